@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"encoding/json"
+	"github.com/atlassian/jec/queue"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+const (
+	poolsPath  = "/admin/pools"
+	reloadPath = "/admin/reload"
+)
+
+type handler struct {
+	poolManager queue.PoolManager
+	reloadFunc  func() error
+	mux         *http.ServeMux
+}
+
+// NewHandler serves the admin endpoints which manage JEC at runtime:
+//
+//	GET  /admin/pools         lists the configurations of the worker pools
+//	PUT  /admin/pools/{name}  resizes the worker pool with the given pool configuration
+//	POST /admin/reload        reloads the configuration file and applies the pool configurations
+func NewHandler(poolManager queue.PoolManager, reloadFunc func() error) http.Handler {
+	h := &handler{
+		poolManager: poolManager,
+		reloadFunc:  reloadFunc,
+		mux:         http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc(poolsPath, h.handlePools)
+	h.mux.HandleFunc(poolsPath+"/", h.handlePool)
+	h.mux.HandleFunc(reloadPath, h.handleReload)
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *handler) handlePools(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method is not allowed.")
+		return
+	}
+	writeJson(w, http.StatusOK, h.poolManager.PoolConfs())
+}
+
+func (h *handler) handlePool(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, poolsPath+"/")
+	if name == "" || strings.Contains(name, "/") {
+		writeError(w, http.StatusNotFound, "Pool is not found.")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		poolConf, ok := h.poolManager.PoolConfs()[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Worker pool["+name+"] does not exist.")
+			return
+		}
+		writeJson(w, http.StatusOK, poolConf)
+	case http.MethodPut:
+		poolConf, ok := h.poolManager.PoolConfs()[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Worker pool["+name+"] does not exist.")
+			return
+		}
+
+		// the fields which are not in the request body keep their current values
+		err := json.NewDecoder(r.Body).Decode(&poolConf)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Pool configuration could not be parsed: "+err.Error())
+			return
+		}
+
+		err = h.poolManager.ResizePool(name, poolConf)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		logrus.Infof("Worker pool[%s] is resized through the admin endpoint.", name)
+		writeJson(w, http.StatusOK, h.poolManager.PoolConfs()[name])
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method is not allowed.")
+	}
+}
+
+func (h *handler) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method is not allowed.")
+		return
+	}
+
+	err := h.reloadFunc()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJson(w, http.StatusOK, h.poolManager.PoolConfs())
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		logrus.Warnf("Admin response could not be written: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJson(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,131 @@
+package admin
+
+import (
+	"encoding/json"
+	"github.com/atlassian/jec/conf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type MockPoolManager struct {
+	PoolConfsFunc   func() map[string]conf.PoolConf
+	ResizePoolFunc  func(name string, poolConf conf.PoolConf) error
+	ReloadPoolsFunc func(configuration *conf.Configuration) error
+}
+
+func (m *MockPoolManager) PoolConfs() map[string]conf.PoolConf {
+	if m.PoolConfsFunc != nil {
+		return m.PoolConfsFunc()
+	}
+	return map[string]conf.PoolConf{}
+}
+
+func (m *MockPoolManager) ResizePool(name string, poolConf conf.PoolConf) error {
+	if m.ResizePoolFunc != nil {
+		return m.ResizePoolFunc(name, poolConf)
+	}
+	return nil
+}
+
+func (m *MockPoolManager) ReloadPools(configuration *conf.Configuration) error {
+	if m.ReloadPoolsFunc != nil {
+		return m.ReloadPoolsFunc(configuration)
+	}
+	return nil
+}
+
+func newPoolManagerTest() *MockPoolManager {
+	poolConfs := map[string]conf.PoolConf{
+		"default": {MaxNumberOfWorker: 4, MinNumberOfWorker: 2, QueueSize: 0, KeepAliveTimeInMillis: 6000},
+	}
+
+	return &MockPoolManager{
+		PoolConfsFunc: func() map[string]conf.PoolConf {
+			return poolConfs
+		},
+		ResizePoolFunc: func(name string, poolConf conf.PoolConf) error {
+			if poolConf.MinNumberOfWorker > poolConf.MaxNumberOfWorker {
+				return errors.New("Min number of workers cannot be greater than max number of workers.")
+			}
+			poolConfs[name] = poolConf
+			return nil
+		},
+	}
+}
+
+func serve(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return recorder
+}
+
+func TestListPools(t *testing.T) {
+	handler := NewHandler(newPoolManagerTest(), nil)
+
+	response := serve(handler, http.MethodGet, "/admin/pools", "")
+
+	poolConfs := map[string]conf.PoolConf{}
+	json.Unmarshal(response.Body.Bytes(), &poolConfs)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, int32(4), poolConfs["default"].MaxNumberOfWorker)
+}
+
+func TestResizePool(t *testing.T) {
+	poolManager := newPoolManagerTest()
+	handler := NewHandler(poolManager, nil)
+
+	response := serve(handler, http.MethodPut, "/admin/pools/default", `{"maxNumberOfWorker": 8}`)
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, int32(8), poolManager.PoolConfs()["default"].MaxNumberOfWorker)
+	assert.Equal(t, int32(2), poolManager.PoolConfs()["default"].MinNumberOfWorker)
+}
+
+func TestResizePoolWithInvalidConf(t *testing.T) {
+	handler := NewHandler(newPoolManagerTest(), nil)
+
+	response := serve(handler, http.MethodPut, "/admin/pools/default", `{"minNumberOfWorker": 8}`)
+
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Contains(t, response.Body.String(), "Min number of workers cannot be greater than max number of workers.")
+}
+
+func TestResizeUnknownPool(t *testing.T) {
+	handler := NewHandler(newPoolManagerTest(), nil)
+
+	response := serve(handler, http.MethodPut, "/admin/pools/slow", `{"maxNumberOfWorker": 8}`)
+
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestReload(t *testing.T) {
+	reloaded := false
+	handler := NewHandler(newPoolManagerTest(), func() error {
+		reloaded = true
+		return nil
+	})
+
+	response := serve(handler, http.MethodPost, "/admin/reload", "")
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.True(t, reloaded)
+
+	response = serve(handler, http.MethodGet, "/admin/reload", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+}
+
+func TestReloadWithError(t *testing.T) {
+	handler := NewHandler(newPoolManagerTest(), func() error {
+		return errors.New("Configuration could not be read.")
+	})
+
+	response := serve(handler, http.MethodPost, "/admin/reload", "")
+
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	assert.Contains(t, response.Body.String(), "Configuration could not be read.")
+}
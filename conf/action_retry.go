@@ -0,0 +1,108 @@
+package conf
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"regexp"
+	"time"
+)
+
+const (
+	actionRetryBaseDelayInMillis = 1000
+	actionRetryMaxDelayInMillis  = 30000
+)
+
+// ActionRetry re-executes the mapped action when it fails. Only the failures with one of the retryable exit codes
+// or with a stderr matching one of the retryable patterns are retried, every failure is retried if neither is given.
+type ActionRetry struct {
+	MaxAttempts             int      `json:"maxAttempts" yaml:"maxAttempts"`
+	BaseDelayInMillis       int64    `json:"baseDelayInMillis" yaml:"baseDelayInMillis"`
+	MaxDelayInMillis        int64    `json:"maxDelayInMillis" yaml:"maxDelayInMillis"`
+	Jitter                  bool     `json:"jitter" yaml:"jitter"`
+	RetryableExitCodes      []int    `json:"retryableExitCodes" yaml:"retryableExitCodes"`
+	RetryableStderrPatterns []string `json:"retryableStderrPatterns" yaml:"retryableStderrPatterns"`
+
+	retryableStderrRegexps []*regexp.Regexp
+}
+
+func (r *ActionRetry) IsEnabled() bool {
+	return r.MaxAttempts > 1
+}
+
+// BackoffPolicy returns the retry policy whose delays are used between the attempts.
+func (r *ActionRetry) BackoffPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:       r.MaxAttempts,
+		BaseDelayInMillis: time.Duration(r.BaseDelayInMillis),
+		MaxDelayInMillis:  time.Duration(r.MaxDelayInMillis),
+		Jitter:            r.Jitter,
+	}
+}
+
+// CompilePatterns compiles the retryable stderr patterns which are matched by IsRetryable, it is called while
+// the configuration is validated.
+func (r *ActionRetry) CompilePatterns() error {
+	var regexps []*regexp.Regexp
+	for _, pattern := range r.RetryableStderrPatterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return errors.Errorf("Retryable stderr pattern[%s] is not valid: %s", pattern, err)
+		}
+		regexps = append(regexps, compiled)
+	}
+	r.retryableStderrRegexps = regexps
+	return nil
+}
+
+// IsRetryable reports whether the failure with the exit code and stderr should be retried.
+func (r *ActionRetry) IsRetryable(exitCode int, stderr string) bool {
+	if len(r.RetryableExitCodes) == 0 && len(r.RetryableStderrPatterns) == 0 {
+		return true
+	}
+
+	for _, retryableExitCode := range r.RetryableExitCodes {
+		if exitCode == retryableExitCode {
+			return true
+		}
+	}
+	for _, retryableStderrRegexp := range r.retryableStderrRegexps {
+		if retryableStderrRegexp.MatchString(stderr) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateActionRetry(actionName string, retry *ActionRetry) error {
+	if retry.MaxAttempts < 0 || retry.BaseDelayInMillis < 0 || retry.MaxDelayInMillis < 0 {
+		return errors.Errorf("Values of the retry of action[%s] cannot be negative.", actionName)
+	}
+	if !retry.IsEnabled() {
+		return nil
+	}
+
+	for _, exitCode := range retry.RetryableExitCodes {
+		if exitCode < 1 || exitCode > 255 {
+			return errors.Errorf("Retryable exit code[%d] of action[%s] should be between 1 and 255.", exitCode, actionName)
+		}
+	}
+	if err := retry.CompilePatterns(); err != nil {
+		return errors.Errorf("Retry of action[%s] is not valid: %s", actionName, err)
+	}
+
+	if retry.BaseDelayInMillis == 0 {
+		logrus.Infof("Base delay of the retry of action[%s] is not set, default value[%d ms.] is set.", actionName, actionRetryBaseDelayInMillis)
+		retry.BaseDelayInMillis = actionRetryBaseDelayInMillis
+	}
+	if retry.MaxDelayInMillis == 0 {
+		retry.MaxDelayInMillis = actionRetryMaxDelayInMillis
+		if retry.MaxDelayInMillis < retry.BaseDelayInMillis {
+			retry.MaxDelayInMillis = retry.BaseDelayInMillis
+		}
+		logrus.Infof("Max delay of the retry of action[%s] is not set, value[%d ms.] is set.", actionName, retry.MaxDelayInMillis)
+	}
+	if retry.MaxDelayInMillis < retry.BaseDelayInMillis {
+		return errors.Errorf("Base delay of the retry of action[%s] cannot be greater than its max delay.", actionName)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package conf
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestActionRetryIsRetryable(t *testing.T) {
+	retry := &ActionRetry{MaxAttempts: 3}
+	assert.True(t, retry.IsRetryable(1, ""))
+
+	retry.RetryableExitCodes = []int{75}
+	retry.RetryableStderrPatterns = []string{"(?i)connection (reset|refused)"}
+	assert.Nil(t, retry.CompilePatterns())
+
+	assert.True(t, retry.IsRetryable(75, ""))
+	assert.True(t, retry.IsRetryable(1, "curl: Connection refused"))
+	assert.False(t, retry.IsRetryable(1, "permission denied"))
+}
+
+func TestValidateActionRetry(t *testing.T) {
+	retry := &ActionRetry{MaxAttempts: 3}
+	assert.Nil(t, validateActionRetry("Create", retry))
+	assert.Equal(t, &ActionRetry{MaxAttempts: 3, BaseDelayInMillis: 1000, MaxDelayInMillis: 30000}, retry)
+
+	retry = &ActionRetry{MaxAttempts: 3, BaseDelayInMillis: 60000}
+	assert.Nil(t, validateActionRetry("Create", retry))
+	assert.EqualValues(t, 60000, retry.MaxDelayInMillis)
+
+	disabled := &ActionRetry{MaxAttempts: 1}
+	assert.Nil(t, validateActionRetry("Create", disabled))
+	assert.Equal(t, &ActionRetry{MaxAttempts: 1}, disabled)
+
+	testCases := []struct {
+		retry ActionRetry
+		err   string
+	}{
+		{ActionRetry{MaxAttempts: -1}, "Values of the retry of action[Create] cannot be negative."},
+		{ActionRetry{MaxAttempts: 2, RetryableExitCodes: []int{0}}, "Retryable exit code[0] of action[Create] should be between 1 and 255."},
+		{ActionRetry{MaxAttempts: 2, RetryableStderrPatterns: []string{"("}}, "Retry of action[Create] is not valid: Retryable stderr pattern[(] is not valid"},
+		{ActionRetry{MaxAttempts: 2, BaseDelayInMillis: 500, MaxDelayInMillis: 100}, "Base delay of the retry of action[Create] cannot be greater than its max delay."},
+	}
+
+	for _, testCase := range testCases {
+		err := validateActionRetry("Create", &testCase.retry)
+		assert.Contains(t, err.Error(), testCase.err)
+	}
+}
@@ -7,27 +7,53 @@ import (
 	"github.com/sirupsen/logrus"
 	"net/url"
 	"strings"
+	"text/template"
 	"time"
 )
 
 type Configuration struct {
 	ActionSpecifications `yaml:",inline"`
-	AppName              string     `json:"appName" yaml:"appName"`
-	ApiKey               string     `json:"apiKey" yaml:"apiKey"`
-	BaseUrl              string     `json:"baseUrl" yaml:"baseUrl"`
-	PollerConf           PollerConf `json:"pollerConf" yaml:"pollerConf"`
-	PoolConf             PoolConf   `json:"poolConf" yaml:"poolConf"`
-	LogLevel             string     `json:"logLevel" yaml:"logLevel"`
+	AppName              string              `json:"appName" yaml:"appName"`
+	ApiKey               string              `json:"apiKey" yaml:"apiKey"`
+	BaseUrl              string              `json:"baseUrl" yaml:"baseUrl"`
+	PollerConf           PollerConf          `json:"pollerConf" yaml:"pollerConf"`
+	PoolConf             PoolConf            `json:"poolConf" yaml:"poolConf"`
+	Pools                map[string]PoolConf `json:"pools" yaml:"pools"`
+	LogLevel             string              `json:"logLevel" yaml:"logLevel"`
+	MessageSigning       MessageSigningConf  `json:"messageSigning" yaml:"messageSigning"`
+	DedupeConf           DedupeConf          `json:"dedupeConf" yaml:"dedupeConf"`
+	SerializationConf    SerializationConf   `json:"serializationConf" yaml:"serializationConf"`
+	RetryConf            RetryConf           `json:"retryConf" yaml:"retryConf"`
+	CircuitBreakerConf   CircuitBreakerConf  `json:"circuitBreakerConf" yaml:"circuitBreakerConf"`
+	TransportConf        TransportConf       `json:"transportConf" yaml:"transportConf"`
+	TokenCacheConf       TokenCacheConf      `json:"tokenCacheConf" yaml:"tokenCacheConf"`
+	Integrations         []IntegrationConf   `json:"integrations" yaml:"integrations"`
+	InstanceConf         InstanceConf        `json:"instanceConf" yaml:"instanceConf"`
+	IntegrationName      string              `json:"-" yaml:"-"`
 	LogrusLevel          logrus.Level
 }
 
 type ActionSpecifications struct {
 	ActionMappings ActionMappings `json:"actionMappings" yaml:"actionMappings"`
+	Routes         []Route        `json:"routes" yaml:"routes"`
+	FallbackAction ActionName     `json:"fallbackAction" yaml:"fallbackAction"`
 	GlobalFlags    Flags          `json:"globalFlags" yaml:"globalFlags"`
 	GlobalArgs     []string       `json:"globalArgs" yaml:"globalArgs"`
 	GlobalEnv      []string       `json:"globalEnv" yaml:"globalEnv"`
 }
 
+// Route dispatches the requests of the action to the mapped action when the payload matches all of the
+// conditions which are set. Routes are evaluated in order, the first matching route wins and the mapped action
+// with the same name as the action is used if none of them matches.
+type Route struct {
+	Action       string     `json:"action" yaml:"action"`
+	EntityType   string     `json:"entityType" yaml:"entityType"`
+	Tags         []string   `json:"tags" yaml:"tags"`
+	Priority     string     `json:"priority" yaml:"priority"`
+	ExtraField   string     `json:"extraField" yaml:"extraField"`
+	MappedAction ActionName `json:"mappedAction" yaml:"mappedAction"`
+}
+
 type ActionName string
 type ActionMappings map[ActionName]MappedAction
 
@@ -42,16 +68,35 @@ func (m ActionMappings) GitActions() []git.Options {
 }
 
 type MappedAction struct {
-	Type       string      `json:"type" yaml:"type"`
-	SourceType string      `json:"sourceType" yaml:"sourceType"`
-	GitOptions git.Options `json:"gitOptions" yaml:"gitOptions"`
-	Filepath   string      `json:"filepath" yaml:"filepath"`
-	Flags      Flags       `json:"flags" yaml:"flags"`
-	Args       []string    `json:"args" yaml:"args"`
-	Env        []string    `json:"env" yaml:"env"`
-	Stdout     string      `json:"stdout" yaml:"stdout"`
-	Stderr     string      `json:"stderr" yaml:"stderr"`
+	Type                   string            `json:"type" yaml:"type"`
+	SourceType             string            `json:"sourceType" yaml:"sourceType"`
+	GitOptions             git.Options       `json:"gitOptions" yaml:"gitOptions"`
+	Filepath               string            `json:"filepath" yaml:"filepath"`
+	Flags                  Flags             `json:"flags" yaml:"flags"`
+	Args                   []string          `json:"args" yaml:"args"`
+	Env                    []string          `json:"env" yaml:"env"`
+	Stdout                 string            `json:"stdout" yaml:"stdout"`
+	Stderr                 string            `json:"stderr" yaml:"stderr"`
+	MaxMessageAgeInSeconds int64             `json:"maxMessageAgeInSeconds" yaml:"maxMessageAgeInSeconds"`
+	MaxConcurrency         int32             `json:"maxConcurrency" yaml:"maxConcurrency"`
+	RateLimit              RateLimit         `json:"rateLimit" yaml:"rateLimit"`
+	Pool                   string            `json:"pool" yaml:"pool"`
+	Priority               string            `json:"priority" yaml:"priority"`
+	RequiredLabels         map[string]string `json:"requiredLabels" yaml:"requiredLabels"`
+	Steps                  []WorkflowStep    `json:"steps" yaml:"steps"`
+	Targets                []ActionName      `json:"targets" yaml:"targets"`
+	DeadlineInSeconds      int64             `json:"deadlineInSeconds" yaml:"deadlineInSeconds"`
+	Retry                  ActionRetry       `json:"retry" yaml:"retry"`
+
+	templates map[string]*template.Template
 }
+
+// RateLimit is a token bucket which is refilled with PerSecond tokens every second and holds at most Burst tokens.
+type RateLimit struct {
+	PerSecond float64 `json:"perSecond" yaml:"perSecond"`
+	Burst     int32   `json:"burst" yaml:"burst"`
+}
+
 type httpFields struct {
 	Url     string            `json:"url" yaml:"url"`
 	Headers map[string]string `json:"headers" yaml:"headers"`
@@ -160,12 +205,78 @@ type PollerConf struct {
 	PollingWaitIntervalInMillis time.Duration `json:"pollingWaitIntervalInMillis" yaml:"pollingWaitIntervalInMillis"`
 	VisibilityTimeoutInSeconds  int64         `json:"visibilityTimeoutInSeconds" yaml:"visibilityTimeoutInSeconds"`
 	MaxNumberOfMessages         int64         `json:"maxNumberOfMessages" yaml:"maxNumberOfMessages"`
+	WaitTimeInSeconds           int64         `json:"waitTimeInSeconds" yaml:"waitTimeInSeconds"`
+	PrefetchBufferSize          int64         `json:"prefetchBufferSize" yaml:"prefetchBufferSize"`
 }
 
+const (
+	HmacSha256SigningAlgorithm = "hmac-sha256"
+	RsaSha256SigningAlgorithm  = "rsa-sha256"
+)
+
+type MessageSigningConf struct {
+	Algorithm         string `json:"algorithm" yaml:"algorithm"`
+	Key               string `json:"key" yaml:"key"`
+	PublicKeyFilepath string `json:"publicKeyFilepath" yaml:"publicKeyFilepath"`
+}
+
+type DedupeConf struct {
+	Enabled      bool   `json:"enabled" yaml:"enabled"`
+	Filepath     string `json:"filepath" yaml:"filepath"`
+	TtlInSeconds int64  `json:"ttlInSeconds" yaml:"ttlInSeconds"`
+}
+
+type TokenCacheConf struct {
+	Enabled  bool   `json:"enabled" yaml:"enabled"`
+	Filepath string `json:"filepath" yaml:"filepath"`
+}
+
+const (
+	EntityIdSerializationKey   = "entityId"
+	EntityTypeSerializationKey = "entityType"
+	ActionSerializationKey     = "action"
+)
+
+type SerializationConf struct {
+	Enabled bool   `json:"enabled" yaml:"enabled"`
+	Key     string `json:"key" yaml:"key"`
+}
+
+// RetryConf holds separate retry policies for the token requests and the action result callbacks.
+type RetryConf struct {
+	Token    RetryPolicy `json:"token" yaml:"token"`
+	Callback RetryPolicy `json:"callback" yaml:"callback"`
+}
+
+type RetryPolicy struct {
+	MaxAttempts            int           `json:"maxAttempts" yaml:"maxAttempts"`
+	BaseDelayInMillis      time.Duration `json:"baseDelayInMillis" yaml:"baseDelayInMillis"`
+	MaxDelayInMillis       time.Duration `json:"maxDelayInMillis" yaml:"maxDelayInMillis"`
+	TimeoutInSeconds       time.Duration `json:"timeoutInSeconds" yaml:"timeoutInSeconds"`
+	Jitter                 bool          `json:"jitter" yaml:"jitter"`
+	RetryableStatusCodes   []int         `json:"retryableStatusCodes" yaml:"retryableStatusCodes"`
+	RetryOnConnectionError bool          `json:"retryOnConnectionError" yaml:"retryOnConnectionError"`
+	HonorRetryAfter        bool          `json:"honorRetryAfter" yaml:"honorRetryAfter"`
+}
+
+// CircuitBreakerConf configures the circuit breaker which is shared by the requests to Jira Service Management.
+// The results which cannot be sent while the breaker is open are kept in the outbox file and sent later.
+type CircuitBreakerConf struct {
+	Enabled               bool   `json:"enabled" yaml:"enabled"`
+	FailureThreshold      int32  `json:"failureThreshold" yaml:"failureThreshold"`
+	OpenDurationInSeconds int64  `json:"openDurationInSeconds" yaml:"openDurationInSeconds"`
+	HalfOpenMaxCalls      int32  `json:"halfOpenMaxCalls" yaml:"halfOpenMaxCalls"`
+	OutboxFilepath        string `json:"outboxFilepath" yaml:"outboxFilepath"`
+}
+
+// DefaultPoolName is the name of the pool configured by PoolConf, the actions without a pool run in it.
+const DefaultPoolName = "default"
+
 type PoolConf struct {
 	MaxNumberOfWorker        int32         `json:"maxNumberOfWorker" yaml:"maxNumberOfWorker"`
 	MinNumberOfWorker        int32         `json:"minNumberOfWorker" yaml:"minNumberOfWorker"`
 	QueueSize                int32         `json:"queueSize" yaml:"queueSize"`
 	KeepAliveTimeInMillis    time.Duration `json:"keepAliveTimeInMillis" yaml:"keepAliveTimeInMillis"`
 	MonitoringPeriodInMillis time.Duration `json:"monitoringPeriodInMillis" yaml:"monitoringPeriodInMillis"`
+	AgingIntervalInMillis    time.Duration `json:"agingIntervalInMillis" yaml:"agingIntervalInMillis"`
 }
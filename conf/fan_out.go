@@ -0,0 +1,38 @@
+package conf
+
+import "github.com/pkg/errors"
+
+// FanOutActionType is the type of the mapped actions which run their target actions concurrently,
+// fan-out actions handle the requests of custom actions.
+const FanOutActionType = "fanOut"
+
+// validateFanOutTargets requires the targets to need only the labels which are required by the fan-out action,
+// since the instances are selected by the labels of the fan-out action.
+func validateFanOutTargets(targets []ActionName, deadlineInSeconds int64, requiredLabels map[string]string, mappings ActionMappings) error {
+	if len(targets) == 0 {
+		return errors.New("Targets are empty.")
+	}
+	if deadlineInSeconds < 0 {
+		return errors.New("Deadline cannot be negative.")
+	}
+
+	names := make(map[ActionName]struct{}, len(targets))
+	for _, target := range targets {
+		if _, ok := names[target]; ok {
+			return errors.Errorf("Target[%s] is not unique.", target)
+		}
+		names[target] = struct{}{}
+
+		action, ok := mappings[target]
+		if !ok {
+			return errors.Errorf("Target[%s] is not found in the action mappings.", target)
+		}
+		if action.Type == WorkflowActionType || action.Type == FanOutActionType {
+			return errors.Errorf("Target[%s] cannot be a workflow or a fan-out action.", target)
+		}
+		if !(InstanceConf{Labels: requiredLabels}).HasLabels(action.RequiredLabels) {
+			return errors.Errorf("Required labels of target[%s] should also be required by the fan-out action.", target)
+		}
+	}
+	return nil
+}
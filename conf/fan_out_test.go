@@ -0,0 +1,33 @@
+package conf
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidateFanOutTargets(t *testing.T) {
+	mappings := copyActionMappings(mockActionMappings)
+	mappings["Gpu"] = MappedAction{Type: "custom", RequiredLabels: map[string]string{"gpu": "true"}}
+	mappings["Workflow"] = MappedAction{Type: WorkflowActionType, Steps: []WorkflowStep{{Name: "step", Action: "Create"}}}
+
+	assert.Nil(t, validateFanOutTargets([]ActionName{"Create", "Close"}, 30, nil, mappings))
+	assert.Nil(t, validateFanOutTargets([]ActionName{"Create", "Gpu"}, 30, map[string]string{"gpu": "true", "zone": "eu"}, mappings))
+
+	testCases := []struct {
+		targets  []ActionName
+		deadline int64
+		err      string
+	}{
+		{nil, 0, "Targets are empty."},
+		{[]ActionName{"Create"}, -1, "Deadline cannot be negative."},
+		{[]ActionName{"Create", "Create"}, 0, "Target[Create] is not unique."},
+		{[]ActionName{"Missing"}, 0, "Target[Missing] is not found in the action mappings."},
+		{[]ActionName{"Workflow"}, 0, "Target[Workflow] cannot be a workflow or a fan-out action."},
+		{[]ActionName{"Gpu"}, 0, "Required labels of target[Gpu] should also be required by the fan-out action."},
+	}
+
+	for _, testCase := range testCases {
+		err := validateFanOutTargets(testCase.targets, testCase.deadline, nil, mappings)
+		assert.EqualError(t, err, testCase.err)
+	}
+}
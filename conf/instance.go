@@ -0,0 +1,65 @@
+package conf
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+)
+
+// InstanceConf identifies the JEC instance among the instances which serve the same integration.
+// The id is the hostname unless it is configured.
+type InstanceConf struct {
+	Id     string            `json:"id" yaml:"id"`
+	Labels map[string]string `json:"labels" yaml:"labels"`
+}
+
+// HasLabels reports whether the instance has all of the required labels with the same values.
+func (i InstanceConf) HasLabels(requiredLabels map[string]string) bool {
+	for key, value := range requiredLabels {
+		if labelValue, ok := i.Labels[key]; !ok || labelValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatLabels returns the labels as comma separated key=value pairs ordered by their keys.
+func (i InstanceConf) FormatLabels() string {
+	pairs := make([]string, 0, len(i.Labels))
+	for key, value := range i.Labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func validateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if key == "" {
+			return errors.New("Label key cannot be empty.")
+		}
+		if strings.ContainsAny(key, ",=") || strings.ContainsAny(value, ",=") {
+			return errors.Errorf("Label[%s] cannot contain \",\" or \"=\".", key)
+		}
+	}
+	return nil
+}
+
+type instanceLogHook struct {
+	instanceId string
+}
+
+// NewInstanceLogHook adds the instance id to all of the log entries.
+func NewInstanceLogHook(instanceId string) logrus.Hook {
+	return &instanceLogHook{instanceId: instanceId}
+}
+
+func (h *instanceLogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *instanceLogHook) Fire(entry *logrus.Entry) error {
+	entry.Data["instanceId"] = h.instanceId
+	return nil
+}
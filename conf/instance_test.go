@@ -0,0 +1,61 @@
+package conf
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+)
+
+func TestInstanceHasLabels(t *testing.T) {
+	instanceConf := InstanceConf{Labels: map[string]string{"zone": "eu-1", "env": "prod"}}
+
+	assert.True(t, instanceConf.HasLabels(nil))
+	assert.True(t, instanceConf.HasLabels(map[string]string{"zone": "eu-1"}))
+	assert.False(t, instanceConf.HasLabels(map[string]string{"zone": "us-1"}))
+	assert.False(t, instanceConf.HasLabels(map[string]string{"tier": "gold"}))
+
+	assert.Equal(t, "env=prod,zone=eu-1", instanceConf.FormatLabels())
+}
+
+func TestValidateLabels(t *testing.T) {
+	conf := *mockConf
+	conf.ActionMappings = copyActionMappings(mockActionMappings)
+	conf.InstanceConf = InstanceConf{Id: "jec-1", Labels: map[string]string{"zone": "eu-1,us-1"}}
+
+	err := validate(&conf)
+	assert.EqualError(t, err, "Instance labels are not valid: Label[zone] cannot contain \",\" or \"=\".")
+
+	conf.InstanceConf.Labels = map[string]string{"zone": "eu-1"}
+	action := conf.ActionMappings["Create"]
+	action.RequiredLabels = map[string]string{"": "eu-1"}
+	conf.ActionMappings["Create"] = action
+
+	err = validate(&conf)
+	assert.EqualError(t, err, "Required labels of action[Create] are not valid: Label key cannot be empty.")
+}
+
+func TestInstanceLogHook(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.AddHook(NewInstanceLogHook("jec-1"))
+
+	var entry *logrus.Entry
+	logger.AddHook(&entryRecorder{record: func(e *logrus.Entry) { entry = e }})
+
+	logger.Info("test")
+	assert.Equal(t, "jec-1", entry.Data["instanceId"])
+}
+
+type entryRecorder struct {
+	record func(entry *logrus.Entry)
+}
+
+func (r *entryRecorder) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (r *entryRecorder) Fire(entry *logrus.Entry) error {
+	r.record(entry)
+	return nil
+}
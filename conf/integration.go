@@ -0,0 +1,134 @@
+package conf
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	fpath "path/filepath"
+	"strings"
+)
+
+// IntegrationConf configures one of the Jira Service Management integrations served by the same JEC process.
+// The global flags, args and env of the configuration are inherited by all of the integrations. Integrations share
+// the worker pools of the configuration unless a pool configuration is given for the integration.
+type IntegrationConf struct {
+	ActionSpecifications `yaml:",inline"`
+	Name                 string   `json:"name" yaml:"name"`
+	ApiKey               string   `json:"apiKey" yaml:"apiKey"`
+	BaseUrl              string   `json:"baseUrl" yaml:"baseUrl"`
+	PoolConf             PoolConf `json:"poolConf" yaml:"poolConf"`
+}
+
+func (i *IntegrationConf) HasDedicatedPool() bool {
+	return i.PoolConf != PoolConf{}
+}
+
+// IntegrationConfigurations returns a configuration for each of the integrations, or the configuration
+// itself if no integration is configured.
+func (c *Configuration) IntegrationConfigurations() []*Configuration {
+	if len(c.Integrations) == 0 {
+		return []*Configuration{c}
+	}
+
+	configurations := make([]*Configuration, 0, len(c.Integrations))
+	for _, integration := range c.Integrations {
+		configuration := *c
+		configuration.Integrations = nil
+		configuration.IntegrationName = integration.Name
+		configuration.ApiKey = integration.ApiKey
+		configuration.BaseUrl = integration.BaseUrl
+		configuration.ActionSpecifications = integration.ActionSpecifications
+		if integration.HasDedicatedPool() {
+			configuration.PoolConf = integration.PoolConf
+			configuration.Pools = copyPools(c.Pools)
+		}
+		configuration.DedupeConf.Filepath = integrationFilepath(c.DedupeConf.Filepath, integration.Name)
+		configuration.TokenCacheConf.Filepath = integrationFilepath(c.TokenCacheConf.Filepath, integration.Name)
+		configurations = append(configurations, &configuration)
+	}
+	return configurations
+}
+
+// integrationFilepath adds the integration name to the filename, since the files cannot be shared by the integrations.
+func integrationFilepath(filepath, integrationName string) string {
+	if filepath == "" {
+		return filepath
+	}
+	extension := fpath.Ext(filepath)
+	return strings.TrimSuffix(filepath, extension) + "-" + integrationName + extension
+}
+
+func copyPools(pools map[string]PoolConf) map[string]PoolConf {
+	if pools == nil {
+		return nil
+	}
+	copyPools := make(map[string]PoolConf, len(pools))
+	for name, poolConf := range pools {
+		copyPools[name] = poolConf
+	}
+	return copyPools
+}
+
+func validateIntegrations(conf *Configuration) error {
+	if len(conf.ActionMappings) != 0 || len(conf.Routes) != 0 || conf.FallbackAction != "" {
+		return errors.New("Action mappings, routes and fallback action should be configured in the integrations when integrations are configured.")
+	}
+
+	names := make(map[string]struct{}, len(conf.Integrations))
+	for index := range conf.Integrations {
+		integration := &conf.Integrations[index]
+
+		if integration.Name == "" {
+			return errors.Errorf("Name of integration[%d] is empty.", index)
+		}
+		if strings.ContainsAny(integration.Name, ":/") {
+			return errors.Errorf("Name of integration[%s] cannot contain \":\" or \"/\".", integration.Name)
+		}
+		if _, ok := names[integration.Name]; ok {
+			return errors.Errorf("Integration name[%s] is not unique.", integration.Name)
+		}
+		names[integration.Name] = struct{}{}
+
+		if integration.ApiKey == "" {
+			return errors.Errorf("ApiKey of integration[%s] is not found in the configuration file.", integration.Name)
+		}
+		if integration.BaseUrl == "" {
+			logrus.Infof("BaseUrl of integration[%s] is not found in the configuration file, url[%s] is set.", integration.Name, conf.BaseUrl)
+			integration.BaseUrl = conf.BaseUrl
+		}
+		if len(integration.ActionMappings) == 0 {
+			return errors.Errorf("Action mappings configuration of integration[%s] is not found in the configuration file.", integration.Name)
+		}
+
+		err := validateActionSpecifications(&integration.ActionSpecifications, conf.Pools)
+		if err != nil {
+			return errors.Errorf("Integration[%s] is not valid: %s", integration.Name, err)
+		}
+	}
+	return nil
+}
+
+// prepareIntegrations makes the action specifications of the integrations ready to execute,
+// the global flags, args and env of the configuration are added to the ones of the integrations.
+func (c *Configuration) prepareIntegrations() {
+	for index := range c.Integrations {
+		integration := &c.Integrations[index]
+
+		globalFlags := make(Flags, len(c.GlobalFlags)+len(integration.GlobalFlags))
+		for name, value := range c.GlobalFlags {
+			globalFlags[name] = value
+		}
+		for name, value := range integration.GlobalFlags {
+			globalFlags[name] = value
+		}
+		integration.GlobalFlags = globalFlags
+
+		integration.GlobalArgs = append(
+			append(defaultFlags(integration.ApiKey, integration.BaseUrl, c.LogLevel), c.GlobalArgs...),
+			integration.GlobalArgs...,
+		)
+		integration.GlobalEnv = append(append([]string{}, c.GlobalEnv...), integration.GlobalEnv...)
+
+		addHomeDirPrefixToActionMappings(integration.ActionMappings)
+		chmodLocalActions(integration.ActionMappings, 0700)
+	}
+}
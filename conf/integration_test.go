@@ -0,0 +1,101 @@
+package conf
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newIntegrationsConf() *Configuration {
+	return &Configuration{
+		BaseUrl:    DefaultBaseUrl,
+		PoolConf:   PoolConf{MaxNumberOfWorker: 8},
+		DedupeConf: DedupeConf{Enabled: true, Filepath: "/jec/dedupe-store.jsonl"},
+		ActionSpecifications: ActionSpecifications{
+			GlobalFlags: Flags{"region": "eu"},
+			GlobalArgs:  []string{"-global"},
+		},
+		Integrations: []IntegrationConf{
+			{
+				Name:   "billing",
+				ApiKey: "billingApiKey",
+				ActionSpecifications: ActionSpecifications{
+					ActionMappings: copyActionMappings(mockActionMappings),
+					GlobalFlags:    Flags{"region": "us"},
+				},
+			},
+			{
+				Name:     "payments",
+				ApiKey:   "paymentsApiKey",
+				BaseUrl:  "https://api.eu.atlassian.com",
+				PoolConf: PoolConf{MaxNumberOfWorker: 2},
+				ActionSpecifications: ActionSpecifications{
+					ActionMappings: copyActionMappings(mockActionMappings),
+				},
+			},
+		},
+	}
+}
+
+func TestValidateIntegrations(t *testing.T) {
+	conf := newIntegrationsConf()
+
+	err := validate(conf)
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultBaseUrl, conf.Integrations[0].BaseUrl)
+
+	conf.Integrations[1].Name = "billing"
+	err = validate(conf)
+	assert.EqualError(t, err, "Integration name[billing] is not unique.")
+
+	conf.Integrations[1].Name = "payments"
+	conf.Integrations[1].ApiKey = ""
+	err = validate(conf)
+	assert.EqualError(t, err, "ApiKey of integration[payments] is not found in the configuration file.")
+
+	conf.Integrations[1].ApiKey = "paymentsApiKey"
+	conf.ActionMappings = copyActionMappings(mockActionMappings)
+	err = validate(conf)
+	assert.EqualError(t, err, "Action mappings, routes and fallback action should be configured in the integrations when integrations are configured.")
+}
+
+func TestValidateActionMappingsOfIntegration(t *testing.T) {
+	conf := newIntegrationsConf()
+
+	action := conf.Integrations[0].ActionMappings["Create"]
+	action.Pool = "slow"
+	conf.Integrations[0].ActionMappings["Create"] = action
+
+	err := validate(conf)
+	assert.EqualError(t, err, "Integration[billing] is not valid: Pool[slow] of action[Create] is not found in the pools configuration.")
+}
+
+func TestIntegrationConfigurations(t *testing.T) {
+	conf := newIntegrationsConf()
+	validate(conf)
+	conf.prepareIntegrations()
+
+	configurations := conf.IntegrationConfigurations()
+	assert.Equal(t, 2, len(configurations))
+
+	billing := configurations[0]
+	assert.Equal(t, "billing", billing.IntegrationName)
+	assert.Equal(t, "billingApiKey", billing.ApiKey)
+	assert.Equal(t, DefaultBaseUrl, billing.BaseUrl)
+	assert.Equal(t, int32(8), billing.PoolConf.MaxNumberOfWorker)
+	assert.Equal(t, "/jec/dedupe-store-billing.jsonl", billing.DedupeConf.Filepath)
+	assert.Equal(t, Flags{"region": "us"}, billing.GlobalFlags)
+	assert.Equal(t, []string{"-apiKey", "billingApiKey", "-jsmUrl", DefaultBaseUrl, "-logLevel", "INFO", "-global"}, billing.GlobalArgs)
+	assert.Nil(t, billing.Integrations)
+
+	payments := configurations[1]
+	assert.Equal(t, "https://api.eu.atlassian.com", payments.BaseUrl)
+	assert.Equal(t, int32(2), payments.PoolConf.MaxNumberOfWorker)
+	assert.Equal(t, Flags{"region": "eu"}, payments.GlobalFlags)
+}
+
+func TestIntegrationConfigurationsWithoutIntegrations(t *testing.T) {
+	conf := *mockConf
+
+	configurations := conf.IntegrationConfigurations()
+	assert.Equal(t, []*Configuration{&conf}, configurations)
+}
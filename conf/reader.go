@@ -6,6 +6,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -20,6 +21,11 @@ var readFileFromGitFunc = readFileFromGit
 var readFileFromLocalFunc = readFileFromLocal
 
 var defaultConfFilepath = filepath.Join("~", "jec", "config.json")
+var priorityPattern = regexp.MustCompile(`^[Pp][1-5]$`)
+
+var defaultDedupeFilepath = filepath.Join("~", "jec", "dedupe-store.jsonl")
+var defaultOutboxFilepath = filepath.Join("~", "jec", "result-outbox.jsonl")
+var defaultTokenCacheFilepath = filepath.Join("~", "jec", "token-cache.json")
 
 func Read() (*Configuration, error) {
 
@@ -33,15 +39,54 @@ func Read() (*Configuration, error) {
 		conf.ApiKey = os.Getenv("JEC_API_KEY")
 	}
 
+	if os.Getenv("JEC_INSTANCE_ID") != "" {
+		conf.InstanceConf.Id = os.Getenv("JEC_INSTANCE_ID")
+	}
+
+	if os.Getenv("JEC_PROXY_PASSWORD") != "" {
+		conf.TransportConf.ProxyPassword = os.Getenv("JEC_PROXY_PASSWORD")
+	}
+
+	if os.Getenv("JEC_MESSAGE_SIGNING_KEY") != "" {
+		conf.MessageSigning.Key = os.Getenv("JEC_MESSAGE_SIGNING_KEY")
+	}
+
+	// transport files are loaded while validating
+	conf.TransportConf.CaBundleFilepath = addHomeDirPrefix(conf.TransportConf.CaBundleFilepath)
+	conf.TransportConf.ClientCertificateFilepath = addHomeDirPrefix(conf.TransportConf.ClientCertificateFilepath)
+	conf.TransportConf.ClientKeyFilepath = addHomeDirPrefix(conf.TransportConf.ClientKeyFilepath)
+
 	err = validate(conf)
 	if err != nil {
 		return nil, err
 	}
 
-	addHomeDirPrefixToActionMappings(conf.ActionMappings)
-	chmodLocalActions(conf.ActionMappings, 0700)
+	if conf.DedupeConf.Enabled && conf.DedupeConf.Filepath == "" {
+		logrus.Infof("Dedupe store filepath is not found in the configuration file, default filepath[%s] is set.", defaultDedupeFilepath)
+		conf.DedupeConf.Filepath = defaultDedupeFilepath
+	}
+	conf.DedupeConf.Filepath = addHomeDirPrefix(conf.DedupeConf.Filepath)
+
+	if conf.CircuitBreakerConf.Enabled && conf.CircuitBreakerConf.OutboxFilepath == "" {
+		logrus.Infof("Result outbox filepath is not found in the configuration file, default filepath[%s] is set.", defaultOutboxFilepath)
+		conf.CircuitBreakerConf.OutboxFilepath = defaultOutboxFilepath
+	}
+	conf.CircuitBreakerConf.OutboxFilepath = addHomeDirPrefix(conf.CircuitBreakerConf.OutboxFilepath)
+
+	if conf.TokenCacheConf.Enabled && conf.TokenCacheConf.Filepath == "" {
+		logrus.Infof("Token cache filepath is not found in the configuration file, default filepath[%s] is set.", defaultTokenCacheFilepath)
+		conf.TokenCacheConf.Filepath = defaultTokenCacheFilepath
+	}
+	conf.TokenCacheConf.Filepath = addHomeDirPrefix(conf.TokenCacheConf.Filepath)
+
+	if len(conf.Integrations) != 0 {
+		conf.prepareIntegrations()
+	} else {
+		addHomeDirPrefixToActionMappings(conf.ActionMappings)
+		chmodLocalActions(conf.ActionMappings, 0700)
 
-	conf.addDefaultFlags()
+		conf.addDefaultFlags()
+	}
 
 	return conf, nil
 }
@@ -82,14 +127,15 @@ func readFileFromSource(confSourceType string) (*Configuration, error) {
 }
 
 func (c *Configuration) addDefaultFlags() {
-	c.GlobalArgs = append(
-		[]string{
-			"-apiKey", c.ApiKey,
-			"-jsmUrl", c.BaseUrl,
-			"-logLevel", strings.ToUpper(c.LogLevel),
-		},
-		c.GlobalArgs...,
-	)
+	c.GlobalArgs = append(defaultFlags(c.ApiKey, c.BaseUrl, c.LogLevel), c.GlobalArgs...)
+}
+
+func defaultFlags(apiKey, baseUrl, logLevel string) []string {
+	return []string{
+		"-apiKey", apiKey,
+		"-jsmUrl", baseUrl,
+		"-logLevel", strings.ToUpper(logLevel),
+	}
 }
 
 func validate(conf *Configuration) error {
@@ -97,33 +143,79 @@ func validate(conf *Configuration) error {
 	if conf == nil || conf == (&Configuration{}) {
 		return errors.New("The configuration is empty.")
 	}
-	if conf.ApiKey == "" {
-		return errors.New("ApiKey is not found in the configuration file.")
-	}
 	if conf.BaseUrl == "" {
 		conf.BaseUrl = DefaultBaseUrl
 		logrus.Infof("BaseUrl is not found in the configuration file, default url[%s] is set.", DefaultBaseUrl)
 	}
 
-	if len(conf.ActionMappings) == 0 {
-		return errors.New("Action mappings configuration is not found in the configuration file.")
+	if len(conf.Integrations) != 0 {
+		err := validateIntegrations(conf)
+		if err != nil {
+			return err
+		}
 	} else {
-		for actionName, action := range conf.ActionMappings {
-			if action.SourceType != LocalSourceType &&
-				action.SourceType != GitSourceType {
-				return errors.Errorf("Action source type of action[%s] should be either local or git.", actionName)
-			} else {
-				if action.Filepath == "" {
-					return errors.Errorf("Filepath of action[%s] is empty.", actionName)
-				}
-				if action.SourceType == GitSourceType &&
-					action.GitOptions == (git.Options{}) {
-					return errors.Errorf("Git options of action[%s] is empty.", actionName)
-				}
-			}
+		if conf.ApiKey == "" {
+			return errors.New("ApiKey is not found in the configuration file.")
+		}
+		if len(conf.ActionMappings) == 0 {
+			return errors.New("Action mappings configuration is not found in the configuration file.")
+		}
+		err := validateActionSpecifications(&conf.ActionSpecifications, conf.Pools)
+		if err != nil {
+			return err
 		}
 	}
 
+	if _, ok := conf.Pools[DefaultPoolName]; ok {
+		return errors.Errorf("Pool name[%s] is reserved for the pool configured by poolConf.", DefaultPoolName)
+	}
+
+	err := validateMessageSigning(&conf.MessageSigning)
+	if err != nil {
+		return err
+	}
+
+	err = validateSerialization(&conf.SerializationConf)
+	if err != nil {
+		return err
+	}
+
+	err = validateRetryPolicy("token", &conf.RetryConf.Token)
+	if err != nil {
+		return err
+	}
+
+	err = validateRetryPolicy("callback", &conf.RetryConf.Callback)
+	if err != nil {
+		return err
+	}
+
+	if !conf.TransportConf.IsEmpty() {
+		_, err = conf.TransportConf.HttpTransport()
+		if err != nil {
+			return err
+		}
+	}
+
+	if conf.InstanceConf.Id == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return errors.Errorf("Instance id is not found in the configuration file and hostname could not be read: %s", err)
+		}
+		logrus.Infof("Instance id is not found in the configuration file, hostname[%s] is set.", hostname)
+		conf.InstanceConf.Id = hostname
+	}
+
+	err = validateLabels(conf.InstanceConf.Labels)
+	if err != nil {
+		return errors.Errorf("Instance labels are not valid: %s", err)
+	}
+
+	breakerConf := conf.CircuitBreakerConf
+	if breakerConf.FailureThreshold < 0 || breakerConf.OpenDurationInSeconds < 0 || breakerConf.HalfOpenMaxCalls < 0 {
+		return errors.New("Values of the circuit breaker configuration cannot be negative.")
+	}
+
 	level, err := logrus.ParseLevel(conf.LogLevel)
 	if err != nil {
 		conf.LogrusLevel = logrus.InfoLevel
@@ -134,3 +226,144 @@ func validate(conf *Configuration) error {
 
 	return nil
 }
+
+func validateActionSpecifications(actionSpecs *ActionSpecifications, pools map[string]PoolConf) error {
+	err := validateActionMappings(actionSpecs.ActionMappings, pools)
+	if err != nil {
+		return err
+	}
+
+	err = validateRoutes(actionSpecs.Routes, actionSpecs.ActionMappings)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := actionSpecs.ActionMappings[actionSpecs.FallbackAction]; !ok && actionSpecs.FallbackAction != "" {
+		return errors.Errorf("Fallback action[%s] is not found in the action mappings.", actionSpecs.FallbackAction)
+	}
+	return nil
+}
+
+func validateActionMappings(mappings ActionMappings, pools map[string]PoolConf) error {
+	for actionName, action := range mappings {
+		if action.Type == WorkflowActionType {
+			if err := validateWorkflowSteps(action.Steps, action.RequiredLabels, mappings, map[string]struct{}{}); err != nil {
+				return errors.Errorf("Steps of workflow[%s] are not valid: %s", actionName, err)
+			}
+		} else if action.Type == FanOutActionType {
+			if err := validateFanOutTargets(action.Targets, action.DeadlineInSeconds, action.RequiredLabels, mappings); err != nil {
+				return errors.Errorf("Fan-out action[%s] is not valid: %s", actionName, err)
+			}
+		} else if action.SourceType != LocalSourceType &&
+			action.SourceType != GitSourceType {
+			return errors.Errorf("Action source type of action[%s] should be either local or git.", actionName)
+		} else {
+			if action.Filepath == "" {
+				return errors.Errorf("Filepath of action[%s] is empty.", actionName)
+			}
+			if action.SourceType == GitSourceType &&
+				action.GitOptions == (git.Options{}) {
+				return errors.Errorf("Git options of action[%s] is empty.", actionName)
+			}
+		}
+		if action.MaxMessageAgeInSeconds < 0 {
+			return errors.Errorf("Max message age of action[%s] cannot be negative.", actionName)
+		}
+		if action.MaxConcurrency < 0 {
+			return errors.Errorf("Max concurrency of action[%s] cannot be negative.", actionName)
+		}
+		if action.RateLimit.PerSecond < 0 || action.RateLimit.Burst < 0 {
+			return errors.Errorf("Rate limit of action[%s] cannot be negative.", actionName)
+		}
+		if action.Priority != "" && !priorityPattern.MatchString(action.Priority) {
+			return errors.Errorf("Priority[%s] of action[%s] should be one of P1, P2, P3, P4 and P5.", action.Priority, actionName)
+		}
+		if _, ok := pools[action.Pool]; !ok && action.Pool != "" && action.Pool != DefaultPoolName {
+			return errors.Errorf("Pool[%s] of action[%s] is not found in the pools configuration.", action.Pool, actionName)
+		}
+		if err := validateLabels(action.RequiredLabels); err != nil {
+			return errors.Errorf("Required labels of action[%s] are not valid: %s", actionName, err)
+		}
+		if err := validateTemplates(&action); err != nil {
+			return errors.Errorf("Templates of action[%s] are not valid: %s", actionName, err)
+		}
+		if err := validateActionRetry(string(actionName), &action.Retry); err != nil {
+			return err
+		}
+		mappings[actionName] = action
+	}
+	return nil
+}
+
+func validateRoutes(routes []Route, mappings ActionMappings) error {
+	for index, route := range routes {
+		if route.Action == "" {
+			return errors.Errorf("Action of route[%d] is empty.", index)
+		}
+		if route.MappedAction == "" {
+			return errors.Errorf("Mapped action of route[%d] is empty.", index)
+		}
+		if _, ok := mappings[route.MappedAction]; !ok {
+			return errors.Errorf("Mapped action[%s] of route[%d] is not found in the action mappings.", route.MappedAction, index)
+		}
+		if route.Priority != "" && !priorityPattern.MatchString(route.Priority) {
+			return errors.Errorf("Priority[%s] of route[%d] should be one of P1, P2, P3, P4 and P5.", route.Priority, index)
+		}
+	}
+	return nil
+}
+
+func validateSerialization(serializationConf *SerializationConf) error {
+	if !serializationConf.Enabled {
+		return nil
+	}
+
+	switch serializationConf.Key {
+	case "":
+		logrus.Infof("Serialization key is not found in the configuration file, default key[%s] is set.", EntityIdSerializationKey)
+		serializationConf.Key = EntityIdSerializationKey
+	case EntityIdSerializationKey, EntityTypeSerializationKey, ActionSerializationKey:
+	default:
+		return errors.Errorf("Unknown serialization key[%s], valid keys are \"%s\", \"%s\" and \"%s\".",
+			serializationConf.Key, EntityIdSerializationKey, EntityTypeSerializationKey, ActionSerializationKey)
+	}
+	return nil
+}
+
+func validateRetryPolicy(name string, policy *RetryPolicy) error {
+	if policy.MaxAttempts < 0 || policy.BaseDelayInMillis < 0 || policy.MaxDelayInMillis < 0 || policy.TimeoutInSeconds < 0 {
+		return errors.Errorf("Values of the %s retry policy cannot be negative.", name)
+	}
+	if policy.MaxDelayInMillis > 0 && policy.BaseDelayInMillis > policy.MaxDelayInMillis {
+		return errors.Errorf("Base delay of the %s retry policy cannot be greater than its max delay.", name)
+	}
+	for _, statusCode := range policy.RetryableStatusCodes {
+		if statusCode < 100 || statusCode > 599 {
+			return errors.Errorf("Retryable status code[%d] of the %s retry policy is not valid.", statusCode, name)
+		}
+	}
+	return nil
+}
+
+func validateMessageSigning(signingConf *MessageSigningConf) error {
+	signingConf.Algorithm = strings.ToLower(signingConf.Algorithm)
+
+	switch signingConf.Algorithm {
+	case "":
+		logrus.Warn("Message signing is not configured, signatures of queue messages will not be verified.")
+		return nil
+	case HmacSha256SigningAlgorithm:
+		if signingConf.Key == "" {
+			return errors.New("Key of message signing is not found in the configuration file.")
+		}
+	case RsaSha256SigningAlgorithm:
+		if signingConf.PublicKeyFilepath == "" {
+			return errors.New("Public key filepath of message signing is not found in the configuration file.")
+		}
+		signingConf.PublicKeyFilepath = addHomeDirPrefix(signingConf.PublicKeyFilepath)
+	default:
+		return errors.Errorf("Unknown message signing algorithm[%s], valid algorithms are \"%s\" and \"%s\".",
+			signingConf.Algorithm, HmacSha256SigningAlgorithm, RsaSha256SigningAlgorithm)
+	}
+	return nil
+}
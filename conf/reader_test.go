@@ -65,6 +65,9 @@ func expectedConf() *Configuration {
 	if expectedConf.LogrusLevel == 0 {
 		expectedConf.LogrusLevel = logrus.InfoLevel
 	}
+	if expectedConf.InstanceConf.Id == "" {
+		expectedConf.InstanceConf.Id, _ = os.Hostname()
+	}
 	return &expectedConf
 }
 
@@ -304,3 +307,142 @@ func TestReadFileFromUnknownSource(t *testing.T) {
 	assert.False(t, readFileFromLocalCalled,
 		"Read method should not call the method readFileFromLocal.")
 }
+
+func TestValidateNegativeMaxMessageAge(t *testing.T) {
+	conf := *mockConf
+	conf.ActionMappings = copyActionMappings(mockActionMappings)
+
+	action := conf.ActionMappings["Create"]
+	action.MaxMessageAgeInSeconds = -1
+	conf.ActionMappings["Create"] = action
+
+	err := validate(&conf)
+	assert.EqualError(t, err, "Max message age of action[Create] cannot be negative.")
+}
+
+func TestValidateMessageSigning(t *testing.T) {
+	err := validateMessageSigning(&MessageSigningConf{Algorithm: "HMAC-SHA256"})
+	assert.EqualError(t, err, "Key of message signing is not found in the configuration file.")
+
+	err = validateMessageSigning(&MessageSigningConf{Algorithm: "rsa-sha256"})
+	assert.EqualError(t, err, "Public key filepath of message signing is not found in the configuration file.")
+
+	err = validateMessageSigning(&MessageSigningConf{Algorithm: "md5"})
+	assert.EqualError(t, err, "Unknown message signing algorithm[md5], valid algorithms are \"hmac-sha256\" and \"rsa-sha256\".")
+
+	signingConf := &MessageSigningConf{Algorithm: "HMAC-SHA256", Key: "secret"}
+	err = validateMessageSigning(signingConf)
+	assert.Nil(t, err)
+	assert.Equal(t, HmacSha256SigningAlgorithm, signingConf.Algorithm)
+}
+
+func TestValidateSerialization(t *testing.T) {
+	serializationConf := &SerializationConf{Enabled: true}
+	err := validateSerialization(serializationConf)
+	assert.Nil(t, err)
+	assert.Equal(t, EntityIdSerializationKey, serializationConf.Key)
+
+	err = validateSerialization(&SerializationConf{Enabled: true, Key: "alias"})
+	assert.EqualError(t, err, "Unknown serialization key[alias], valid keys are \"entityId\", \"entityType\" and \"action\".")
+
+	err = validateSerialization(&SerializationConf{Key: "alias"})
+	assert.Nil(t, err)
+}
+
+func TestValidateRetryPolicy(t *testing.T) {
+	err := validateRetryPolicy("token", &RetryPolicy{})
+	assert.Nil(t, err)
+
+	err = validateRetryPolicy("token", &RetryPolicy{MaxAttempts: -1})
+	assert.EqualError(t, err, "Values of the token retry policy cannot be negative.")
+
+	err = validateRetryPolicy("callback", &RetryPolicy{BaseDelayInMillis: 500, MaxDelayInMillis: 100})
+	assert.EqualError(t, err, "Base delay of the callback retry policy cannot be greater than its max delay.")
+
+	err = validateRetryPolicy("callback", &RetryPolicy{RetryableStatusCodes: []int{503, 1000}})
+	assert.EqualError(t, err, "Retryable status code[1000] of the callback retry policy is not valid.")
+}
+
+func TestValidateNegativeActionLimits(t *testing.T) {
+	conf := *mockConf
+	conf.ActionMappings = copyActionMappings(mockActionMappings)
+
+	action := conf.ActionMappings["Create"]
+	action.MaxConcurrency = -1
+	conf.ActionMappings["Create"] = action
+
+	err := validate(&conf)
+	assert.EqualError(t, err, "Max concurrency of action[Create] cannot be negative.")
+
+	action.MaxConcurrency = 0
+	action.RateLimit.PerSecond = -1
+	conf.ActionMappings["Create"] = action
+
+	err = validate(&conf)
+	assert.EqualError(t, err, "Rate limit of action[Create] cannot be negative.")
+}
+
+func TestValidatePools(t *testing.T) {
+	conf := *mockConf
+	conf.ActionMappings = copyActionMappings(mockActionMappings)
+
+	action := conf.ActionMappings["Create"]
+	action.Pool = "slow"
+	conf.ActionMappings["Create"] = action
+
+	err := validate(&conf)
+	assert.EqualError(t, err, "Pool[slow] of action[Create] is not found in the pools configuration.")
+
+	conf.Pools = map[string]PoolConf{"slow": {MaxNumberOfWorker: 1}}
+	err = validate(&conf)
+	assert.Nil(t, err)
+
+	conf.Pools[DefaultPoolName] = PoolConf{}
+	err = validate(&conf)
+	assert.EqualError(t, err, "Pool name[default] is reserved for the pool configured by poolConf.")
+}
+
+func TestValidateActionPriority(t *testing.T) {
+	conf := *mockConf
+	conf.ActionMappings = copyActionMappings(mockActionMappings)
+
+	action := conf.ActionMappings["Create"]
+	action.Priority = "P6"
+	conf.ActionMappings["Create"] = action
+
+	err := validate(&conf)
+	assert.EqualError(t, err, "Priority[P6] of action[Create] should be one of P1, P2, P3, P4 and P5.")
+
+	action.Priority = "p2"
+	conf.ActionMappings["Create"] = action
+	assert.Nil(t, validate(&conf))
+}
+
+func TestValidateRoutes(t *testing.T) {
+	conf := *mockConf
+	conf.ActionMappings = copyActionMappings(mockActionMappings)
+	conf.Routes = []Route{{Action: "Create", Tags: []string{"db"}, MappedAction: "CreateDatabase"}}
+
+	err := validate(&conf)
+	assert.EqualError(t, err, "Mapped action[CreateDatabase] of route[0] is not found in the action mappings.")
+
+	conf.Routes[0].MappedAction = "Close"
+	conf.Routes[0].Priority = "high"
+	err = validate(&conf)
+	assert.EqualError(t, err, "Priority[high] of route[0] should be one of P1, P2, P3, P4 and P5.")
+
+	conf.Routes[0].Priority = "P1"
+	assert.Nil(t, validate(&conf))
+}
+
+func TestValidateFallbackAction(t *testing.T) {
+	conf := *mockConf
+	conf.ActionMappings = copyActionMappings(mockActionMappings)
+	conf.FallbackAction = "Audit"
+
+	err := validate(&conf)
+	assert.EqualError(t, err, "Fallback action[Audit] is not found in the action mappings.")
+
+	conf.FallbackAction = "Create"
+	assert.Nil(t, validate(&conf))
+}
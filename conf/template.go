@@ -0,0 +1,197 @@
+package conf
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"strings"
+	"text/template"
+)
+
+const (
+	templateDelimiter = "{{"
+	endOfOptionsArg   = "--"
+)
+
+// templateFuncs are available in the templated args, env and flags of the mapped actions. The rendered values are
+// passed to the executables as they are without a shell, shellQuote escapes them for the scripts which pass them to one.
+var templateFuncs = template.FuncMap{
+	"json":       toJson,
+	"shellQuote": shellQuote,
+}
+
+// IsTemplated reports whether the args, env or flags of the mapped action contain a template.
+func (a *MappedAction) IsTemplated() bool {
+	for _, arg := range a.Args {
+		if isTemplate(arg) {
+			return true
+		}
+	}
+	for _, env := range a.Env {
+		if isTemplate(env) {
+			return true
+		}
+	}
+	for _, value := range a.Flags {
+		if isTemplate(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Render returns a copy of the mapped action whose templated args, env and flags are rendered over the payload,
+// e.g. "{{ .entity.id }}". Referring to a field which does not exist in the payload is an error. A templated arg
+// which is rendered to a value starting with "-" is an error unless it follows a "--" arg, since the executables
+// would parse it as an option.
+func (a *MappedAction) Render(payload []byte) (*MappedAction, error) {
+	rendered := *a
+	if !a.IsTemplated() {
+		return &rendered, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	data := map[string]interface{}{}
+	err := decoder.Decode(&data)
+	if err != nil {
+		return nil, errors.Errorf("Payload could not be parsed to render the templates: %s", err)
+	}
+
+	rendered.Args, err = a.renderArgs(data)
+	if err != nil {
+		return nil, errors.Errorf("Args could not be rendered: %s", err)
+	}
+	rendered.Env, err = a.renderAll(a.Env, data)
+	if err != nil {
+		return nil, errors.Errorf("Env could not be rendered: %s", err)
+	}
+
+	if a.Flags != nil {
+		rendered.Flags = make(Flags, len(a.Flags))
+		for name, value := range a.Flags {
+			rendered.Flags[name], err = a.render(value, data)
+			if err != nil {
+				return nil, errors.Errorf("Flag[%s] could not be rendered: %s", name, err)
+			}
+		}
+	}
+	return &rendered, nil
+}
+
+func isTemplate(text string) bool {
+	return strings.Contains(text, templateDelimiter)
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+func (a *MappedAction) renderArgs(data interface{}) ([]string, error) {
+	if a.Args == nil {
+		return nil, nil
+	}
+	rendered := make([]string, 0, len(a.Args))
+	isEndOfOptions := false
+	for _, text := range a.Args {
+		value, err := a.render(text, data)
+		if err != nil {
+			return nil, err
+		}
+		if isTemplate(text) && !isEndOfOptions && strings.HasPrefix(value, "-") && !strings.HasPrefix(text, "-") {
+			return nil, errors.Errorf("Rendered value of template[%s] starts with \"-\" and would be parsed as an option, "+
+				"put a \"%s\" arg before it.", text, endOfOptionsArg)
+		}
+		isEndOfOptions = isEndOfOptions || text == endOfOptionsArg
+		rendered = append(rendered, value)
+	}
+	return rendered, nil
+}
+
+func (a *MappedAction) renderAll(texts []string, data interface{}) ([]string, error) {
+	if texts == nil {
+		return nil, nil
+	}
+	rendered := make([]string, 0, len(texts))
+	for _, text := range texts {
+		value, err := a.render(text, data)
+		if err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, value)
+	}
+	return rendered, nil
+}
+
+// render uses the templates which are parsed while the mapped action is validated, the others are parsed on demand.
+func (a *MappedAction) render(text string, data interface{}) (string, error) {
+	if !isTemplate(text) {
+		return text, nil
+	}
+
+	tmpl, ok := a.templates[text]
+	if !ok {
+		var err error
+		tmpl, err = parseTemplate(text)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	buffer := &bytes.Buffer{}
+	err := tmpl.Execute(buffer, data)
+	if err != nil {
+		return "", err
+	}
+
+	// the executables cannot receive args or env containing NUL
+	if strings.ContainsRune(buffer.String(), 0) {
+		return "", errors.Errorf("Rendered value of template[%s] contains NUL character.", text)
+	}
+	return buffer.String(), nil
+}
+
+// validateTemplates parses the templates of the mapped action and keeps them to be rendered.
+func validateTemplates(action *MappedAction) error {
+	texts := append(append([]string{}, action.Args...), action.Env...)
+	for _, value := range action.Flags {
+		texts = append(texts, value)
+	}
+
+	for _, text := range texts {
+		if !isTemplate(text) {
+			continue
+		}
+		tmpl, err := parseTemplate(text)
+		if err != nil {
+			return err
+		}
+		if action.templates == nil {
+			action.templates = make(map[string]*template.Template)
+		}
+		action.templates[text] = tmpl
+	}
+	return nil
+}
+
+func toJson(value interface{}) (string, error) {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+// shellQuote quotes the value with single quotes for POSIX shells.
+func shellQuote(value interface{}) string {
+	var text string
+	switch v := value.(type) {
+	case string:
+		text = v
+	case nil:
+		text = ""
+	default:
+		text, _ = toJson(v)
+	}
+	return "'" + strings.Replace(text, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,91 @@
+package conf
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const templatePayload = `{"entity":{"id":"1234","type":"alert"},"alert":{"priority":"P1","tags":["db","prod"],"count":12345678901}}`
+
+func TestRender(t *testing.T) {
+	action := &MappedAction{
+		Args:  []string{"restart", "--id={{ .entity.id }}", "{{ .alert.count }}"},
+		Env:   []string{"PRIORITY={{ .alert.priority }}", "TAGS={{ json .alert.tags }}"},
+		Flags: Flags{"entity": "{{ .entity.type }}", "static": "value"},
+	}
+
+	rendered, err := action.Render([]byte(templatePayload))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"restart", "--id=1234", "12345678901"}, rendered.Args)
+	assert.Equal(t, []string{"PRIORITY=P1", `TAGS=["db","prod"]`}, rendered.Env)
+	assert.Equal(t, Flags{"entity": "alert", "static": "value"}, rendered.Flags)
+	assert.Equal(t, "--id={{ .entity.id }}", action.Args[1])
+}
+
+func TestRenderWithoutTemplates(t *testing.T) {
+	action := &MappedAction{Args: []string{"arg"}, Env: []string{"e=1"}}
+
+	rendered, err := action.Render([]byte("not json"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, action, rendered)
+}
+
+func TestRenderMissingField(t *testing.T) {
+	action := &MappedAction{Args: []string{"{{ .incident.id }}"}}
+
+	_, err := action.Render([]byte(templatePayload))
+	assert.Contains(t, err.Error(), "Args could not be rendered")
+
+	action = &MappedAction{Env: []string{"ID={{ .entity.missing }}"}}
+
+	_, err = action.Render([]byte(templatePayload))
+	assert.Contains(t, err.Error(), `map has no entry for key "missing"`)
+}
+
+func TestRenderRejectsNul(t *testing.T) {
+	action := &MappedAction{Args: []string{"{{ .entity.id }}"}}
+
+	_, err := action.Render([]byte(`{"entity":{"id":"12\u000034"}}`))
+	assert.Contains(t, err.Error(), "contains NUL character")
+}
+
+func TestRenderRejectsArgsParsedAsOptions(t *testing.T) {
+	payload := []byte(`{"entity":{"id":"--delete-all"}}`)
+
+	_, err := (&MappedAction{Args: []string{"restart", "{{ .entity.id }}"}}).Render(payload)
+	assert.Contains(t, err.Error(), `starts with "-" and would be parsed as an option`)
+
+	rendered, err := (&MappedAction{Args: []string{"restart", "--", "{{ .entity.id }}"}}).Render(payload)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"restart", "--", "--delete-all"}, rendered.Args)
+
+	rendered, err = (&MappedAction{Args: []string{"--id={{ .entity.id }}"}, Env: []string{"{{ .entity.id }}"}}).Render(payload)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"--id=--delete-all"}, rendered.Args)
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, `'it'\''s; rm -rf /'`, shellQuote("it's; rm -rf /"))
+	assert.Equal(t, `'["a"]'`, shellQuote([]interface{}{"a"}))
+	assert.Equal(t, `''`, shellQuote(nil))
+}
+
+func TestValidateTemplatesKeepsParsedTemplates(t *testing.T) {
+	action := &MappedAction{Args: []string{"static", "{{ .entity.id }}"}, Flags: Flags{"type": "{{ .entity.type }}"}}
+
+	assert.Nil(t, validateTemplates(action))
+	assert.Len(t, action.templates, 2)
+	assert.Contains(t, action.templates, "{{ .entity.id }}")
+
+	rendered, err := action.Render([]byte(templatePayload))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"static", "1234"}, rendered.Args)
+}
+
+func TestValidateTemplates(t *testing.T) {
+	assert.Nil(t, validateTemplates(&MappedAction{Args: []string{"{{ shellQuote .entity.id }}"}}))
+	assert.NotNil(t, validateTemplates(&MappedAction{Flags: Flags{"id": "{{ .entity.id "}}))
+	assert.NotNil(t, validateTemplates(&MappedAction{Env: []string{"ID={{ unknown .entity.id }}"}}))
+}
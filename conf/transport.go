@@ -0,0 +1,89 @@
+package conf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TransportConf is applied to every outgoing https connection; token and result requests,
+// sqs sessions and git operations over https. An empty proxy url falls back to the proxy environment variables.
+type TransportConf struct {
+	ProxyUrl                  string `json:"proxyUrl" yaml:"proxyUrl"`
+	ProxyUsername             string `json:"proxyUsername" yaml:"proxyUsername"`
+	ProxyPassword             string `json:"proxyPassword" yaml:"proxyPassword"`
+	CaBundleFilepath          string `json:"caBundleFilepath" yaml:"caBundleFilepath"`
+	ClientCertificateFilepath string `json:"clientCertificateFilepath" yaml:"clientCertificateFilepath"`
+	ClientKeyFilepath         string `json:"clientKeyFilepath" yaml:"clientKeyFilepath"`
+}
+
+func (c TransportConf) IsEmpty() bool {
+	return c == TransportConf{}
+}
+
+// HttpTransport creates a transport with the same defaults as http.DefaultTransport, using the configured
+// proxy, root certificate authorities and client certificate.
+func (c TransportConf) HttpTransport() (*http.Transport, error) {
+
+	proxy := http.ProxyFromEnvironment
+	if c.ProxyUrl != "" {
+		proxyUrl, err := url.Parse(c.ProxyUrl)
+		if err != nil {
+			return nil, errors.Errorf("Proxy url[%s] is not valid: %s", c.ProxyUrl, err)
+		}
+		if proxyUrl.Scheme == "" || proxyUrl.Host == "" {
+			return nil, errors.Errorf("Proxy url[%s] should contain a scheme and a host.", c.ProxyUrl)
+		}
+		if c.ProxyUsername != "" {
+			proxyUrl.User = url.UserPassword(c.ProxyUsername, c.ProxyPassword)
+		}
+		proxy = http.ProxyURL(proxyUrl)
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if c.CaBundleFilepath != "" {
+		caBundle, err := ioutil.ReadFile(c.CaBundleFilepath)
+		if err != nil {
+			return nil, errors.Errorf("CA bundle[%s] could not be read: %s", c.CaBundleFilepath, err)
+		}
+
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, errors.Errorf("CA bundle[%s] does not contain any PEM encoded certificate.", c.CaBundleFilepath)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if c.ClientCertificateFilepath != "" || c.ClientKeyFilepath != "" {
+		if c.ClientCertificateFilepath == "" || c.ClientKeyFilepath == "" {
+			return nil, errors.New("Both client certificate and client key filepaths should be set.")
+		}
+		certificate, err := tls.LoadX509KeyPair(c.ClientCertificateFilepath, c.ClientKeyFilepath)
+		if err != nil {
+			return nil, errors.Errorf("Client certificate[%s] could not be loaded: %s", c.ClientCertificateFilepath, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+	}, nil
+}
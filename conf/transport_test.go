@@ -0,0 +1,71 @@
+package conf
+
+import (
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHttpTransportWithCaBundle(t *testing.T) {
+	testServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	dir, _ := ioutil.TempDir("", "transport")
+	defer os.RemoveAll(dir)
+
+	caBundleFilepath := filepath.Join(dir, "ca.pem")
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: testServer.Certificate().Raw})
+	ioutil.WriteFile(caBundleFilepath, caBundle, 0600)
+
+	transport, err := TransportConf{CaBundleFilepath: caBundleFilepath}.HttpTransport()
+	assert.Nil(t, err)
+
+	response, err := (&http.Client{Transport: transport}).Get(testServer.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	transport, _ = TransportConf{}.HttpTransport()
+	_, err = (&http.Client{Transport: transport}).Get(testServer.URL)
+	assert.NotNil(t, err)
+}
+
+func TestHttpTransportWithProxy(t *testing.T) {
+	transport, err := TransportConf{
+		ProxyUrl:      "http://proxy.example.com:3128",
+		ProxyUsername: "user",
+		ProxyPassword: "secret",
+	}.HttpTransport()
+	assert.Nil(t, err)
+
+	request, _ := http.NewRequest(http.MethodGet, "https://api.atlassian.com", nil)
+	proxyUrl, err := transport.Proxy(request)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "proxy.example.com:3128", proxyUrl.Host)
+	assert.Equal(t, url.UserPassword("user", "secret"), proxyUrl.User)
+}
+
+func TestHttpTransportWithInvalidConf(t *testing.T) {
+	_, err := TransportConf{ProxyUrl: "proxy.example.com"}.HttpTransport()
+	assert.EqualError(t, err, "Proxy url[proxy.example.com] should contain a scheme and a host.")
+
+	_, err = TransportConf{ClientCertificateFilepath: "client.pem"}.HttpTransport()
+	assert.EqualError(t, err, "Both client certificate and client key filepaths should be set.")
+
+	dir, _ := ioutil.TempDir("", "transport")
+	defer os.RemoveAll(dir)
+
+	caBundleFilepath := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caBundleFilepath, []byte("not a certificate"), 0600)
+
+	_, err = TransportConf{CaBundleFilepath: caBundleFilepath}.HttpTransport()
+	assert.EqualError(t, err, "CA bundle["+caBundleFilepath+"] does not contain any PEM encoded certificate.")
+}
@@ -0,0 +1,79 @@
+package conf
+
+import (
+	"github.com/pkg/errors"
+	"text/template"
+)
+
+// WorkflowActionType is the type of the mapped actions which run their steps in order, workflows handle
+// the requests of custom actions.
+const WorkflowActionType = "workflow"
+
+// WorkflowStep runs either a mapped action or a command. When the step fails, the steps of its failure branch
+// are run and the workflow is aborted, unless the step is allowed to fail.
+type WorkflowStep struct {
+	Name              string         `json:"name" yaml:"name"`
+	Action            ActionName     `json:"action" yaml:"action"`
+	Command           string         `json:"command" yaml:"command"`
+	Args              []string       `json:"args" yaml:"args"`
+	Env               []string       `json:"env" yaml:"env"`
+	ContinueOnFailure bool           `json:"continueOnFailure" yaml:"continueOnFailure"`
+	OnFailure         []WorkflowStep `json:"onFailure" yaml:"onFailure"`
+
+	templates map[string]*template.Template
+}
+
+// RenderCommand returns the args and env of the command of the step rendered over the payload.
+func (s *WorkflowStep) RenderCommand(payload []byte) (*MappedAction, error) {
+	return (&MappedAction{Args: s.Args, Env: s.Env, templates: s.templates}).Render(payload)
+}
+
+// validateWorkflowSteps requires the actions of the steps to need only the labels which are required by the workflow,
+// since the instances are selected by the labels of the workflow.
+func validateWorkflowSteps(steps []WorkflowStep, requiredLabels map[string]string, mappings ActionMappings, names map[string]struct{}) error {
+	if len(steps) == 0 {
+		return errors.New("Steps are empty.")
+	}
+
+	for index := range steps {
+		step := &steps[index]
+		if step.Name == "" {
+			return errors.Errorf("Name of step[%d] is empty.", index)
+		}
+		if _, ok := names[step.Name]; ok {
+			return errors.Errorf("Step name[%s] is not unique.", step.Name)
+		}
+		names[step.Name] = struct{}{}
+
+		if (step.Action == "") == (step.Command == "") {
+			return errors.Errorf("Step[%s] should have either an action or a command.", step.Name)
+		}
+		if step.Action != "" {
+			action, ok := mappings[step.Action]
+			if !ok {
+				return errors.Errorf("Action[%s] of step[%s] is not found in the action mappings.", step.Action, step.Name)
+			}
+			if action.Type == WorkflowActionType || action.Type == FanOutActionType {
+				return errors.Errorf("Action[%s] of step[%s] cannot be a workflow or a fan-out action.", step.Action, step.Name)
+			}
+			if !(InstanceConf{Labels: requiredLabels}).HasLabels(action.RequiredLabels) {
+				return errors.Errorf("Required labels of action[%s] of step[%s] should also be required by the workflow.", step.Action, step.Name)
+			}
+			if len(step.Args) != 0 || len(step.Env) != 0 {
+				return errors.Errorf("Args and env of step[%s] can only be set for commands.", step.Name)
+			}
+		}
+		command := &MappedAction{Args: step.Args, Env: step.Env}
+		if err := validateTemplates(command); err != nil {
+			return errors.Errorf("Templates of step[%s] are not valid: %s", step.Name, err)
+		}
+		step.templates = command.templates
+
+		if len(step.OnFailure) != 0 {
+			if err := validateWorkflowSteps(step.OnFailure, requiredLabels, mappings, names); err != nil {
+				return errors.Errorf("Failure branch of step[%s] is not valid: %s", step.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package conf
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidateWorkflow(t *testing.T) {
+	conf := *mockConf
+	conf.ActionMappings = copyActionMappings(mockActionMappings)
+	conf.ActionMappings["Recover"] = MappedAction{
+		Type: WorkflowActionType,
+		Steps: []WorkflowStep{
+			{Name: "diagnose", Command: "/usr/bin/diagnose", Args: []string{"{{ .entity.id }}"}},
+			{Name: "restart", Action: "Create", OnFailure: []WorkflowStep{{Name: "rollback", Action: "Close"}}},
+		},
+	}
+
+	assert.Nil(t, validate(&conf))
+}
+
+func TestValidateWorkflowSteps(t *testing.T) {
+	mappings := copyActionMappings(mockActionMappings)
+	mappings["Workflow"] = MappedAction{Type: WorkflowActionType, Steps: []WorkflowStep{{Name: "step", Action: "Create"}}}
+	mappings["Gpu"] = MappedAction{Type: "custom", RequiredLabels: map[string]string{"gpu": "true"}}
+
+	assert.Nil(t, validateWorkflowSteps([]WorkflowStep{{Name: "step", Action: "Gpu"}}, map[string]string{"gpu": "true"}, mappings, map[string]struct{}{}))
+
+	testCases := []struct {
+		steps []WorkflowStep
+		err   string
+	}{
+		{nil, "Steps are empty."},
+		{[]WorkflowStep{{Action: "Create"}}, "Name of step[0] is empty."},
+		{[]WorkflowStep{{Name: "step", Action: "Create"}, {Name: "step", Action: "Close"}}, "Step name[step] is not unique."},
+		{[]WorkflowStep{{Name: "step"}}, "Step[step] should have either an action or a command."},
+		{[]WorkflowStep{{Name: "step", Action: "Create", Command: "ls"}}, "Step[step] should have either an action or a command."},
+		{[]WorkflowStep{{Name: "step", Action: "Missing"}}, "Action[Missing] of step[step] is not found in the action mappings."},
+		{[]WorkflowStep{{Name: "step", Action: "Workflow"}}, "Action[Workflow] of step[step] cannot be a workflow or a fan-out action."},
+		{[]WorkflowStep{{Name: "step", Action: "Create", Args: []string{"arg"}}}, "Args and env of step[step] can only be set for commands."},
+		{[]WorkflowStep{{Name: "step", Command: "ls", Args: []string{"{{ .entity.id "}}}, "Templates of step[step] are not valid"},
+		{[]WorkflowStep{{Name: "step", Command: "ls", OnFailure: []WorkflowStep{{Name: "step", Command: "ls"}}}},
+			"Failure branch of step[step] is not valid: Step name[step] is not unique."},
+		{[]WorkflowStep{{Name: "step", Command: "ls", OnFailure: []WorkflowStep{{Name: "rollback", Action: "Gpu"}}}},
+			"Required labels of action[Gpu] of step[rollback] should also be required by the workflow."},
+	}
+
+	for _, testCase := range testCases {
+		err := validateWorkflowSteps(testCase.steps, nil, mappings, map[string]struct{}{})
+		assert.Contains(t, err.Error(), testCase.err)
+	}
+}
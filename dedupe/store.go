@@ -0,0 +1,229 @@
+package dedupe
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const compactionThreshold = 1000
+
+type Store interface {
+	Get(key string) ([]byte, bool)
+	Put(value []byte, keys ...string) error
+	Close() error
+}
+
+type record struct {
+	Keys             []string        `json:"keys"`
+	Value            json.RawMessage `json:"value"`
+	ExpireTimeMillis int64           `json:"expireTimeMillis"`
+}
+
+func (r *record) isExpired(now time.Time) bool {
+	return r.ExpireTimeMillis <= now.UnixNano()/int64(time.Millisecond)
+}
+
+// fileStore keeps the records in memory and appends every write to a json lines file,
+// so that the records survive restarts until their time to live passes.
+type fileStore struct {
+	path      string
+	ttl       time.Duration
+	file      *os.File
+	records   map[string]*record
+	lineCount int
+	nowFunc   func() time.Time
+	mu        *sync.Mutex
+}
+
+func Open(path string, ttl time.Duration) (Store, error) {
+	store := &fileStore{
+		path:    path,
+		ttl:     ttl,
+		records: make(map[string]*record),
+		nowFunc: time.Now,
+		mu:      &sync.Mutex{},
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.load()
+	if err != nil {
+		return nil, errors.Errorf("Dedupe store[%s] could not be loaded: %s", path, err)
+	}
+
+	err = store.compact()
+	if err != nil {
+		return nil, errors.Errorf("Dedupe store[%s] could not be compacted: %s", path, err)
+	}
+
+	logrus.Infof("Dedupe store[%s] is opened with %d records.", path, len(store.uniqueRecords()))
+	return store, nil
+}
+
+func (s *fileStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.records[key]
+	if !ok {
+		return nil, false
+	}
+	if r.isExpired(s.nowFunc()) {
+		delete(s.records, key)
+		return nil, false
+	}
+	return r.Value, true
+}
+
+func (s *fileStore) Put(value []byte, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return errors.New("Dedupe store is closed.")
+	}
+
+	r := &record{
+		Keys:             keys,
+		Value:            value,
+		ExpireTimeMillis: s.nowFunc().Add(s.ttl).UnixNano() / int64(time.Millisecond),
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	s.lineCount++
+
+	for _, key := range keys {
+		s.records[key] = r
+	}
+
+	if s.lineCount > 2*len(s.records)+compactionThreshold {
+		return s.compact()
+	}
+	return nil
+}
+
+func (s *fileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *fileStore) load() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	now := s.nowFunc()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		r := &record{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			logrus.Warnf("Dedupe store[%s] skipped a corrupted record: %s", s.path, err)
+			continue
+		}
+		if r.isExpired(now) {
+			continue
+		}
+		for _, key := range r.Keys {
+			s.records[key] = r
+		}
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the file with the records which are not expired yet and reopens it for appending.
+func (s *fileStore) compact() error {
+	now := s.nowFunc()
+	tmpPath := s.path + ".tmp"
+
+	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	lineCount := 0
+	writer := bufio.NewWriter(tmpFile)
+	for _, r := range s.uniqueRecords() {
+		if r.isExpired(now) {
+			for _, key := range r.Keys {
+				delete(s.records, key)
+			}
+			continue
+		}
+
+		line, err := json.Marshal(r)
+		if err != nil {
+			tmpFile.Close()
+			return err
+		}
+		writer.Write(append(line, '\n'))
+		lineCount++
+	}
+
+	if err := writer.Flush(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return err
+	}
+
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	s.lineCount = lineCount
+	return nil
+}
+
+func (s *fileStore) uniqueRecords() []*record {
+	seen := make(map[*record]struct{}, len(s.records))
+	records := make([]*record, 0, len(s.records))
+	for _, r := range s.records {
+		if _, ok := seen[r]; ok {
+			continue
+		}
+		seen[r] = struct{}{}
+		records = append(records, r)
+	}
+	return records
+}
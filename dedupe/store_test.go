@@ -0,0 +1,122 @@
+package dedupe
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logrus.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func newTestStorePath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "jecDedupe")
+	assert.Nil(t, err)
+	return filepath.Join(dir, "dedupe-store.jsonl"), func() { os.RemoveAll(dir) }
+}
+
+func TestPutAndGet(t *testing.T) {
+	path, cleanup := newTestStorePath(t)
+	defer cleanup()
+
+	store, err := Open(path, time.Hour)
+	assert.Nil(t, err)
+	defer store.Close()
+
+	err = store.Put([]byte(`{"isSuccessful":true}`), "request:1", "message:1")
+	assert.Nil(t, err)
+
+	value, ok := store.Get("request:1")
+	assert.True(t, ok)
+	assert.Equal(t, `{"isSuccessful":true}`, string(value))
+
+	value, ok = store.Get("message:1")
+	assert.True(t, ok)
+	assert.Equal(t, `{"isSuccessful":true}`, string(value))
+
+	_, ok = store.Get("request:2")
+	assert.False(t, ok)
+}
+
+func TestRecordsSurviveReopen(t *testing.T) {
+	path, cleanup := newTestStorePath(t)
+	defer cleanup()
+
+	store, err := Open(path, time.Hour)
+	assert.Nil(t, err)
+
+	assert.Nil(t, store.Put([]byte(`"first"`), "request:1"))
+	assert.Nil(t, store.Put([]byte(`"second"`), "request:2"))
+	assert.Nil(t, store.Close())
+
+	store, err = Open(path, time.Hour)
+	assert.Nil(t, err)
+	defer store.Close()
+
+	value, ok := store.Get("request:2")
+	assert.True(t, ok)
+	assert.Equal(t, `"second"`, string(value))
+}
+
+func TestExpiredRecords(t *testing.T) {
+	path, cleanup := newTestStorePath(t)
+	defer cleanup()
+
+	store, err := Open(path, time.Hour)
+	assert.Nil(t, err)
+
+	now := time.Now()
+	store.(*fileStore).nowFunc = func() time.Time { return now.Add(-2 * time.Hour) }
+	assert.Nil(t, store.Put([]byte(`"expired"`), "request:1"))
+	store.(*fileStore).nowFunc = time.Now
+	assert.Nil(t, store.Put([]byte(`"valid"`), "request:2"))
+
+	_, ok := store.Get("request:1")
+	assert.False(t, ok)
+	assert.Nil(t, store.Close())
+
+	store, err = Open(path, time.Hour)
+	assert.Nil(t, err)
+	defer store.Close()
+
+	content, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), "\n"))
+	assert.NotContains(t, string(content), "expired")
+}
+
+func TestCorruptedRecordsAreSkipped(t *testing.T) {
+	path, cleanup := newTestStorePath(t)
+	defer cleanup()
+
+	expireTimeMillis := time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond)
+	content := "not json\n" + `{"keys":["request:1"],"value":"ok","expireTimeMillis":` + strconv.FormatInt(expireTimeMillis, 10) + "}\n"
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	store, err := Open(path, time.Hour)
+	assert.Nil(t, err)
+	defer store.Close()
+
+	_, ok := store.Get("request:1")
+	assert.True(t, ok)
+}
+
+func TestPutAfterClose(t *testing.T) {
+	path, cleanup := newTestStorePath(t)
+	defer cleanup()
+
+	store, err := Open(path, time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Close())
+
+	err = store.Put([]byte(`"value"`), "request:1")
+	assert.EqualError(t, err, "Dedupe store is closed.")
+}
@@ -0,0 +1,14 @@
+package git
+
+import (
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"net/http"
+)
+
+// SetHttpTransport makes the git operations over http and https use the given transport.
+func SetHttpTransport(transport http.RoundTripper) {
+	httpClient := githttp.NewClient(&http.Client{Transport: transport})
+	client.InstallProtocol("https", httpClient)
+	client.InstallProtocol("http", httpClient)
+}
@@ -0,0 +1,105 @@
+package health
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+const (
+	StatusUp   = "UP"
+	StatusDown = "DOWN"
+)
+
+// Check returns nil when the checked component is healthy.
+type Check func() error
+
+// Detail describes the state of a component which is reported without affecting the status, e.g. a degraded dependency.
+type Detail func() string
+
+type Report struct {
+	Status  string            `json:"status"`
+	Checks  map[string]string `json:"checks"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+var (
+	checks   = make(map[string]Check)
+	details  = make(map[string]Detail)
+	checksMu = &sync.RWMutex{}
+)
+
+// Register adds the check under the given name, an existing check with the same name is replaced.
+func Register(name string, check Check) {
+	checksMu.Lock()
+	defer checksMu.Unlock()
+	checks[name] = check
+}
+
+// RegisterDetail adds the detail under the given name, an existing detail with the same name is replaced.
+func RegisterDetail(name string, detail Detail) {
+	checksMu.Lock()
+	defer checksMu.Unlock()
+	details[name] = detail
+}
+
+// Unregister removes the check or the detail with the given name.
+func Unregister(name string) {
+	checksMu.Lock()
+	defer checksMu.Unlock()
+	delete(checks, name)
+	delete(details, name)
+}
+
+func Status() Report {
+	checksMu.RLock()
+	defer checksMu.RUnlock()
+
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	report := Report{
+		Status: StatusUp,
+		Checks: make(map[string]string, len(checks)),
+	}
+	for _, name := range names {
+		if err := checks[name](); err != nil {
+			report.Status = StatusDown
+			report.Checks[name] = err.Error()
+			continue
+		}
+		report.Checks[name] = StatusUp
+	}
+
+	if len(details) != 0 {
+		report.Details = make(map[string]string, len(details))
+		for name, detail := range details {
+			report.Details[name] = detail()
+		}
+	}
+	return report
+}
+
+// Handler responds with the report of all checks, the status code is 503 when any of them fails.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := Status()
+
+		statusCode := http.StatusOK
+		if report.Status != StatusUp {
+			statusCode = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		err := json.NewEncoder(w).Encode(report)
+		if err != nil {
+			logrus.Warnf("Health report could not be written: %s", err)
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package health
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	defer Unregister("first")
+	defer Unregister("second")
+
+	Register("first", func() error { return nil })
+	Register("second", func() error { return nil })
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	report := Report{}
+	json.Unmarshal(recorder.Body.Bytes(), &report)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, Report{Status: StatusUp, Checks: map[string]string{"first": StatusUp, "second": StatusUp}}, report)
+
+	Register("second", func() error { return errors.New("Second is broken.") })
+
+	recorder = httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	report = Report{}
+	json.Unmarshal(recorder.Body.Bytes(), &report)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, Report{Status: StatusDown, Checks: map[string]string{"first": StatusUp, "second": "Second is broken."}}, report)
+}
+
+func TestHandlerWithDetail(t *testing.T) {
+	defer Unregister("check")
+	defer Unregister("dependency")
+
+	Register("check", func() error { return nil })
+	RegisterDetail("dependency", func() string { return "degraded" })
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	report := Report{}
+	json.Unmarshal(recorder.Body.Bytes(), &report)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, Report{
+		Status:  StatusUp,
+		Checks:  map[string]string{"check": StatusUp},
+		Details: map[string]string{"dependency": "degraded"},
+	}, report)
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/atlassian/jec/admin"
 	"github.com/atlassian/jec/conf"
+	"github.com/atlassian/jec/health"
 	"github.com/atlassian/jec/queue"
 	"github.com/atlassian/jec/util"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -21,6 +23,7 @@ import (
 )
 
 var metricAddr = flag.String("jec-metrics", "7070", "The address to listen on for HTTP requests.")
+var adminAddr = flag.String("jec-admin", "127.0.0.1:7071", "The local address to listen on for admin requests, empty disables the admin endpoint.")
 var defaultLogFilepath = filepath.Join("/var", "log", "jec", "jec"+strconv.Itoa(os.Getpid())+".log")
 
 var JECVersion string
@@ -55,10 +58,15 @@ func main() {
 	}
 
 	logrus.SetLevel(configuration.LogrusLevel)
+	logrus.AddHook(conf.NewInstanceLogHook(configuration.InstanceConf.Id))
+	if len(configuration.InstanceConf.Labels) != 0 {
+		logrus.Infof("JEC instance labels are %s", configuration.InstanceConf.FormatLabels())
+	}
 
 	flag.Parse()
 	go func() {
 		http.Handle("/metrics", promhttp.Handler())
+		http.Handle("/health", health.Handler())
 		logrus.Infof("JEC-metrics serves in http://localhost:%s/metrics.", *metricAddr)
 		logrus.Error("JEC-metrics error: ", http.ListenAndServe(":"+*metricAddr, nil))
 	}()
@@ -66,6 +74,22 @@ func main() {
 	queueProcessor := queue.NewProcessor(configuration)
 	queue.UserAgentHeader = fmt.Sprintf("%s/%s %s (%s/%s)", JECVersion, JECCommitVersion, runtime.Version(), runtime.GOOS, runtime.GOARCH)
 
+	poolManager, isPoolManager := queueProcessor.(queue.PoolManager)
+	reloadPools := func() error {
+		reloadedConf, err := conf.Read()
+		if err != nil {
+			return err
+		}
+		return poolManager.ReloadPools(reloadedConf)
+	}
+
+	if *adminAddr != "" && isPoolManager {
+		go func() {
+			logrus.Infof("JEC-admin serves in http://%s/admin.", *adminAddr)
+			logrus.Error("JEC-admin error: ", http.ListenAndServe(*adminAddr, admin.NewHandler(poolManager, reloadPools)))
+		}()
+	}
+
 	go func() {
 		if configuration.AppName != "" {
 			logrus.Infof("%s is starting.", configuration.AppName)
@@ -77,15 +101,27 @@ func main() {
 	}()
 
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			if !isPoolManager {
+				continue
+			}
+			logrus.Infof("Configuration will be reloaded.")
+			err := reloadPools()
+			if err != nil {
+				logrus.Errorf("Configuration could not be reloaded: %s", err)
+			}
+			continue
+		}
 
-	select {
-	case <-signals:
 		logrus.Infof("JEC will be stopped gracefully.")
 		err := queueProcessor.Stop()
 		if err != nil {
 			logrus.Fatalln(err)
 		}
+		break
 	}
 
 	os.Exit(0)
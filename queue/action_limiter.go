@@ -0,0 +1,174 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/atlassian/jec/conf"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	concurrencyDeferralInSec = 5
+
+	concurrencyLimitReason = "concurrency"
+	rateLimitReason        = "rateLimit"
+
+	// the targets of fan-out actions and the steps of workflows wait for the limits of their actions
+	// in shorter intervals than the deferred messages
+	maxActionLimitWait = 250 * time.Millisecond
+)
+
+type ActionLimiter interface {
+	// Acquire reserves an execution slot for the action of the message. If the action is over its limits,
+	// it returns false with the duration after which the message should be retried.
+	Acquire(message *sqs.Message) (release func(), retryAfter time.Duration, ok bool)
+	// AcquireAction reserves an execution slot for the mapped action, i.e. a target of a fan-out action.
+	AcquireAction(actionName conf.ActionName) (release func(), retryAfter time.Duration, ok bool)
+}
+
+type actionLimit struct {
+	integration    string
+	action         string
+	maxConcurrency int32
+	inFlight       int32
+
+	ratePerSecond float64
+	burst         float64
+	tokens        float64
+	lastRefill    time.Time
+
+	mu *sync.Mutex
+}
+
+type actionLimiter struct {
+	limits      map[string]*actionLimit
+	actionSpecs conf.ActionSpecifications
+	nowFunc     func() time.Time
+}
+
+// NewActionLimiter limits the executions of the mapped actions, the requests are limited by the mapped actions they are routed to.
+// The integration is the label of the metrics of the limits.
+func NewActionLimiter(integration string, actionSpecs conf.ActionSpecifications) ActionLimiter {
+	limiter := &actionLimiter{
+		limits:      make(map[string]*actionLimit),
+		actionSpecs: actionSpecs,
+		nowFunc:     time.Now,
+	}
+
+	for actionName, action := range actionSpecs.ActionMappings {
+		if action.MaxConcurrency <= 0 && action.RateLimit.PerSecond <= 0 {
+			continue
+		}
+
+		limit := &actionLimit{
+			integration:    integration,
+			action:         string(actionName),
+			maxConcurrency: action.MaxConcurrency,
+			ratePerSecond:  action.RateLimit.PerSecond,
+			burst:          float64(action.RateLimit.Burst),
+			lastRefill:     limiter.nowFunc(),
+			mu:             &sync.Mutex{},
+		}
+		if limit.ratePerSecond > 0 && limit.burst <= 0 {
+			limit.burst = math.Max(1, math.Ceil(limit.ratePerSecond))
+		}
+		limit.tokens = limit.burst
+
+		limiter.limits[limit.action] = limit
+		actionMaxConcurrency.WithLabelValues(integration, limit.action).Set(float64(limit.maxConcurrency))
+		actionRateLimit.WithLabelValues(integration, limit.action).Set(limit.ratePerSecond)
+		actionInFlightExecutions.WithLabelValues(integration, limit.action).Set(0)
+	}
+
+	if len(limiter.limits) == 0 {
+		return nil
+	}
+	return limiter
+}
+
+func (l *actionLimiter) Acquire(message *sqs.Message) (func(), time.Duration, bool) {
+	queuePayload := payload{}
+	if err := json.Unmarshal([]byte(aws.StringValue(message.Body)), &queuePayload); err != nil {
+		return func() {}, 0, true // the message handler reports invalid messages
+	}
+
+	actionName, _ := resolveActionName(&l.actionSpecs, &queuePayload)
+	return l.AcquireAction(actionName)
+}
+
+func (l *actionLimiter) AcquireAction(actionName conf.ActionName) (func(), time.Duration, bool) {
+	limit, ok := l.limits[string(actionName)]
+	if !ok {
+		return func() {}, 0, true
+	}
+
+	retryAfter, reason := limit.acquire(l.nowFunc())
+	if reason != "" {
+		actionDeferredMessages.WithLabelValues(limit.integration, limit.action, reason).Inc()
+		return nil, retryAfter, false
+	}
+	return limit.release, 0, true
+}
+
+func (a *actionLimit) acquire(now time.Time) (retryAfter time.Duration, reason string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.maxConcurrency > 0 && a.inFlight >= a.maxConcurrency {
+		return concurrencyDeferralInSec * time.Second, concurrencyLimitReason
+	}
+
+	if a.ratePerSecond > 0 {
+		elapsed := now.Sub(a.lastRefill).Seconds()
+		a.tokens = math.Min(a.burst, a.tokens+elapsed*a.ratePerSecond)
+		a.lastRefill = now
+
+		if a.tokens < 1 {
+			wait := time.Duration((1 - a.tokens) / a.ratePerSecond * float64(time.Second))
+			return wait, rateLimitReason
+		}
+		a.tokens--
+	}
+
+	a.inFlight++
+	actionInFlightExecutions.WithLabelValues(a.integration, a.action).Set(float64(a.inFlight))
+	return 0, ""
+}
+
+func (a *actionLimit) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.inFlight--
+	actionInFlightExecutions.WithLabelValues(a.integration, a.action).Set(float64(a.inFlight))
+}
+
+// acquireAction waits until the mapped action is within its limits. It fails when the context is done, e.g. the deadline
+// of a fan-out action passes, or when the processor stops.
+func (mh *messageHandler) acquireAction(ctx context.Context, actionName conf.ActionName) (func(), error) {
+	if mh.actionLimiter == nil {
+		return func() {}, nil
+	}
+
+	for {
+		release, retryAfter, ok := mh.actionLimiter.AcquireAction(actionName)
+		if ok {
+			return release, nil
+		}
+
+		if retryAfter > maxActionLimitWait {
+			retryAfter = maxActionLimitWait
+		}
+		if !sleepBeforeRetry(ctx, retryAfter, mh.quit) {
+			if ctx.Err() != nil {
+				return nil, errors.Errorf("Action[%s] could not run within its limits before the deadline.", actionName)
+			}
+			return nil, errors.Errorf("Action[%s] could not run within its limits before the processor stopped.", actionName)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package queue
+
+import (
+	"github.com/atlassian/jec/conf"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newLimitedActionMessage(action string) *sqs.Message {
+	body := `{"actionType":"custom", "action":"` + action + `"}`
+	return &sqs.Message{Body: &body, MessageId: &mockMessageId}
+}
+
+func TestNewActionLimiterWithoutLimits(t *testing.T) {
+	limiter := NewActionLimiter(defaultIntegrationLabel, conf.ActionSpecifications{ActionMappings: mockActionMappings})
+	assert.Nil(t, limiter)
+}
+
+func TestActionLimiterMaxConcurrency(t *testing.T) {
+	limiter := NewActionLimiter(defaultIntegrationLabel, conf.ActionSpecifications{ActionMappings: conf.ActionMappings{
+		"Restart": conf.MappedAction{Type: CustomActionType, MaxConcurrency: 2},
+	}})
+
+	release1, _, ok := limiter.Acquire(newLimitedActionMessage("Restart"))
+	assert.True(t, ok)
+	_, _, ok = limiter.Acquire(newLimitedActionMessage("Restart"))
+	assert.True(t, ok)
+
+	_, retryAfter, ok := limiter.Acquire(newLimitedActionMessage("Restart"))
+	assert.False(t, ok)
+	assert.Equal(t, concurrencyDeferralInSec*time.Second, retryAfter)
+
+	_, _, ok = limiter.Acquire(newLimitedActionMessage("Create"))
+	assert.True(t, ok)
+
+	release1()
+	_, _, ok = limiter.Acquire(newLimitedActionMessage("Restart"))
+	assert.True(t, ok)
+}
+
+func TestActionLimiterRateLimit(t *testing.T) {
+	limiter := NewActionLimiter(defaultIntegrationLabel, conf.ActionSpecifications{ActionMappings: conf.ActionMappings{
+		"Restart": conf.MappedAction{Type: CustomActionType, RateLimit: conf.RateLimit{PerSecond: 2, Burst: 2}},
+	}}).(*actionLimiter)
+
+	now := time.Now()
+	limiter.nowFunc = func() time.Time { return now }
+	limiter.limits["Restart"].lastRefill = now
+
+	for i := 0; i < 2; i++ {
+		release, _, ok := limiter.Acquire(newLimitedActionMessage("Restart"))
+		assert.True(t, ok)
+		release()
+	}
+
+	_, retryAfter, ok := limiter.Acquire(newLimitedActionMessage("Restart"))
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	now = now.Add(500 * time.Millisecond)
+	_, _, ok = limiter.Acquire(newLimitedActionMessage("Restart"))
+	assert.True(t, ok)
+}
+
+func TestActionLimiterDefaultBurst(t *testing.T) {
+	limiter := NewActionLimiter(defaultIntegrationLabel, conf.ActionSpecifications{ActionMappings: conf.ActionMappings{
+		"Restart": conf.MappedAction{Type: CustomActionType, RateLimit: conf.RateLimit{PerSecond: 0.5}},
+	}}).(*actionLimiter)
+
+	assert.Equal(t, float64(1), limiter.limits["Restart"].burst)
+}
+
+func TestActionLimiterLimitsRoutedMappedAction(t *testing.T) {
+	limiter := NewActionLimiter(defaultIntegrationLabel, conf.ActionSpecifications{
+		ActionMappings: conf.ActionMappings{
+			"Restart":         conf.MappedAction{Type: CustomActionType},
+			"RestartDatabase": conf.MappedAction{Type: CustomActionType, MaxConcurrency: 1},
+		},
+		Routes: []conf.Route{{Action: "Restart", EntityType: "alert", MappedAction: "RestartDatabase"}},
+	})
+
+	body := `{"actionType":"custom", "action":"Restart", "entity": {"type": "alert"}}`
+	message := &sqs.Message{Body: &body, MessageId: &mockMessageId}
+
+	release, _, ok := limiter.Acquire(message)
+	assert.True(t, ok)
+	_, _, ok = limiter.Acquire(message)
+	assert.False(t, ok)
+
+	_, _, ok = limiter.Acquire(newLimitedActionMessage("Restart"))
+	assert.True(t, ok)
+	release()
+}
+
+func TestActionLimiterLimitsFallbackAction(t *testing.T) {
+	limiter := NewActionLimiter(defaultIntegrationLabel, conf.ActionSpecifications{
+		ActionMappings: conf.ActionMappings{
+			"Audit": conf.MappedAction{Type: CustomActionType, MaxConcurrency: 1},
+		},
+		FallbackAction: "Audit",
+	})
+
+	release, _, ok := limiter.Acquire(newLimitedActionMessage("Unknown"))
+	assert.True(t, ok)
+	_, _, ok = limiter.Acquire(newLimitedActionMessage("Acknowledge"))
+	assert.False(t, ok)
+	release()
+}
@@ -0,0 +1,75 @@
+package queue
+
+import (
+	"context"
+	"github.com/atlassian/jec/conf"
+	"github.com/atlassian/jec/retryer"
+	"github.com/atlassian/jec/runbook"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// sleepBeforeRetry returns false if the wait is interrupted by the context of the execution or the quit channel
+// of the processor.
+var sleepBeforeRetry = func(ctx context.Context, d time.Duration, quit <-chan struct{}) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-quit:
+		return false
+	}
+}
+
+// executeWithRetry executes the mapped action again while it fails with a retryable failure and it has attempts left.
+// The outcomes of the attempts are returned if the retry of the mapped action is enabled.
+func (mh *messageHandler) executeWithRetry(ctx context.Context, executionId string, mappedAction *conf.MappedAction, message *sqs.Message, extraEnv []string, captureStdout bool) (string, string, []runbook.AttemptResult, error) {
+	retry := &mappedAction.Retry
+	if !retry.IsEnabled() {
+		stdout, callbackContext, err := mh.execute(ctx, executionId, mappedAction, message, extraEnv, captureStdout)
+		return stdout, callbackContext, nil, err
+	}
+
+	backoff := retryer.NewBackoff(retry.BackoffPolicy())
+	attempts := make([]runbook.AttemptResult, 0, retry.MaxAttempts)
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		stdout, callbackContext, err := mh.execute(ctx, executionId, mappedAction, message, extraEnv, captureStdout)
+
+		attemptResult := runbook.AttemptResult{
+			Attempt:          attempt,
+			IsSuccessful:     err == nil,
+			DurationInMillis: int64(time.Since(start) / time.Millisecond),
+		}
+		if err != nil {
+			attemptResult.FailureMessage = executionFailureMessage(err)
+		}
+		attempts = append(attempts, attemptResult)
+
+		if err == nil || attempt >= retry.MaxAttempts || !isRetryable(retry, err) {
+			return stdout, callbackContext, attempts, err
+		}
+
+		waitDuration := backoff.Next()
+		logrus.Infof("Execution[%s] failed at attempt %d of %d and it will be retried in %s: %s",
+			executionId, attempt, retry.MaxAttempts, waitDuration, attemptResult.FailureMessage)
+		if !sleepBeforeRetry(ctx, waitDuration, mh.quit) {
+			logrus.Infof("Retry of execution[%s] is cancelled since the execution is stopped or the queue processor is stopping.", executionId)
+			return stdout, callbackContext, attempts, err
+		}
+	}
+}
+
+// isRetryable reports whether the failure is retryable, only the failures of the executables can be retried.
+func isRetryable(retry *conf.ActionRetry, err error) bool {
+	execErr, ok := err.(*runbook.ExecError)
+	if !ok {
+		return false
+	}
+	return retry.IsRetryable(execErr.ExitCode(), execErr.Stderr)
+}
@@ -0,0 +1,136 @@
+package queue
+
+import (
+	"context"
+	"github.com/atlassian/jec/conf"
+	"github.com/atlassian/jec/runbook"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+)
+
+// mockFailingExecute fails the first executions with the exit code.
+func mockFailingExecute(failures int, exitCode int) (*int, func(context.Context, string, string, []string, []string, io.Writer, io.Writer) (string, error)) {
+	executions := new(int)
+	return executions, func(ctx context.Context, executionId string, path string, args, env []string, stdout, stderr io.Writer) (string, error) {
+		*executions++
+		if *executions <= failures {
+			return "", runbook.NewExecError(exitCode, "connection reset\n")
+		}
+		return "context", nil
+	}
+}
+
+var defaultSleepBeforeRetry = sleepBeforeRetry
+
+func handleRetriedMessage(t *testing.T, retry conf.ActionRetry) *runbook.ActionResultPayload {
+	return handleRetriedMessageUntilQuit(t, retry, nil)
+}
+
+func handleRetriedMessageUntilQuit(t *testing.T, retry conf.ActionRetry, quit <-chan struct{}) *runbook.ActionResultPayload {
+	assert.Nil(t, retry.CompilePatterns())
+	actionSpecs := conf.ActionSpecifications{
+		ActionMappings: conf.ActionMappings{
+			"Restart": conf.MappedAction{Type: CustomActionType, SourceType: "local", Filepath: "/path/to/restart.sh", Retry: retry},
+		},
+	}
+	body := `{"action":"Restart", "actionType":"custom", "requestId": "RequestId"}`
+	id := "MessageId"
+
+	handler := &messageHandler{actionSpecs: actionSpecs, actionLoggers: mockActionLoggers, quit: quit}
+	result, err := handler.Handle(sqs.Message{Body: &body, MessageId: &id})
+
+	assert.Nil(t, err)
+	return result
+}
+
+func TestExecuteWithRetryUntilSuccess(t *testing.T) {
+	defer func() {
+		runbook.ExecuteFunc = runbook.Execute
+		sleepBeforeRetry = defaultSleepBeforeRetry
+	}()
+
+	waitDurations := make([]time.Duration, 0)
+	sleepBeforeRetry = func(ctx context.Context, d time.Duration, quit <-chan struct{}) bool {
+		waitDurations = append(waitDurations, d)
+		return true
+	}
+	var executions *int
+	executions, runbook.ExecuteFunc = mockFailingExecute(2, 75)
+
+	result := handleRetriedMessage(t, conf.ActionRetry{
+		MaxAttempts:        3,
+		BaseDelayInMillis:  100,
+		MaxDelayInMillis:   1000,
+		RetryableExitCodes: []int{75},
+	})
+
+	assert.True(t, result.IsSuccessful)
+	assert.Equal(t, "context", result.CallbackContext)
+	assert.Equal(t, 3, *executions)
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, waitDurations)
+
+	assert.Len(t, result.Attempts, 3)
+	assert.Equal(t, 1, result.Attempts[0].Attempt)
+	assert.False(t, result.Attempts[0].IsSuccessful)
+	assert.Equal(t, "Err: exit status 75, Stderr: connection reset\n", result.Attempts[1].FailureMessage)
+	assert.True(t, result.Attempts[2].IsSuccessful)
+}
+
+func TestExecuteWithRetryStopsOnNotRetryableFailure(t *testing.T) {
+	defer func() {
+		runbook.ExecuteFunc = runbook.Execute
+		sleepBeforeRetry = defaultSleepBeforeRetry
+	}()
+
+	sleepBeforeRetry = func(ctx context.Context, d time.Duration, quit <-chan struct{}) bool { return true }
+	var executions *int
+	executions, runbook.ExecuteFunc = mockFailingExecute(3, 1)
+
+	result := handleRetriedMessage(t, conf.ActionRetry{
+		MaxAttempts:             3,
+		RetryableStderrPatterns: []string{"timed out"},
+	})
+
+	assert.False(t, result.IsSuccessful)
+	assert.Equal(t, 1, *executions)
+	assert.Len(t, result.Attempts, 1)
+	assert.Equal(t, "Err: exit status 1, Stderr: connection reset\n", result.FailureMessage)
+}
+
+func TestExecuteWithoutRetry(t *testing.T) {
+	defer func() { runbook.ExecuteFunc = runbook.Execute }()
+
+	var executions *int
+	executions, runbook.ExecuteFunc = mockFailingExecute(0, 0)
+
+	result := handleRetriedMessage(t, conf.ActionRetry{})
+
+	assert.True(t, result.IsSuccessful)
+	assert.Equal(t, 1, *executions)
+	assert.Nil(t, result.Attempts)
+}
+
+func TestExecuteWithRetryStopsWhenProcessorQuits(t *testing.T) {
+	defer func() { runbook.ExecuteFunc = runbook.Execute }()
+
+	var executions *int
+	executions, runbook.ExecuteFunc = mockFailingExecute(3, 1)
+
+	quit := make(chan struct{})
+	close(quit)
+
+	start := time.Now()
+	result := handleRetriedMessageUntilQuit(t, conf.ActionRetry{
+		MaxAttempts:       3,
+		BaseDelayInMillis: 60000,
+		MaxDelayInMillis:  60000,
+	}, quit)
+
+	assert.True(t, time.Since(start) < time.Second)
+	assert.False(t, result.IsSuccessful)
+	assert.Equal(t, 1, *executions)
+	assert.Len(t, result.Attempts, 1)
+}
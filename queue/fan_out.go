@@ -0,0 +1,216 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"github.com/atlassian/jec/conf"
+	"github.com/atlassian/jec/runbook"
+	"github.com/atlassian/jec/worker_pool"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const defaultFanOutDeadlineInSeconds = 60
+
+type targetOutcome struct {
+	index  int
+	result runbook.StepResult
+}
+
+// fanOutTarget is run either by a worker of the pool or by the worker of the fan-out action while it waits,
+// whichever claims it first, so that the fan-out action cannot wait for the targets queued behind it.
+// The context of the target is done at the deadline of the fan-out action, which stops the execution of the target.
+type fanOutTarget struct {
+	index    int
+	name     conf.ActionName
+	action   conf.MappedAction
+	ctx      context.Context
+	claimed  int32
+	outcomes chan<- targetOutcome
+}
+
+func (t *fanOutTarget) claim() bool {
+	return atomic.CompareAndSwapInt32(&t.claimed, 0, 1)
+}
+
+// targetJob runs a target of a fan-out action on the worker pool, the targets have the highest priority
+// since the fan-out action holds a worker while waiting for them.
+type targetJob struct {
+	id      string
+	pool    string
+	target  *fanOutTarget
+	handler *messageHandler
+	message *sqs.Message
+	env     []string
+}
+
+func (j *targetJob) Id() string {
+	return j.id
+}
+
+func (j *targetJob) Pool() string {
+	return j.pool
+}
+
+func (j *targetJob) Priority() int {
+	return worker_pool.HighestPriority
+}
+
+func (j *targetJob) Execute() error {
+	if j.target.claim() {
+		j.handler.runTarget(j.target, j.message, j.env)
+	}
+	return nil
+}
+
+func (j *targetJob) HandlePanic(recovered interface{}) {
+	j.target.outcomes <- targetOutcome{j.target.index, runbook.StepResult{
+		Name:           string(j.target.name),
+		FailureMessage: fmt.Sprintf("Target panicked: %v", recovered),
+	}}
+}
+
+// executeFanOut runs the targets of the fan-out action concurrently and adds the outcome of each target to the result.
+// The targets which have not completed before the deadline are reported as failed and their executions are stopped.
+func (mh *messageHandler) executeFanOut(fanOutAction *conf.MappedAction, message *sqs.Message, extraEnv []string, result *runbook.ActionResultPayload) {
+	deadlineInSeconds := fanOutAction.DeadlineInSeconds
+	if deadlineInSeconds <= 0 {
+		deadlineInSeconds = defaultFanOutDeadlineInSeconds
+	}
+	deadline := time.Duration(deadlineInSeconds) * time.Second
+	deadlineAt := time.Now().Add(deadline)
+	ctx, cancel := context.WithDeadline(context.Background(), deadlineAt)
+	defer cancel()
+
+	outcomes := make(chan targetOutcome, len(fanOutAction.Targets))
+	targets := make([]*fanOutTarget, 0, len(fanOutAction.Targets))
+	targetResults := make([]runbook.StepResult, len(fanOutAction.Targets))
+	for index, target := range fanOutAction.Targets {
+		targetResults[index] = runbook.StepResult{
+			Name:             string(target),
+			FailureMessage:   deadlineFailureMessage(deadline),
+			DurationInMillis: int64(deadline / time.Millisecond),
+		}
+		fanOutTarget := &fanOutTarget{
+			index:    index,
+			name:     target,
+			action:   mh.actionSpecs.ActionMappings[target],
+			ctx:      ctx,
+			outcomes: outcomes,
+		}
+		targets = append(targets, fanOutTarget)
+		mh.submitTarget(fanOutTarget, message, extraEnv)
+	}
+
+	// the targets which are still queued are run by the worker of the fan-out action
+	for _, target := range targets {
+		if target.claim() {
+			mh.runTarget(target, message, extraEnv)
+		}
+	}
+
+	timer := time.NewTimer(time.Until(deadlineAt))
+	defer timer.Stop()
+
+collect:
+	for remaining := len(fanOutAction.Targets); remaining > 0; remaining-- {
+		select {
+		case outcome := <-outcomes:
+			targetResults[outcome.index] = outcome.result
+		case <-timer.C:
+			// the outcomes of the targets which completed while the fan-out action was running a target are not late
+			for ; remaining > 0 && len(outcomes) > 0; remaining-- {
+				outcome := <-outcomes
+				targetResults[outcome.index] = outcome.result
+			}
+			if remaining > 0 {
+				logrus.Warnf("%d targets of message[%s] did not complete before the deadline[%s].", remaining, *message.MessageId, deadline)
+			}
+			break collect
+		}
+	}
+
+	failedTargets := make([]string, 0)
+	for _, targetResult := range targetResults {
+		if !targetResult.IsSuccessful {
+			failedTargets = append(failedTargets, targetResult.Name)
+		}
+	}
+
+	result.Targets = targetResults
+	result.IsSuccessful = len(failedTargets) == 0
+	if !result.IsSuccessful {
+		result.FailureMessage = fmt.Sprintf("Targets[%s] of the fan-out action failed.", strings.Join(failedTargets, ", "))
+	}
+}
+
+func deadlineFailureMessage(deadline time.Duration) string {
+	return fmt.Sprintf("Target did not complete before the deadline[%s].", deadline)
+}
+
+// submitTarget submits the target to the worker pool, the targets which cannot be submitted are run by the worker of the fan-out action.
+func (mh *messageHandler) submitTarget(target *fanOutTarget, message *sqs.Message, extraEnv []string) {
+	if mh.workerPool == nil {
+		return
+	}
+
+	job := &targetJob{
+		id:      *message.MessageId + "-" + string(target.name),
+		pool:    target.action.Pool,
+		target:  target,
+		handler: mh,
+		message: message,
+		env:     extraEnv,
+	}
+
+	isSubmitted, err := mh.workerPool.Submit(job)
+	if !isSubmitted {
+		logrus.Debugf("Target[%s] of message[%s] could not be submitted to the worker pool, it will be run by the fan-out action: %v", target.name, *message.MessageId, err)
+	}
+}
+
+// runTarget sends the outcome of the target, the targets which are started after the deadline are skipped.
+// The outcome of a target which is stopped at the deadline is only logged since the result is already reported.
+func (mh *messageHandler) runTarget(target *fanOutTarget, message *sqs.Message, extraEnv []string) {
+	if target.ctx.Err() != nil {
+		logrus.Debugf("Target[%s] of message[%s] is skipped since the deadline of the fan-out action has passed.", target.name, *message.MessageId)
+		return
+	}
+
+	targetResult := mh.executeTarget(target, message, extraEnv)
+	if target.ctx.Err() != nil {
+		logrus.Warnf("Target[%s] of message[%s] completed after the deadline of the fan-out action; successful: %t, failure: %s",
+			target.name, *message.MessageId, targetResult.IsSuccessful, targetResult.FailureMessage)
+	}
+	target.outcomes <- targetOutcome{target.index, targetResult}
+}
+
+func (mh *messageHandler) executeTarget(target *fanOutTarget, message *sqs.Message, extraEnv []string) runbook.StepResult {
+	start := time.Now()
+	targetResult := runbook.StepResult{Name: string(target.name)}
+
+	release, err := mh.acquireAction(target.ctx, target.name)
+	if err == nil {
+		defer release()
+
+		var renderedAction *conf.MappedAction
+		renderedAction, err = target.action.Render([]byte(*message.Body))
+		if err == nil {
+			var attempts []runbook.AttemptResult
+			executionId := *message.MessageId + "-" + string(target.name)
+			_, targetResult.CallbackContext, attempts, err = mh.executeWithRetry(target.ctx, executionId, renderedAction, message, extraEnv, false)
+			targetResult.Attempts = len(attempts)
+		}
+	}
+
+	targetResult.IsSuccessful = err == nil
+	if err != nil {
+		targetResult.FailureMessage = executionFailureMessage(err)
+		logrus.Debugf("Target[%s] of message[%s] failed: %s", target.name, *message.MessageId, targetResult.FailureMessage)
+	}
+	targetResult.DurationInMillis = int64(time.Since(start) / time.Millisecond)
+	return targetResult
+}
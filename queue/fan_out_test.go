@@ -0,0 +1,212 @@
+package queue
+
+import (
+	"context"
+	"github.com/atlassian/jec/conf"
+	"github.com/atlassian/jec/runbook"
+	"github.com/atlassian/jec/worker_pool"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var mockFanOutActionSpecs = conf.ActionSpecifications{
+	ActionMappings: conf.ActionMappings{
+		"NotifyJira":     conf.MappedAction{Type: CustomActionType, SourceType: "local", Filepath: "/path/to/jira.sh", Pool: "tickets"},
+		"NotifyServiceX": conf.MappedAction{Type: CustomActionType, SourceType: "local", Filepath: "/path/to/servicex.sh"},
+		"Notify": conf.MappedAction{
+			Type:              conf.FanOutActionType,
+			Targets:           []conf.ActionName{"NotifyJira", "NotifyServiceX"},
+			DeadlineInSeconds: 1,
+		},
+	},
+}
+
+func handleFanOutMessage(t *testing.T, workerPool worker_pool.WorkerPool) *runbook.ActionResultPayload {
+	body := `{"action":"Notify", "actionType":"custom", "requestId": "RequestId"}`
+	id := "MessageId"
+
+	handler := &messageHandler{
+		actionSpecs:   mockFanOutActionSpecs,
+		actionLoggers: mockActionLoggers,
+		workerPool:    workerPool,
+	}
+	result, err := handler.Handle(sqs.Message{Body: &body, MessageId: &id})
+
+	assert.Nil(t, err)
+	return result
+}
+
+func TestFanOutSuccessfully(t *testing.T) {
+	defer func() { runbook.ExecuteFunc = runbook.Execute }()
+
+	mu := &sync.Mutex{}
+	executionIds := make([]string, 0)
+	runbook.ExecuteFunc = func(ctx context.Context, executionId string, path string, args, env []string, stdout, stderr io.Writer) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		executionIds = append(executionIds, executionId)
+		return "context of " + path, nil
+	}
+
+	submittedJobs := make(map[string]worker_pool.Job)
+	workerPool := NewMockWorkerPool()
+	workerPool.SubmitFunc = func(job worker_pool.Job) (bool, error) {
+		mu.Lock()
+		submittedJobs[job.Id()] = job
+		mu.Unlock()
+		go job.Execute()
+		return true, nil
+	}
+
+	result := handleFanOutMessage(t, workerPool)
+
+	assert.True(t, result.IsSuccessful)
+	assert.Len(t, result.Targets, 2)
+	assert.Equal(t, "NotifyJira", result.Targets[0].Name)
+	assert.Equal(t, "context of /path/to/jira.sh", result.Targets[0].CallbackContext)
+	assert.Equal(t, "NotifyServiceX", result.Targets[1].Name)
+	assert.True(t, result.Targets[1].IsSuccessful)
+
+	sort.Strings(executionIds)
+	assert.Equal(t, []string{"MessageId-NotifyJira", "MessageId-NotifyServiceX"}, executionIds)
+
+	jiraJob := submittedJobs["MessageId-NotifyJira"].(*targetJob)
+	assert.Equal(t, "tickets", jiraJob.Pool())
+	assert.Equal(t, worker_pool.HighestPriority, jiraJob.Priority())
+}
+
+func TestFanOutReportsFailedAndLateTargets(t *testing.T) {
+	defer func() { runbook.ExecuteFunc = runbook.Execute }()
+
+	workers := &sync.WaitGroup{}
+	defer workers.Wait()
+
+	stoppedLateTarget := make(chan error, 1)
+	runbook.ExecuteFunc = func(ctx context.Context, executionId string, path string, args, env []string, stdout, stderr io.Writer) (string, error) {
+		if path == "/path/to/jira.sh" {
+			return "", errors.New("exit status 1")
+		}
+		<-ctx.Done()
+		stoppedLateTarget <- ctx.Err()
+		return "", ctx.Err()
+	}
+
+	workerPool := NewMockWorkerPool()
+	workerPool.SubmitFunc = func(job worker_pool.Job) (bool, error) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			job.Execute()
+		}()
+		// the target is run by the pool rather than by the fan-out action
+		for atomic.LoadInt32(&job.(*targetJob).target.claimed) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		return true, nil
+	}
+
+	result := handleFanOutMessage(t, workerPool)
+
+	assert.False(t, result.IsSuccessful)
+	assert.Equal(t, "Targets[NotifyJira, NotifyServiceX] of the fan-out action failed.", result.FailureMessage)
+	assert.Equal(t, "exit status 1", result.Targets[0].FailureMessage)
+	assert.Equal(t, "Target did not complete before the deadline[1s].", result.Targets[1].FailureMessage)
+
+	select {
+	case err := <-stoppedLateTarget:
+		assert.NotNil(t, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "Late target is not stopped.")
+	}
+}
+
+func TestFanOutRunsQueuedTargetsWhileWaiting(t *testing.T) {
+	defer func() { runbook.ExecuteFunc = runbook.Execute }()
+
+	executionCount := int32(0)
+	runbook.ExecuteFunc = func(ctx context.Context, executionId string, path string, args, env []string, stdout, stderr io.Writer) (string, error) {
+		atomic.AddInt32(&executionCount, 1)
+		return "", nil
+	}
+
+	queuedJobs := make([]worker_pool.Job, 0)
+	workerPool := NewMockWorkerPool()
+	workerPool.SubmitFunc = func(job worker_pool.Job) (bool, error) {
+		queuedJobs = append(queuedJobs, job)
+		return true, nil
+	}
+
+	result := handleFanOutMessage(t, workerPool)
+
+	assert.True(t, result.IsSuccessful)
+	assert.Equal(t, int32(2), executionCount)
+
+	for _, job := range queuedJobs {
+		job.Execute()
+	}
+	assert.Equal(t, int32(2), executionCount)
+}
+
+func TestFanOutTargetIsSkippedAfterDeadline(t *testing.T) {
+	defer func() { runbook.ExecuteFunc = runbook.Execute }()
+
+	runbook.ExecuteFunc = func(ctx context.Context, executionId string, path string, args, env []string, stdout, stderr io.Writer) (string, error) {
+		t.Fatal("Target should not be executed after the deadline.")
+		return "", nil
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	outcomes := make(chan targetOutcome, 1)
+	body := `{"action":"Notify"}`
+	id := "MessageId"
+	job := &targetJob{
+		handler: &messageHandler{actionSpecs: mockFanOutActionSpecs},
+		message: &sqs.Message{Body: &body, MessageId: &id},
+		target: &fanOutTarget{
+			name:     "NotifyJira",
+			action:   mockFanOutActionSpecs.ActionMappings["NotifyJira"],
+			ctx:      ctx,
+			outcomes: outcomes,
+		},
+	}
+
+	assert.Nil(t, job.Execute())
+	assert.Len(t, outcomes, 0)
+}
+
+func TestFanOutTargetWaitsForActionLimiter(t *testing.T) {
+	defer func() { runbook.ExecuteFunc = runbook.Execute }()
+
+	runbook.ExecuteFunc = func(ctx context.Context, executionId string, path string, args, env []string, stdout, stderr io.Writer) (string, error) {
+		return "", nil
+	}
+
+	limiter := NewActionLimiter(defaultIntegrationLabel, conf.ActionSpecifications{ActionMappings: conf.ActionMappings{
+		"NotifyJira": conf.MappedAction{MaxConcurrency: 1},
+	}})
+	release, _, ok := limiter.AcquireAction("NotifyJira")
+	assert.True(t, ok)
+	time.AfterFunc(100*time.Millisecond, release)
+
+	body := `{"action":"Notify", "actionType":"custom", "requestId": "RequestId"}`
+	id := "MessageId"
+	handler := &messageHandler{
+		actionSpecs:   mockFanOutActionSpecs,
+		actionLoggers: mockActionLoggers,
+		actionLimiter: limiter,
+	}
+	result, err := handler.Handle(sqs.Message{Body: &body, MessageId: &id})
+
+	assert.Nil(t, err)
+	assert.True(t, result.IsSuccessful)
+	assert.True(t, result.Targets[0].DurationInMillis >= 100)
+}
@@ -1,10 +1,15 @@
 package queue
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/atlassian/jec/runbook"
+	"github.com/atlassian/jec/util"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"math"
 	"sync"
 	"time"
 )
@@ -17,28 +22,36 @@ const (
 )
 
 type job struct {
-	queueProvider  SQSProvider
-	messageHandler MessageHandler
-
-	message sqs.Message
-	ownerId string
-	apiKey  string
-	baseUrl string
+	queueProvider     SQSProvider
+	messageHandler    MessageHandler
+	signatureVerifier SignatureVerifier
+	actionLimiter     ActionLimiter
+
+	message  sqs.Message
+	pool     string
+	priority int
+	ownerId  string
+	apiKey   string
+	baseUrl  string
 
 	state        int32
+	isAccepted   bool
 	executeMutex *sync.Mutex
 }
 
-func newJob(queueProvider SQSProvider, messageHandler MessageHandler, message sqs.Message, apiKey, baseUrl, ownerId string) *job {
+func newJob(queueProvider SQSProvider, messageHandler MessageHandler, signatureVerifier SignatureVerifier, actionLimiter ActionLimiter,
+	message sqs.Message, apiKey, baseUrl, ownerId string) *job {
 	return &job{
-		queueProvider:  queueProvider,
-		messageHandler: messageHandler,
-		message:        message,
-		ownerId:        ownerId,
-		apiKey:         apiKey,
-		baseUrl:        baseUrl,
-		state:          jobInitial,
-		executeMutex:   &sync.Mutex{},
+		queueProvider:     queueProvider,
+		messageHandler:    messageHandler,
+		signatureVerifier: signatureVerifier,
+		actionLimiter:     actionLimiter,
+		message:           message,
+		ownerId:           ownerId,
+		apiKey:            apiKey,
+		baseUrl:           baseUrl,
+		state:             jobInitial,
+		executeMutex:      &sync.Mutex{},
 	}
 }
 
@@ -46,6 +59,14 @@ func (j *job) Id() string {
 	return *j.message.MessageId
 }
 
+func (j *job) Pool() string {
+	return j.pool
+}
+
+func (j *job) Priority() int {
+	return j.priority
+}
+
 func (j *job) sqsMessage() sqs.Message {
 	return j.message
 }
@@ -63,6 +84,16 @@ func (j *job) Execute() error {
 	region := j.queueProvider.Properties().Region()
 	messageId := j.Id()
 
+	if j.actionLimiter != nil {
+		release, retryAfter, ok := j.actionLimiter.Acquire(&j.message)
+		if !ok {
+			j.deferMessage(retryAfter)
+			j.state = jobFinished
+			return nil
+		}
+		defer release()
+	}
+
 	err := j.queueProvider.DeleteMessage(&j.message)
 	if err != nil {
 		j.state = jobError
@@ -71,29 +102,23 @@ func (j *job) Execute() error {
 
 	logrus.Debugf("Message[%s] is deleted from the queue[%s].", messageId, region)
 
-	messageAttr := j.sqsMessage().MessageAttributes
-
-	if messageAttr == nil ||
-		*messageAttr[ownerId].StringValue != j.ownerId &&
-			*messageAttr[channelId].StringValue != j.ownerId {
+	if !j.isOwned() {
 		j.state = jobError
 		return errors.Errorf("Message[%s] is invalid, will not be processed.", messageId)
 	}
 
+	if err := j.verifySignature(); err != nil {
+		j.state = jobError
+		return errors.Errorf("Message[%s] could not be verified, will not be processed: %s", messageId, err)
+	}
+
+	// the message is deleted and it belongs to the owner, a panic from now on is reported as the result
+	j.isAccepted = true
+
 	result, err := j.messageHandler.Handle(j.message)
 
 	if result != nil {
-		go func() {
-			start := time.Now()
-
-			err = runbook.SendResultToJsmFunc(result, j.apiKey, j.baseUrl)
-			if err != nil {
-				logrus.Warnf("Could not send action result[%+v] of message[%s] to Jira Service Management: %s", result, messageId, err)
-			} else {
-				took := time.Since(start)
-				logrus.Debugf("Successfully sent result of message[%s] to Jira Service Management and it took %f seconds.", messageId, took.Seconds())
-			}
-		}()
+		go j.sendResult(result)
 	}
 
 	if err != nil {
@@ -104,3 +129,82 @@ func (j *job) Execute() error {
 	j.state = jobFinished
 	return nil
 }
+
+func (j *job) sendResult(result *runbook.ActionResultPayload) {
+	start := time.Now()
+
+	err := runbook.SendResultToJsmFunc(result, j.apiKey, j.baseUrl)
+	if err != nil {
+		logrus.Warnf("Could not send action result[%+v] of message[%s] to Jira Service Management: %s", result, j.Id(), err)
+	} else {
+		took := time.Since(start)
+		logrus.Debugf("Successfully sent result of message[%s] to Jira Service Management and it took %f seconds.", j.Id(), took.Seconds())
+	}
+}
+
+// HandlePanic reports the message as failed when the execution panics after the message is accepted. A message which
+// is not deleted yet is delivered again, and a message which is not verified to belong to the owner is not reported.
+// The executeMutex is already released by the deferred unlock of Execute.
+func (j *job) HandlePanic(recovered interface{}) {
+	j.executeMutex.Lock()
+	j.state = jobError
+	isAccepted := j.isAccepted
+	j.executeMutex.Unlock()
+
+	if !isAccepted {
+		logrus.Warnf("Processing of message[%s] panicked before it was accepted, its result will not be sent: %v", j.Id(), recovered)
+		return
+	}
+
+	queuePayload := payload{}
+	_ = json.Unmarshal([]byte(aws.StringValue(j.message.Body)), &queuePayload)
+
+	result := &runbook.ActionResultPayload{
+		EntityId:       queuePayload.Entity.Id,
+		EntityType:     queuePayload.Entity.Type,
+		Action:         queuePayload.actionName(),
+		ActionType:     queuePayload.ActionType,
+		RequestId:      queuePayload.RequestId,
+		IsSuccessful:   false,
+		FailureMessage: fmt.Sprintf("Processing of message[%s] panicked: %v", j.Id(), recovered),
+	}
+
+	go j.sendResult(result)
+}
+
+// deferMessage keeps the message in the queue and makes it visible again after the given duration.
+func (j *job) deferMessage(retryAfter time.Duration) {
+	region := j.queueProvider.Properties().Region()
+	visibilityTimeout := util.Max(1, int64(math.Ceil(retryAfter.Seconds())))
+
+	err := j.queueProvider.ChangeMessageVisibility(&j.message, visibilityTimeout)
+	if err != nil {
+		logrus.Warnf("Message[%s] is over the limits of its action but could not be deferred in the queue[%s]: %s", j.Id(), region, err)
+		return
+	}
+	logrus.Debugf("Message[%s] is over the limits of its action, it is deferred %d seconds in the queue[%s].", j.Id(), visibilityTimeout, region)
+}
+
+// isOwned reports whether the owner or the channel attribute of the message is the owner of the job.
+func (j *job) isOwned() bool {
+	messageAttr := j.message.MessageAttributes
+	for _, name := range []string{ownerId, channelId} {
+		if attr := messageAttr[name]; attr != nil && aws.StringValue(attr.StringValue) == j.ownerId {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *job) verifySignature() error {
+	if j.signatureVerifier == nil {
+		return nil
+	}
+
+	signatureAttr, ok := j.message.MessageAttributes[signature]
+	if !ok || aws.StringValue(signatureAttr.StringValue) == "" {
+		return errors.New("Message is not signed.")
+	}
+
+	return j.signatureVerifier.Verify([]byte(aws.StringValue(j.message.Body)), *signatureAttr.StringValue)
+}
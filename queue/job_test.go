@@ -2,6 +2,7 @@ package queue
 
 import (
 	"encoding/json"
+	"github.com/atlassian/jec/conf"
 	"github.com/atlassian/jec/runbook"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/pkg/errors"
@@ -11,6 +12,7 @@ import (
 	"net/http/httptest"
 	"sync"
 	"testing"
+	"time"
 )
 
 var mockActionResultPayload = &runbook.ActionResultPayload{
@@ -202,3 +204,154 @@ func TestExecuteWithInvalidQueueMessage(t *testing.T) {
 
 	assert.Equal(t, expectedState, actualState)
 }
+
+func TestExecuteWithoutOwnerAttribute(t *testing.T) {
+
+	sqsJob := newJobTest()
+	sqsJob.message.MessageAttributes = map[string]*sqs.MessageAttributeValue{channelId: {}}
+
+	err := sqsJob.Execute()
+
+	assert.EqualError(t, err, "Message[mockMessageId] is invalid, will not be processed.")
+	assert.Equal(t, int32(jobError), sqsJob.state)
+	assert.False(t, sqsJob.isAccepted)
+}
+
+func TestExecuteWithValidSignature(t *testing.T) {
+
+	wg := &sync.WaitGroup{}
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusAccepted)
+		wg.Done()
+	}))
+	defer testServer.Close()
+
+	sqsJob := newJobTest()
+	sqsJob.signatureVerifier = &hmacVerifier{key: []byte("secret")}
+	sqsJob.baseUrl = testServer.URL
+
+	encodedSignature := signHmac([]byte("secret"), *sqsJob.message.Body)
+	sqsJob.message.MessageAttributes[signature] = &sqs.MessageAttributeValue{StringValue: &encodedSignature}
+
+	wg.Add(1)
+	err := sqsJob.Execute()
+
+	wg.Wait()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(jobFinished), sqsJob.state)
+}
+
+func TestExecuteWithInvalidSignature(t *testing.T) {
+
+	sqsJob := newJobTest()
+	sqsJob.signatureVerifier = &hmacVerifier{key: []byte("secret")}
+
+	handled := false
+	sqsJob.messageHandler.(*MockMessageHandler).HandleFunc = func(message sqs.Message) (*runbook.ActionResultPayload, error) {
+		handled = true
+		return mockActionResultPayload, nil
+	}
+
+	encodedSignature := signHmac([]byte("anotherSecret"), *sqsJob.message.Body)
+	sqsJob.message.MessageAttributes[signature] = &sqs.MessageAttributeValue{StringValue: &encodedSignature}
+
+	err := sqsJob.Execute()
+
+	expectedErr := errors.Errorf("Message[%s] could not be verified, will not be processed: %s", sqsJob.Id(), "Signature does not match.")
+	assert.EqualError(t, err, expectedErr.Error())
+	assert.False(t, handled)
+	assert.Equal(t, int32(jobError), sqsJob.state)
+}
+
+func TestExecuteWithoutSignature(t *testing.T) {
+
+	sqsJob := newJobTest()
+	sqsJob.signatureVerifier = &hmacVerifier{key: []byte("secret")}
+
+	err := sqsJob.Execute()
+
+	expectedErr := errors.Errorf("Message[%s] could not be verified, will not be processed: %s", sqsJob.Id(), "Message is not signed.")
+	assert.EqualError(t, err, expectedErr.Error())
+	assert.Equal(t, int32(jobError), sqsJob.state)
+}
+
+func TestExecuteWithLimitedAction(t *testing.T) {
+
+	sqsJob := newJobTest()
+	sqsJob.actionLimiter = NewActionLimiter(defaultIntegrationLabel, conf.ActionSpecifications{ActionMappings: conf.ActionMappings{
+		"Restart": conf.MappedAction{Type: CustomActionType, MaxConcurrency: 1},
+	}})
+	body := `{"actionType":"custom", "action":"Restart"}`
+	sqsJob.message.Body = &body
+
+	release, _, ok := sqsJob.actionLimiter.Acquire(&sqsJob.message)
+	assert.True(t, ok)
+	defer release()
+
+	deferredVisibilityTimeout := int64(0)
+	sqsJob.queueProvider.(*MockSQSProvider).ChangeMessageVisibilityFunc = func(message *sqs.Message, visibilityTimeout int64) error {
+		deferredVisibilityTimeout = visibilityTimeout
+		return nil
+	}
+	sqsJob.queueProvider.(*MockSQSProvider).DeleteMessageFunc = func(message *sqs.Message) error {
+		assert.Fail(t, "Deferred message should not be deleted.")
+		return nil
+	}
+
+	err := sqsJob.Execute()
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(concurrencyDeferralInSec), deferredVisibilityTimeout)
+	assert.Equal(t, int32(jobFinished), sqsJob.state)
+}
+
+func TestHandlePanic(t *testing.T) {
+
+	wg := &sync.WaitGroup{}
+	defer func() { runbook.SendResultToJsmFunc = runbook.SendResultToJsm }()
+
+	var sentResult *runbook.ActionResultPayload
+	runbook.SendResultToJsmFunc = func(result *runbook.ActionResultPayload, apiKey, baseUrl string) error {
+		sentResult = result
+		wg.Done()
+		return nil
+	}
+
+	sqsJob := newJobTest()
+	body := `{"actionType":"custom", "action":"Restart", "requestId": "RequestId", "entity": {"id": "alertId", "type": "alert"}}`
+	sqsJob.message.Body = &body
+	sqsJob.state = jobExecuting
+	sqsJob.isAccepted = true
+
+	wg.Add(1)
+	sqsJob.HandlePanic("unexpected")
+	wg.Wait()
+
+	assert.Equal(t, int32(jobError), sqsJob.state)
+	assert.Equal(t, &runbook.ActionResultPayload{
+		EntityId:       "alertId",
+		EntityType:     "alert",
+		Action:         "Restart",
+		ActionType:     CustomActionType,
+		RequestId:      "RequestId",
+		FailureMessage: "Processing of message[mockMessageId] panicked: unexpected",
+	}, sentResult)
+}
+
+func TestHandlePanicBeforeMessageIsAccepted(t *testing.T) {
+
+	defer func() { runbook.SendResultToJsmFunc = runbook.SendResultToJsm }()
+	runbook.SendResultToJsmFunc = func(result *runbook.ActionResultPayload, apiKey, baseUrl string) error {
+		assert.Fail(t, "Result of the message which is not accepted should not be sent.")
+		return nil
+	}
+
+	sqsJob := newJobTest()
+	sqsJob.state = jobExecuting
+
+	sqsJob.HandlePanic("unexpected")
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Equal(t, int32(jobError), sqsJob.state)
+}
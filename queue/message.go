@@ -2,26 +2,43 @@ package queue
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/atlassian/jec/conf"
+	"github.com/atlassian/jec/dedupe"
 	"github.com/atlassian/jec/git"
 	"github.com/atlassian/jec/runbook"
+	"github.com/atlassian/jec/util"
+	"github.com/atlassian/jec/worker_pool"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"io"
+	"strconv"
 	"time"
 )
 
+// resolutionErrorEnv passes the reason why the action could not be resolved to the fallback action.
+const resolutionErrorEnv = "JEC_RESOLUTION_ERROR"
+
 type MessageHandler interface {
 	Handle(message sqs.Message) (*runbook.ActionResultPayload, error)
 }
 
 type messageHandler struct {
-	repositories  git.Repositories
-	actionSpecs   conf.ActionSpecifications
-	actionLoggers map[string]io.Writer
+	repositories     git.Repositories
+	actionSpecs      conf.ActionSpecifications
+	actionLoggers    map[string]io.Writer
+	dedupeStore      dedupe.Store
+	inFlightRequests *util.KeyedMutex
+	serializationKey string
+	executionLocks   *util.KeyedMutex
+	workerPool       worker_pool.WorkerPool
+	actionLimiter    ActionLimiter
+	integration      string
+	quit             <-chan struct{}
 }
 
 func NewMessageHandler(repositories git.Repositories, actionSpecs conf.ActionSpecifications, actionLoggers map[string]io.Writer) MessageHandler {
@@ -40,14 +57,23 @@ func (mh *messageHandler) Handle(message sqs.Message) (*runbook.ActionResultPayl
 	}
 
 	actionType := queuePayload.ActionType
-	action := queuePayload.MappedAction.Name
-	if action == "" {
-		action = queuePayload.Action
-	}
+	action := queuePayload.actionName()
 	if action == "" {
 		return nil, errors.Errorf("SQS message does not contain action property.")
 	}
 
+	if mh.dedupeStore != nil {
+		dedupeKey := requestDedupeKey(queuePayload.RequestId, &message)
+		mh.inFlightRequests.Lock(dedupeKey)
+		defer mh.inFlightRequests.Unlock(dedupeKey)
+
+		if previousResult := mh.previousResult(queuePayload.RequestId, &message); previousResult != nil {
+			logrus.Infof("Message[%s] of request[%s] is a duplicate, the outcome of its previous execution will be reported.",
+				aws.StringValue(message.MessageId), queuePayload.RequestId)
+			return previousResult, nil
+		}
+	}
+
 	result := &runbook.ActionResultPayload{
 		EntityId:   queuePayload.Entity.Id,
 		EntityType: queuePayload.Entity.Type,
@@ -56,27 +82,74 @@ func (mh *messageHandler) Handle(message sqs.Message) (*runbook.ActionResultPayl
 		RequestId:  queuePayload.RequestId,
 	}
 
-	mappedAction, err := mh.resolveMappedAction(action, actionType)
-	if err != nil {
+	var resolutionEnv []string
+	mappedActionName, reason := resolveActionName(&mh.actionSpecs, &queuePayload)
+	if mappedActionName == "" {
+		err := errors.Errorf("%s The request will be ignored.", reason)
 		result.IsSuccessful = false
 		result.FailureMessage = err.Error()
 		return result, err
 	}
+	if reason != nil {
+		logrus.Infof("Action[%s] of message[%s] will be handled by the fallback action[%s]: %s",
+			action, aws.StringValue(message.MessageId), mappedActionName, reason)
+		resolutionEnv = []string{resolutionErrorEnv + "=" + reason.Error()}
+	} else if string(mappedActionName) != action {
+		logrus.Debugf("Action[%s] of request[%s] is routed to mapped action[%s].", action, queuePayload.RequestId, mappedActionName)
+	}
+	resolvedAction := mh.actionSpecs.ActionMappings[mappedActionName]
+	mappedAction := &resolvedAction
+
+	if err := checkMessageAge(mappedAction, &message, time.Now()); err != nil {
+		result.IsSuccessful = false
+		result.FailureMessage = err.Error()
+		result.FailureReason = runbook.MessageExpiredFailureReason
+		return result, err
+	}
+
+	mappedAction, err = mappedAction.Render([]byte(*message.Body))
+	if err != nil {
+		result.IsSuccessful = false
+		result.FailureMessage = fmt.Sprintf("Mapped action of action[%s] could not be rendered: %s", action, err)
+		return result, err
+	}
+
+	// the messages without a key, e.g. without an entity, are not serialized behind each other
+	if executionKey := mh.executionKey(&queuePayload, action); mh.executionLocks != nil && executionKey != "" {
+		mh.lockExecution(executionKey, action, &message)
+		defer mh.executionLocks.Unlock(executionKey)
+	}
 
 	start := time.Now()
-	executionResult, callbackContext, err := mh.execute(mappedAction, &message)
+	switch mappedAction.Type {
+	case conf.WorkflowActionType:
+		mh.executeWorkflow(mappedAction, &message, resolutionEnv, result)
+		logrus.Debugf("Workflow[%s] execution of message[%s] has been completed and it took %f seconds.", action, *message.MessageId, time.Since(start).Seconds())
+
+		mh.saveResult(queuePayload.RequestId, &message, result)
+		return result, nil
+	case conf.FanOutActionType:
+		mh.executeFanOut(mappedAction, &message, resolutionEnv, result)
+		logrus.Debugf("Fan-out action[%s] execution of message[%s] has been completed and it took %f seconds.", action, *message.MessageId, time.Since(start).Seconds())
+
+		mh.saveResult(queuePayload.RequestId, &message, result)
+		return result, nil
+	}
+
+	executionResult, callbackContext, attempts, err := mh.executeWithRetry(context.Background(), *message.MessageId, mappedAction, &message, resolutionEnv, false)
 	took := time.Since(start)
 
 	result.CallbackContext = callbackContext
+	result.Attempts = attempts
 
 	switch err := err.(type) {
 	case *runbook.ExecError:
 		result.IsSuccessful = false
-		result.FailureMessage = fmt.Sprintf("Err: %s, Stderr: %s", err.Error(), err.Stderr)
+		result.FailureMessage = executionFailureMessage(err)
 		logrus.Debugf("Action[%s] execution of message[%s] failed: %s Stderr: %s", action, *message.MessageId, err.Error(), err.Stderr)
 	case nil:
 		result.IsSuccessful = true
-		if !queuePayload.DiscardScriptResponse && queuePayload.ActionType == HttpActionType {
+		if !queuePayload.DiscardScriptResponse && mappedAction.Type == HttpActionType {
 			httpResult := &runbook.HttpResponse{}
 			err := json.Unmarshal([]byte(executionResult), httpResult)
 			if err != nil {
@@ -94,28 +167,126 @@ func (mh *messageHandler) Handle(message sqs.Message) (*runbook.ActionResultPayl
 		return nil, err
 	}
 
+	mh.saveResult(queuePayload.RequestId, &message, result)
 	return result, nil
 }
 
-func (mh *messageHandler) resolveMappedAction(action string, actionType string) (*conf.MappedAction, error) {
-	mappedAction, ok := mh.actionSpecs.ActionMappings[conf.ActionName(action)]
+func (mh *messageHandler) executionKey(queuePayload *payload, action string) string {
+	switch mh.serializationKey {
+	case conf.EntityTypeSerializationKey:
+		return queuePayload.Entity.Type
+	case conf.ActionSerializationKey:
+		return action
+	default:
+		return queuePayload.Entity.Id
+	}
+}
+
+// lockExecution blocks until the executions which share the same key with the message have been completed.
+func (mh *messageHandler) lockExecution(executionKey string, action string, message *sqs.Message) {
+	integration := integrationLabel(mh.integration)
+	serializationWaitingExecutions.WithLabelValues(integration).Inc()
+	start := time.Now()
 
+	mh.executionLocks.Lock(executionKey)
+
+	waited := time.Since(start)
+	serializationWaitingExecutions.WithLabelValues(integration).Dec()
+	serializationWaitSeconds.WithLabelValues(integration, action).Observe(waited.Seconds())
+
+	if waited > time.Millisecond {
+		logrus.Debugf("Action[%s] execution of message[%s] waited %s for the executions of %s[%s].",
+			action, aws.StringValue(message.MessageId), waited, mh.serializationKey, executionKey)
+	}
+}
+
+func requestDedupeKey(requestId string, message *sqs.Message) string {
+	if requestId != "" {
+		return "request:" + requestId
+	}
+	return messageDedupeKey(message)
+}
+
+func messageDedupeKey(message *sqs.Message) string {
+	return "message:" + aws.StringValue(message.MessageId)
+}
+
+func (mh *messageHandler) previousResult(requestId string, message *sqs.Message) *runbook.ActionResultPayload {
+	value, ok := mh.dedupeStore.Get(requestDedupeKey(requestId, message))
+	if !ok {
+		value, ok = mh.dedupeStore.Get(messageDedupeKey(message))
+	}
 	if !ok {
-		failureMessage := fmt.Sprintf("No mapped action is configured for requested action[%s]. "+
-			"The request will be ignored.", action)
-		return nil, errors.Errorf(failureMessage)
+		return nil
 	}
 
-	if mappedAction.Type != actionType {
-		failureMessage := fmt.Sprintf("The type[%s] of the mapped action[%s] is not compatible with requested type[%s]. "+
-			"The request will be ignored.", mappedAction.Type, action, actionType)
-		return nil, errors.Errorf(failureMessage)
+	result := &runbook.ActionResultPayload{}
+	if err := json.Unmarshal(value, result); err != nil {
+		logrus.Warnf("Previous result of message[%s] could not be read from dedupe store: %s", aws.StringValue(message.MessageId), err)
+		return nil
 	}
+	return result
+}
 
-	return &mappedAction, nil
+func (mh *messageHandler) saveResult(requestId string, message *sqs.Message, result *runbook.ActionResultPayload) {
+	if mh.dedupeStore == nil {
+		return
+	}
+
+	value, err := json.Marshal(result)
+	if err == nil {
+		err = mh.dedupeStore.Put(value, requestDedupeKey(requestId, message), messageDedupeKey(message))
+	}
+	if err != nil {
+		logrus.Warnf("Result of message[%s] could not be saved to dedupe store: %s", aws.StringValue(message.MessageId), err)
+	}
+}
+
+func checkMessageAge(mappedAction *conf.MappedAction, message *sqs.Message, now time.Time) error {
+	if mappedAction.MaxMessageAgeInSeconds <= 0 {
+		return nil
+	}
+
+	sentAt, ok := messageSentTime(message)
+	if !ok {
+		return nil
+	}
+
+	age := now.Sub(sentAt)
+	maxMessageAge := time.Duration(mappedAction.MaxMessageAgeInSeconds) * time.Second
+	if age > maxMessageAge {
+		return errors.Errorf("Message[%s] is expired, it was sent %s ago which exceeds the max message age[%s] of the mapped action. "+
+			"The request will be ignored.", aws.StringValue(message.MessageId), age.Round(time.Second), maxMessageAge)
+	}
+	return nil
+}
+
+func messageSentTime(message *sqs.Message) (time.Time, bool) {
+	sentTimestamp, err := strconv.ParseInt(aws.StringValue(message.Attributes[sentTimestamp]), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, sentTimestamp*int64(time.Millisecond)), true
+}
+
+func messageReceiveCount(message *sqs.Message) int64 {
+	receiveCount, err := strconv.ParseInt(aws.StringValue(message.Attributes[approximateReceiveCount]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return receiveCount
+}
+
+func executionFailureMessage(err error) string {
+	if execErr, ok := err.(*runbook.ExecError); ok {
+		return fmt.Sprintf("Err: %s, Stderr: %s", execErr.Error(), execErr.Stderr)
+	}
+	return err.Error()
 }
 
-func (mh *messageHandler) execute(mappedAction *conf.MappedAction, message *sqs.Message) (string, string, error) {
+// execute runs the mapped action and returns its stdout if it is an http action or captureStdout is set.
+// The execution id should be unique among the concurrent executions.
+func (mh *messageHandler) execute(ctx context.Context, executionId string, mappedAction *conf.MappedAction, message *sqs.Message, extraEnv []string, captureStdout bool) (string, string, error) {
 
 	sourceType := mappedAction.SourceType
 	switch sourceType {
@@ -138,11 +309,12 @@ func (mh *messageHandler) execute(mappedAction *conf.MappedAction, message *sqs.
 		args = append(args, []string{"-payload", *message.Body}...)
 		args = append(args, mh.actionSpecs.GlobalArgs...)
 		args = append(args, mappedAction.Args...)
-		env := append(mh.actionSpecs.GlobalEnv, mappedAction.Env...)
+		env := append(append([]string{}, mh.actionSpecs.GlobalEnv...), mappedAction.Env...)
+		env = append(env, extraEnv...)
 
 		stdout := mh.actionLoggers[mappedAction.Stdout]
 		stdoutBuff := &bytes.Buffer{}
-		if mappedAction.Type == HttpActionType {
+		if mappedAction.Type == HttpActionType || captureStdout {
 			if stdout != nil {
 				stdout = io.MultiWriter(stdoutBuff, mh.actionLoggers[mappedAction.Stdout])
 			} else {
@@ -151,7 +323,7 @@ func (mh *messageHandler) execute(mappedAction *conf.MappedAction, message *sqs.
 		}
 		stderr := mh.actionLoggers[mappedAction.Stderr]
 
-		callbackContext, err := runbook.ExecuteFunc(*message.MessageId, mappedAction.Filepath, args, env, stdout, stderr)
+		callbackContext, err := runbook.ExecuteFunc(ctx, executionId, mappedAction.Filepath, args, env, stdout, stderr)
 		return stdoutBuff.String(), callbackContext, err
 	default:
 		return "", "", errors.Errorf("Unknown action sourceType[%s].", sourceType)
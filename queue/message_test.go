@@ -2,14 +2,19 @@ package queue
 
 import (
 	"bytes"
+	"context"
 	"github.com/atlassian/jec/conf"
 	"github.com/atlassian/jec/git"
 	"github.com/atlassian/jec/runbook"
+	"github.com/atlassian/jec/util"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -45,6 +50,12 @@ var mockActionMappings = conf.ActionMappings{
 		Filepath: "jec/testConfig.json",
 		Env:      []string{"e1=v1", "e2=v2"},
 	},
+	"Restart": conf.MappedAction{
+		Type:                   CustomActionType,
+		SourceType:             "local",
+		Filepath:               "/path/to/restart.sh",
+		MaxMessageAgeInSeconds: 60,
+	},
 	"Retrieve": conf.MappedAction{
 		Type:       HttpActionType,
 		SourceType: "local",
@@ -63,7 +74,7 @@ var mockActionLoggers = map[string]io.Writer{
 	"/path/to/stderr": mockStderr,
 }
 
-func mockExecute(executionId string, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) (string, error) {
+func mockExecute(ctx context.Context, executionId string, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) (string, error) {
 	return "", nil
 }
 
@@ -71,9 +82,16 @@ func TestProcess(t *testing.T) {
 
 	t.Run("TestProcessSuccessfully", testProcessSuccessfully)
 	t.Run("TestProcessMappedActionNotFound", testProcessMappedActionNotFound)
+	t.Run("TestProcessDuplicateMessage", testProcessDuplicateMessage)
+	t.Run("TestProcessSerializedMessages", testProcessSerializedMessages)
 	t.Run("TestProcessActionTypeNotMatched", testProcessActionTypeNotMatched)
 	t.Run("TestProcessFieldMissing", testProcessFieldMissing)
 	t.Run("TestProcessHttpActionSuccessfully", testProcessHttpActionSuccessfully)
+	t.Run("TestProcessExpiredMessage", testProcessExpiredMessage)
+	t.Run("TestProcessNotExpiredMessage", testProcessNotExpiredMessage)
+	t.Run("TestProcessRoutedMessage", testProcessRoutedMessage)
+	t.Run("TestProcessWithFallbackAction", testProcessWithFallbackAction)
+	t.Run("TestProcessTemplatedAction", testProcessTemplatedAction)
 
 	runbook.ExecuteFunc = runbook.Execute
 }
@@ -85,7 +103,7 @@ func testProcessSuccessfully(t *testing.T) {
 	message := sqs.Message{Body: &body, MessageId: &id}
 	queueMessage := NewMessageHandler(nil, mockActionSpecs, mockActionLoggers)
 
-	runbook.ExecuteFunc = func(executionId string, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) (string, error) {
+	runbook.ExecuteFunc = func(ctx context.Context, executionId string, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) (string, error) {
 		assert.Equal(t, mockStdout, stdout)
 		assert.Equal(t, mockStderr, stderr)
 		return "", nil
@@ -98,8 +116,130 @@ func testProcessSuccessfully(t *testing.T) {
 	assert.True(t, result.IsSuccessful)
 }
 
+func testProcessRoutedMessage(t *testing.T) {
+
+	actionSpecs := conf.ActionSpecifications{
+		ActionMappings: conf.ActionMappings{
+			"Create":         conf.MappedAction{Type: CustomActionType, SourceType: "local", Filepath: "/path/to/action.bin"},
+			"CreateDatabase": conf.MappedAction{Type: CustomActionType, SourceType: "local", Filepath: "/path/to/database.bin"},
+			"CreateCritical": conf.MappedAction{Type: CustomActionType, SourceType: "local", Filepath: "/path/to/critical.bin"},
+		},
+		Routes: []conf.Route{
+			{Action: "Create", Tags: []string{"database"}, MappedAction: "CreateDatabase"},
+			{Action: "Create", Priority: "P1", MappedAction: "CreateCritical"},
+		},
+	}
+	queueMessage := NewMessageHandler(nil, actionSpecs, mockActionLoggers)
+
+	var executablePath string
+	runbook.ExecuteFunc = func(ctx context.Context, executionId string, path string, args, environmentVars []string, stdout, stderr io.Writer) (string, error) {
+		executablePath = path
+		return "", nil
+	}
+
+	body := `{"action":"Create", "actionType":"custom", "alert": {"priority": "P1", "tags": ["web", "database"]}}`
+	id := "MessageId"
+	result, err := queueMessage.Handle(sqs.Message{Body: &body, MessageId: &id})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Create", result.Action)
+	assert.Equal(t, "/path/to/database.bin", executablePath)
+
+	body = `{"action":"Create", "actionType":"custom", "alert": {"priority": "P1", "tags": ["web"]}}`
+	queueMessage.Handle(sqs.Message{Body: &body, MessageId: &id})
+	assert.Equal(t, "/path/to/critical.bin", executablePath)
+
+	body = `{"action":"Create", "actionType":"custom", "alert": {"priority": "P3"}}`
+	queueMessage.Handle(sqs.Message{Body: &body, MessageId: &id})
+	assert.Equal(t, "/path/to/action.bin", executablePath)
+}
+
+func testProcessTemplatedAction(t *testing.T) {
+
+	actionSpecs := conf.ActionSpecifications{
+		ActionMappings: conf.ActionMappings{
+			"Close": conf.MappedAction{
+				Type:       CustomActionType,
+				SourceType: "local",
+				Filepath:   "/usr/bin/tool",
+				Flags:      conf.Flags{"priority": "{{ .alert.priority }}"},
+				Args:       []string{"close", "{{ .entity.id }}"},
+				Env:        []string{"ENTITY_TYPE={{ .entity.type }}"},
+			},
+		},
+	}
+	queueMessage := NewMessageHandler(nil, actionSpecs, mockActionLoggers)
+
+	var args, env []string
+	runbook.ExecuteFunc = func(ctx context.Context, executionId string, path string, arguments, environmentVars []string, stdout, stderr io.Writer) (string, error) {
+		args = arguments
+		env = environmentVars
+		return "", nil
+	}
+
+	body := `{"action":"Close", "actionType":"custom", "requestId": "RequestId", "entity":{"id":"1234","type":"alert"}, "alert":{"priority":"P2"}}`
+	id := "MessageId"
+	result, err := queueMessage.Handle(sqs.Message{Body: &body, MessageId: &id})
+
+	assert.Nil(t, err)
+	assert.True(t, result.IsSuccessful)
+	assert.Equal(t, []string{"-priority", "P2", "-payload", body, "close", "1234"}, args)
+	assert.Equal(t, []string{"ENTITY_TYPE=alert"}, env)
+
+	body = `{"action":"Close", "actionType":"custom", "requestId": "RequestId", "entity":{"id":"1234","type":"alert"}}`
+	result, err = queueMessage.Handle(sqs.Message{Body: &body, MessageId: &id})
+
+	assert.NotNil(t, err)
+	assert.False(t, result.IsSuccessful)
+	assert.Contains(t, result.FailureMessage, "Mapped action of action[Close] could not be rendered")
+}
+
+func testProcessWithFallbackAction(t *testing.T) {
+
+	actionSpecs := conf.ActionSpecifications{
+		ActionMappings: conf.ActionMappings{
+			"Create": conf.MappedAction{Type: HttpActionType, SourceType: "local", Filepath: "/path/to/http.bin"},
+			"Audit":  conf.MappedAction{Type: CustomActionType, SourceType: "local", Filepath: "/path/to/audit.bin"},
+		},
+		FallbackAction: "Audit",
+	}
+	queueMessage := NewMessageHandler(nil, actionSpecs, mockActionLoggers)
+
+	var executablePath string
+	var env []string
+	runbook.ExecuteFunc = func(ctx context.Context, executionId string, path string, args, environmentVars []string, stdout, stderr io.Writer) (string, error) {
+		executablePath = path
+		env = environmentVars
+		return "", nil
+	}
+
+	body := `{"action":"Acknowledge", "actionType":"custom", "requestId": "RequestId"}`
+	id := "MessageId"
+	result, err := queueMessage.Handle(sqs.Message{Body: &body, MessageId: &id})
+
+	assert.Nil(t, err)
+	assert.True(t, result.IsSuccessful)
+	assert.Equal(t, "Acknowledge", result.Action)
+	assert.Equal(t, "/path/to/audit.bin", executablePath)
+	assert.Equal(t, []string{"JEC_RESOLUTION_ERROR=No mapped action is configured for requested action[Acknowledge]."}, env)
+
+	body = `{"action":"Create", "actionType":"custom", "requestId": "RequestId"}`
+	result, err = queueMessage.Handle(sqs.Message{Body: &body, MessageId: &id})
+
+	assert.Nil(t, err)
+	assert.True(t, result.IsSuccessful)
+	assert.Equal(t, "/path/to/audit.bin", executablePath)
+	assert.Contains(t, env[0], "is not compatible with requested type[custom].")
+
+	body = `{"action":"Acknowledge", "actionType":"http", "requestId": "RequestId"}`
+	result, err = queueMessage.Handle(sqs.Message{Body: &body, MessageId: &id})
+
+	assert.EqualError(t, err, "No mapped action is configured for requested action[Acknowledge]. The request will be ignored.")
+	assert.False(t, result.IsSuccessful)
+}
+
 func testProcessHttpActionSuccessfully(t *testing.T) {
-	runbook.ExecuteFunc = func(executionId string, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) (string, error) {
+	runbook.ExecuteFunc = func(ctx context.Context, executionId string, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) (string, error) {
 		io.Copy(stdout, bytes.NewBufferString(`{"headers": {"Date": "Wed, 14 Oct 2020 08:59:30 GMT"},"body": "done", "statusCode": 200}`))
 		return "", nil
 	}
@@ -165,6 +305,138 @@ func testProcessActionTypeNotMatched(t *testing.T) {
 	assert.Equal(t, result, expectedResult)
 }
 
+func testProcessExpiredMessage(t *testing.T) {
+
+	executed := false
+	runbook.ExecuteFunc = func(ctx context.Context, executionId string, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) (string, error) {
+		executed = true
+		return "", nil
+	}
+
+	body := `{"actionType":"custom", "action":"Restart", "requestId": "RequestId"}`
+	sentTimestamp := strconv.FormatInt(time.Now().Add(-2*time.Hour).UnixNano()/int64(time.Millisecond), 10)
+	message := sqs.Message{
+		Body:       &body,
+		MessageId:  &mockMessageId,
+		Attributes: map[string]*string{"SentTimestamp": &sentTimestamp},
+	}
+	messageHandler := NewMessageHandler(nil, mockActionSpecs, mockActionLoggers)
+
+	result, err := messageHandler.Handle(message)
+
+	assert.NotNil(t, err)
+	assert.False(t, executed)
+	assert.False(t, result.IsSuccessful)
+	assert.Equal(t, runbook.MessageExpiredFailureReason, result.FailureReason)
+	assert.Contains(t, result.FailureMessage, "Message[mockMessageId] is expired")
+}
+
+func testProcessNotExpiredMessage(t *testing.T) {
+
+	runbook.ExecuteFunc = mockExecute
+
+	body := `{"actionType":"custom", "action":"Restart", "requestId": "RequestId"}`
+	sentTimestamp := strconv.FormatInt(time.Now().Add(-time.Second).UnixNano()/int64(time.Millisecond), 10)
+	message := sqs.Message{
+		Body:       &body,
+		MessageId:  &mockMessageId,
+		Attributes: map[string]*string{"SentTimestamp": &sentTimestamp},
+	}
+	messageHandler := NewMessageHandler(nil, mockActionSpecs, mockActionLoggers)
+
+	result, err := messageHandler.Handle(message)
+
+	assert.Nil(t, err)
+	assert.True(t, result.IsSuccessful)
+	assert.Empty(t, result.FailureReason)
+}
+
+func testProcessDuplicateMessage(t *testing.T) {
+
+	executionCount := 0
+	runbook.ExecuteFunc = func(ctx context.Context, executionId string, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) (string, error) {
+		executionCount++
+		return "", nil
+	}
+
+	body := `{"actionType":"custom", "action":"Restart", "requestId": "RequestId"}`
+	message := sqs.Message{Body: &body, MessageId: &mockMessageId}
+	redeliveredMessageId := "redeliveredMessageId"
+	redeliveredMessage := sqs.Message{Body: &body, MessageId: &redeliveredMessageId}
+
+	messageHandler := &messageHandler{
+		actionSpecs:      mockActionSpecs,
+		actionLoggers:    mockActionLoggers,
+		dedupeStore:      NewMockDedupeStore(),
+		inFlightRequests: util.NewKeyedMutex(),
+	}
+
+	result, err := messageHandler.Handle(message)
+	assert.Nil(t, err)
+
+	duplicateResult, err := messageHandler.Handle(redeliveredMessage)
+	assert.Nil(t, err)
+	assert.Equal(t, result, duplicateResult)
+
+	_, err = messageHandler.Handle(message)
+	assert.Nil(t, err)
+
+	assert.Equal(t, 1, executionCount)
+}
+
+func testProcessSerializedMessages(t *testing.T) {
+
+	concurrentExecutions := int32(0)
+	maxConcurrentExecutions := int32(0)
+	runbook.ExecuteFunc = func(ctx context.Context, executionId string, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) (string, error) {
+		current := atomic.AddInt32(&concurrentExecutions, 1)
+		defer atomic.AddInt32(&concurrentExecutions, -1)
+
+		for {
+			max := atomic.LoadInt32(&maxConcurrentExecutions)
+			if current <= max || atomic.CompareAndSwapInt32(&maxConcurrentExecutions, max, current) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		return "", nil
+	}
+
+	messageHandler := &messageHandler{
+		actionSpecs:      mockActionSpecs,
+		actionLoggers:    mockActionLoggers,
+		serializationKey: conf.EntityIdSerializationKey,
+		executionLocks:   util.NewKeyedMutex(),
+	}
+
+	handleConcurrently := func(entityIds ...string) {
+		wg := &sync.WaitGroup{}
+		for i, entityId := range entityIds {
+			body := `{"actionType":"custom", "action":"Restart", "entity": {"id": "` + entityId + `"}}`
+			messageId := strconv.Itoa(i)
+			message := sqs.Message{Body: &body, MessageId: &messageId}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := messageHandler.Handle(message)
+				assert.Nil(t, err)
+			}()
+		}
+		wg.Wait()
+	}
+
+	handleConcurrently("alert1", "alert1", "alert1")
+	assert.Equal(t, int32(1), maxConcurrentExecutions)
+
+	handleConcurrently("alert1", "alert2", "alert3")
+	assert.Equal(t, int32(3), maxConcurrentExecutions)
+
+	maxConcurrentExecutions = 0
+	handleConcurrently("", "", "")
+	assert.Equal(t, int32(3), maxConcurrentExecutions)
+}
+
 func testProcessFieldMissing(t *testing.T) {
 
 	runbook.ExecuteFunc = mockExecute
@@ -196,3 +468,38 @@ func (mqm *MockMessageHandler) Handle(message sqs.Message) (*runbook.ActionResul
 func NewMockMessageHandler() MessageHandler {
 	return &MockMessageHandler{}
 }
+
+// Mock Dedupe Store
+type MockDedupeStore struct {
+	values map[string][]byte
+	mu     *sync.Mutex
+}
+
+func NewMockDedupeStore() *MockDedupeStore {
+	return &MockDedupeStore{
+		values: make(map[string][]byte),
+		mu:     &sync.Mutex{},
+	}
+}
+
+func (s *MockDedupeStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.values[key]
+	return value, ok
+}
+
+func (s *MockDedupeStore) Put(value []byte, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		s.values[key] = value
+	}
+	return nil
+}
+
+func (s *MockDedupeStore) Close() error {
+	return nil
+}
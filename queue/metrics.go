@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultIntegrationLabel is the integration label of the metrics when a single integration is configured.
+const defaultIntegrationLabel = "default"
+
+func integrationLabel(integrationName string) string {
+	if integrationName == "" {
+		return defaultIntegrationLabel
+	}
+	return integrationName
+}
+
+var (
+	processorReady = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "jec",
+			Subsystem: "processor",
+			Name:      "ready",
+			Help:      "Whether the queue processor of the integration is ready to poll the queues; 0 means it is in degraded state.",
+		},
+		[]string{"integration"},
+	)
+	prefetchBufferMessages = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "jec",
+			Subsystem: "poller",
+			Name:      "prefetch_buffer_messages",
+			Help:      "Number of received messages held in the prefetch buffer of the poller.",
+		},
+		[]string{"integration", "region"},
+	)
+	prefetchBufferCapacity = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "jec",
+			Subsystem: "poller",
+			Name:      "prefetch_buffer_capacity",
+			Help:      "Maximum number of messages the prefetch buffer of the poller can hold.",
+		},
+		[]string{"integration", "region"},
+	)
+	serializationWaitingExecutions = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "jec",
+			Subsystem: "serialization",
+			Name:      "waiting_executions",
+			Help:      "Number of executions waiting for an execution with the same serialization key to complete.",
+		},
+		[]string{"integration"},
+	)
+	serializationWaitSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "jec",
+			Subsystem: "serialization",
+			Name:      "wait_seconds",
+			Help:      "Time an execution waited for the executions with the same serialization key.",
+			Buckets:   []float64{0.001, 0.01, 0.1, 0.5, 1, 5, 15, 30, 60, 120, 300},
+		},
+		[]string{"integration", "action"},
+	)
+	actionInFlightExecutions = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "jec",
+			Subsystem: "action",
+			Name:      "in_flight_executions",
+			Help:      "Number of running executions of the action which has a concurrency or rate limit.",
+		},
+		[]string{"integration", "action"},
+	)
+	actionMaxConcurrency = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "jec",
+			Subsystem: "action",
+			Name:      "max_concurrency",
+			Help:      "Max number of concurrent executions of the action, 0 means unlimited.",
+		},
+		[]string{"integration", "action"},
+	)
+	actionRateLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "jec",
+			Subsystem: "action",
+			Name:      "rate_limit_per_second",
+			Help:      "Number of executions of the action allowed per second, 0 means unlimited.",
+		},
+		[]string{"integration", "action"},
+	)
+	actionDeferredMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "jec",
+			Subsystem: "action",
+			Name:      "deferred_messages_total",
+			Help:      "Number of messages deferred because the action was over its concurrency or rate limit.",
+		},
+		[]string{"integration", "action", "reason"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		processorReady,
+		prefetchBufferMessages,
+		prefetchBufferCapacity,
+		serializationWaitingExecutions,
+		serializationWaitSeconds,
+		actionInFlightExecutions,
+		actionMaxConcurrency,
+		actionRateLimit,
+		actionDeferredMessages,
+	)
+}
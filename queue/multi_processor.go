@@ -0,0 +1,200 @@
+package queue
+
+import (
+	"github.com/atlassian/jec/conf"
+	"github.com/atlassian/jec/runbook"
+	"github.com/atlassian/jec/worker_pool"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+)
+
+// integrationPoolSeparator separates the integration name from the pool name of the dedicated pools, e.g. "billing:default".
+const integrationPoolSeparator = ":"
+
+// multiProcessor runs a queue processor for each of the integrations. The processors share the worker pools
+// of the configuration unless the integration has a dedicated pool, the result outbox is always shared.
+type multiProcessor struct {
+	configuration *conf.Configuration
+	workerPool    worker_pool.WorkerPool
+	processors    []*processor
+	resultOutbox  *runbook.Outbox
+
+	isRunning   bool
+	startStopMu *sync.Mutex
+}
+
+func newMultiProcessor(configuration *conf.Configuration, clients *sharedClients) *multiProcessor {
+	mp := &multiProcessor{
+		configuration: configuration,
+		workerPool:    newWorkerPool(configuration, ""),
+		processors:    make([]*processor, 0, len(configuration.Integrations)),
+		startStopMu:   &sync.Mutex{},
+	}
+
+	for index, integrationConf := range configuration.IntegrationConfigurations() {
+		workerPool := mp.workerPool
+		isWorkerPoolShared := !configuration.Integrations[index].HasDedicatedPool()
+		if !isWorkerPoolShared {
+			workerPool = newWorkerPool(integrationConf, integrationConf.IntegrationName+integrationPoolSeparator)
+		}
+
+		qp := newProcessor(integrationConf, workerPool, clients)
+		qp.isWorkerPoolShared = isWorkerPoolShared
+		qp.isResultOutboxShared = true
+		mp.processors = append(mp.processors, qp)
+	}
+	return mp
+}
+
+func (mp *multiProcessor) Start() error {
+	defer mp.startStopMu.Unlock()
+	mp.startStopMu.Lock()
+
+	if mp.isRunning {
+		return errors.New("Queue processor is already running.")
+	}
+
+	logrus.Infof("Queue processors of %d integrations are starting.", len(mp.processors))
+	if mp.configuration.CircuitBreakerConf.Enabled {
+		resultOutbox, err := runbook.OpenOutbox(mp.configuration.CircuitBreakerConf.OutboxFilepath)
+		if err != nil {
+			logrus.Errorf("Queue processor could not open result outbox and will terminate.")
+			return err
+		}
+		mp.resultOutbox = resultOutbox
+		runbook.SetOutbox(resultOutbox)
+	}
+
+	mp.workerPool.Start()
+	for index, qp := range mp.processors {
+		qp.resultOutbox = mp.resultOutbox
+
+		err := qp.Start()
+		if err != nil {
+			mp.stopProcessors(mp.processors[:index])
+			mp.closeResultOutbox()
+			return errors.Errorf("Queue processor of integration[%s] could not be started: %s", qp.name, err)
+		}
+	}
+
+	mp.isRunning = true
+	return nil
+}
+
+func (mp *multiProcessor) Stop() error {
+	defer mp.startStopMu.Unlock()
+	mp.startStopMu.Lock()
+
+	if !mp.isRunning {
+		return errors.New("Queue processor is not running.")
+	}
+
+	mp.stopProcessors(mp.processors)
+	mp.closeResultOutbox()
+
+	mp.isRunning = false
+	logrus.Infof("Queue processors of all integrations have stopped.")
+	return nil
+}
+
+// stopProcessors stops the pollers of all processors first, then the shared worker pool which completes the jobs
+// in it, and releases the repositories and the dedupe stores of the processors only after that.
+func (mp *multiProcessor) stopProcessors(processors []*processor) {
+	stoppedProcessors := make([]*processor, 0, len(processors))
+	for _, qp := range processors {
+		qp.startStopMu.Lock()
+		if !qp.isRunning {
+			qp.startStopMu.Unlock()
+			logrus.Warnf("Queue processor of integration[%s] is not running.", qp.name)
+			continue
+		}
+		qp.stopPolling()
+		stoppedProcessors = append(stoppedProcessors, qp)
+	}
+
+	mp.workerPool.Stop()
+
+	for _, qp := range stoppedProcessors {
+		qp.release()
+		qp.startStopMu.Unlock()
+	}
+}
+
+func (mp *multiProcessor) closeResultOutbox() {
+	if mp.resultOutbox == nil {
+		return
+	}
+	runbook.SetOutbox(nil)
+	err := mp.resultOutbox.Close()
+	if err != nil {
+		logrus.Warnf("Result outbox could not be closed: %s", err)
+	}
+	mp.resultOutbox = nil
+}
+
+// PoolConfs returns the shared pools by their names and the dedicated pools of the integrations
+// by their names prefixed with the integration name.
+func (mp *multiProcessor) PoolConfs() map[string]conf.PoolConf {
+	poolConfs := workerPoolConfs(mp.workerPool)
+	for _, qp := range mp.processors {
+		if qp.isWorkerPoolShared {
+			continue
+		}
+		for name, poolConf := range qp.PoolConfs() {
+			poolConfs[qp.name+integrationPoolSeparator+name] = poolConf
+		}
+	}
+	return poolConfs
+}
+
+func (mp *multiProcessor) ResizePool(name string, poolConf conf.PoolConf) error {
+	separatorIndex := strings.Index(name, integrationPoolSeparator)
+	if separatorIndex < 0 {
+		return resizeWorkerPool(mp.workerPool, name, poolConf)
+	}
+
+	qp := mp.processor(name[:separatorIndex])
+	if qp == nil || qp.isWorkerPoolShared {
+		return errors.Errorf("Worker pool[%s] does not exist.", name)
+	}
+	return qp.ResizePool(name[separatorIndex+1:], poolConf)
+}
+
+func (mp *multiProcessor) ReloadPools(configuration *conf.Configuration) error {
+	err := reloadWorkerPools(mp.workerPool, configuration)
+	if err != nil {
+		return err
+	}
+
+	for index, integrationConf := range configuration.IntegrationConfigurations() {
+		qp := mp.processor(integrationConf.IntegrationName)
+		if qp == nil {
+			logrus.Warnf("Integration[%s] is added to the configuration, it will be started when JEC is restarted.", integrationConf.IntegrationName)
+			continue
+		}
+		if qp.isWorkerPoolShared == configuration.Integrations[index].HasDedicatedPool() {
+			logrus.Warnf("Dedicated pool of integration[%s] is changed, it will be applied when JEC is restarted.", qp.name)
+			continue
+		}
+		if qp.isWorkerPoolShared {
+			continue
+		}
+
+		err = qp.ReloadPools(integrationConf)
+		if err != nil {
+			return errors.Errorf("Worker pools of integration[%s] could not be reloaded: %s", qp.name, err)
+		}
+	}
+	return nil
+}
+
+func (mp *multiProcessor) processor(integrationName string) *processor {
+	for _, qp := range mp.processors {
+		if qp.name == integrationName {
+			return qp
+		}
+	}
+	return nil
+}
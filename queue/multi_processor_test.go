@@ -0,0 +1,150 @@
+package queue
+
+import (
+	"github.com/atlassian/jec/conf"
+	"github.com/atlassian/jec/health"
+	"github.com/atlassian/jec/retryer"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func newMultiProcessorTest() *multiProcessor {
+	configuration := &conf.Configuration{
+		PoolConf: conf.PoolConf{MaxNumberOfWorker: 8, MinNumberOfWorker: 2},
+		Integrations: []conf.IntegrationConf{
+			{Name: "billing", ApiKey: "billingApiKey"},
+			{Name: "payments", ApiKey: "paymentsApiKey", PoolConf: conf.PoolConf{MaxNumberOfWorker: 4, MinNumberOfWorker: 1}},
+		},
+	}
+	return newMultiProcessor(configuration, &sharedClients{})
+}
+
+func TestNewMultiProcessor(t *testing.T) {
+	mp := newMultiProcessorTest()
+
+	assert.Equal(t, 2, len(mp.processors))
+	assert.True(t, mp.processors[0].isWorkerPoolShared)
+	assert.Equal(t, mp.workerPool, mp.processors[0].workerPool)
+	assert.False(t, mp.processors[1].isWorkerPoolShared)
+	assert.NotEqual(t, mp.workerPool, mp.processors[1].workerPool)
+	assert.Equal(t, "paymentsApiKey", mp.processors[1].configuration.ApiKey)
+}
+
+func TestStartAndStopMultiProcessor(t *testing.T) {
+
+	defer func() {
+		newPollerFunc = NewPoller
+	}()
+	newPollerFunc = NewMockPollerForQueueProcessor
+
+	mp := newMultiProcessorTest()
+
+	apiKeys := make([]string, 0)
+	apiKeysMu := &sync.Mutex{}
+	for _, qp := range mp.processors {
+		qp.retryer.DoFunc = func(r *retryer.Retryer, request *retryer.Request) (*http.Response, error) {
+			apiKeysMu.Lock()
+			apiKeys = append(apiKeys, request.Header.Get("Authorization"))
+			apiKeysMu.Unlock()
+			return mockHttpGet(r, request)
+		}
+	}
+
+	err := mp.Start()
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"GenieKey billingApiKey", "GenieKey paymentsApiKey"}, apiKeys)
+	for _, qp := range mp.processors {
+		assert.Equal(t, 2, len(qp.pollers))
+		assert.Nil(t, qp.checkReadiness())
+	}
+
+	err = mp.Stop()
+	assert.Nil(t, err)
+
+	err = mp.Stop()
+	assert.EqualError(t, err, "Queue processor is not running.")
+}
+
+func TestMultiProcessorPools(t *testing.T) {
+	mp := newMultiProcessorTest()
+
+	poolConfs := mp.PoolConfs()
+	assert.Equal(t, int32(8), poolConfs["default"].MaxNumberOfWorker)
+	assert.Equal(t, int32(4), poolConfs["payments:default"].MaxNumberOfWorker)
+	assert.Equal(t, 2, len(poolConfs))
+
+	err := mp.ResizePool("payments:default", conf.PoolConf{MaxNumberOfWorker: 6, MinNumberOfWorker: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(6), mp.PoolConfs()["payments:default"].MaxNumberOfWorker)
+
+	err = mp.ResizePool("billing:default", conf.PoolConf{MaxNumberOfWorker: 6})
+	assert.EqualError(t, err, "Worker pool[billing:default] does not exist.")
+}
+
+func TestStopMultiProcessorReleasesAfterSharedPoolStops(t *testing.T) {
+
+	defer func() {
+		newPollerFunc = NewPoller
+	}()
+	newPollerFunc = NewMockPollerForQueueProcessor
+
+	mp := newMultiProcessorTest()
+	for _, qp := range mp.processors {
+		qp.retryer.DoFunc = mockHttpGet
+	}
+
+	sharedPool := NewMockWorkerPool()
+	sharedPool.StopFunc = func() error {
+		for _, qp := range mp.processors {
+			select {
+			case <-qp.quit:
+			default:
+				t.Errorf("Pollers of integration[%s] are not stopped before the shared worker pool.", qp.name)
+			}
+			assert.True(t, qp.isRunning)
+			assert.Nil(t, qp.checkReadiness())
+		}
+		return nil
+	}
+	mp.workerPool = sharedPool
+
+	assert.Nil(t, mp.Start())
+	assert.Nil(t, mp.Stop())
+
+	for _, qp := range mp.processors {
+		assert.False(t, qp.isRunning)
+		assert.NotNil(t, qp.checkReadiness())
+	}
+}
+
+func TestMultiProcessorCircuitBreakers(t *testing.T) {
+	defer health.Unregister("circuitBreaker[jsm[billing]]")
+	defer health.Unregister("circuitBreaker[jsm[payments]]")
+
+	configuration := &conf.Configuration{
+		CircuitBreakerConf: conf.CircuitBreakerConf{Enabled: true},
+		Integrations: []conf.IntegrationConf{
+			{Name: "billing", ApiKey: "billingApiKey"},
+			{Name: "payments", ApiKey: "paymentsApiKey"},
+		},
+	}
+	mp := newMultiProcessor(configuration, &sharedClients{})
+
+	for _, qp := range mp.processors {
+		qp.registerCircuitBreakerDetail()
+	}
+
+	report := health.Status()
+	assert.Equal(t, "closed", report.Details["circuitBreaker[jsm[billing]]"])
+	assert.Equal(t, "closed", report.Details["circuitBreaker[jsm[payments]]"])
+	assert.NotContains(t, report.Details, "circuitBreaker[jsm]")
+	assert.NotContains(t, report.Checks, "circuitBreaker[jsm[billing]]")
+
+	for i := 0; i < 10; i++ {
+		mp.processors[0].circuitBreaker.Failure()
+	}
+	assert.Equal(t, "open", health.Status().Details["circuitBreaker[jsm[billing]]"])
+}
@@ -1,19 +1,37 @@
 package queue
 
+import (
+	"github.com/atlassian/jec/conf"
+	"github.com/atlassian/jec/worker_pool"
+)
+
 type payload struct {
 	RequestId             string       `json:"requestId"`
 	Entity                entity       `json:"entity"`
+	Alert                 alert        `json:"alert"`
 	Action                string       `json:"action"`
 	MappedAction          mappedAction `json:"mappedActionV2"`
 	ActionType            string       `json:"actionType"`
 	DiscardScriptResponse bool         `json:"discardScriptResponse"`
 }
 
+func (p *payload) actionName() string {
+	if p.MappedAction.Name != "" {
+		return p.MappedAction.Name
+	}
+	return p.Action
+}
+
 type entity struct {
 	Id   string `json:"id"`
 	Type string `json:"type"`
 }
 
+type alert struct {
+	Priority string   `json:"priority"`
+	Tags     []string `json:"tags"`
+}
+
 type mappedAction struct {
 	Name       string `json:"name"`
 	ExtraField string `json:"extraField"`
@@ -23,3 +41,25 @@ const (
 	CustomActionType = "custom"
 	HttpActionType   = "http"
 )
+
+// isCompatible reports whether the mapped action can handle the requests of the action type,
+// workflows and fan-out actions handle the requests of custom actions.
+func isCompatible(mappedActionType, actionType string) bool {
+	if mappedActionType == conf.WorkflowActionType || mappedActionType == conf.FanOutActionType {
+		return actionType == CustomActionType
+	}
+	return mappedActionType == actionType
+}
+
+// parsePriority converts the priorities from P1 to P5 to the priorities of the worker pool.
+func parsePriority(priority string) (int, bool) {
+	if len(priority) != 2 || (priority[0] != 'P' && priority[0] != 'p') {
+		return 0, false
+	}
+
+	level := int(priority[1] - '0')
+	if level < worker_pool.HighestPriority || level > worker_pool.LowestPriority {
+		return 0, false
+	}
+	return level, true
+}
@@ -1,9 +1,12 @@
 package queue
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/atlassian/jec/conf"
 	"github.com/atlassian/jec/util"
 	"github.com/atlassian/jec/worker_pool"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -15,6 +18,14 @@ import (
 	"time"
 )
 
+// The messages which cannot run on this instance are kept invisible for a while, so that they are received by
+// the other instances instead of being received by this one again. The deferral doubles with each receive,
+// so that the messages which no instance can run are not received every second.
+const (
+	labelMismatchDeferralInSec    = 1
+	maxLabelMismatchDeferralInSec = 60
+)
+
 type Poller interface {
 	Processor
 	RefreshClient(assumeRoleResult AssumeRoleResult) error
@@ -22,39 +33,55 @@ type Poller interface {
 }
 
 type poller struct {
-	workerPool     worker_pool.WorkerPool
-	queueProvider  SQSProvider
-	messageHandler MessageHandler
+	workerPool        worker_pool.WorkerPool
+	queueProvider     SQSProvider
+	messageHandler    MessageHandler
+	signatureVerifier SignatureVerifier
+	actionLimiter     ActionLimiter
 
 	ownerId            string
 	conf               *conf.Configuration
 	queueMessageLogrus *logrus.Logger
 
-	isRunning   bool
-	isRunningWg *sync.WaitGroup
-	startStopMu *sync.Mutex
-	quit        chan struct{}
-	wakeUp      chan struct{}
+	isRunning      bool
+	isRunningWg    *sync.WaitGroup
+	startStopMu    *sync.Mutex
+	quit           chan struct{}
+	wakeUp         chan struct{}
+	prefetchBuffer *prefetchBuffer
+	ctx            context.Context
+	cancel         context.CancelFunc
 }
 
 func NewPoller(workerPool worker_pool.WorkerPool,
 	queueProvider SQSProvider,
 	messageHandler MessageHandler,
+	signatureVerifier SignatureVerifier,
+	actionLimiter ActionLimiter,
 	conf *conf.Configuration,
 	ownerId string) Poller {
 
+	ctx, cancel := context.WithCancel(context.Background())
+	region := queueProvider.Properties().Region()
+	integration := integrationLabel(conf.IntegrationName)
+
 	return &poller{
 		workerPool:         workerPool,
 		queueProvider:      queueProvider,
 		messageHandler:     messageHandler,
+		signatureVerifier:  signatureVerifier,
+		actionLimiter:      actionLimiter,
 		ownerId:            ownerId,
 		conf:               conf,
-		queueMessageLogrus: newQueueMessageLogrus(queueProvider.Properties().Region()),
+		queueMessageLogrus: newQueueMessageLogrus(conf.IntegrationName, region),
 		isRunning:          false,
 		isRunningWg:        &sync.WaitGroup{},
 		startStopMu:        &sync.Mutex{},
 		quit:               make(chan struct{}),
 		wakeUp:             make(chan struct{}),
+		prefetchBuffer:     newPrefetchBuffer(conf.PollerConf.PrefetchBufferSize, conf.PollerConf.VisibilityTimeoutInSeconds, integration, region),
+		ctx:                ctx,
+		cancel:             cancel,
 	}
 }
 
@@ -92,6 +119,7 @@ func (p *poller) Stop() error {
 
 	close(p.quit)
 	close(p.wakeUp)
+	p.cancel() // aborts in-flight long polling
 
 	p.isRunningWg.Wait()
 	p.isRunning = false
@@ -101,33 +129,256 @@ func (p *poller) Stop() error {
 
 func (p *poller) terminateMessageVisibility(messages []*sqs.Message) {
 
+	if len(messages) == 0 {
+		return
+	}
+
 	region := p.queueProvider.Properties().Region()
 
-	for i := 0; i < len(messages); i++ {
-		messageId := *messages[i].MessageId
+	err := p.queueProvider.ChangeMessageVisibilityBatch(messages, 0)
+	batchErr, isBatchErr := err.(*BatchError)
+	if err != nil && !isBatchErr {
+		logrus.Warnf("Poller[%s] could not terminate visibility of %d messages: %s.", region, len(messages), err.Error())
+		return
+	}
+
+	for _, message := range messages {
+		messageId := aws.StringValue(message.MessageId)
+
+		if isBatchErr {
+			if err, failed := batchErr.Failed[message]; failed {
+				logrus.Warnf("Poller[%s] could not terminate visibility of message[%s]: %s.", region, messageId, err.Error())
+				continue
+			}
+		}
+
+		logrus.Debugf("Poller[%s] terminated visibility of message[%s].", region, messageId)
+	}
+}
+
+func (p *poller) submit(message *sqs.Message, schedule *messageSchedule) (bool, error) {
+	job := newJob(
+		p.queueProvider,
+		p.messageHandler,
+		p.signatureVerifier,
+		p.actionLimiter,
+		*message,
+		p.conf.ApiKey,
+		p.conf.BaseUrl,
+		p.ownerId,
+	)
+
+	job.pool, job.priority = schedule.pool, schedule.priority
+
+	return p.workerPool.Submit(job)
+}
+
+// messageSchedule is resolved once when the message is received, the prefetched messages keep it
+// so that their bodies are not parsed again each time they are submitted.
+type messageSchedule struct {
+	pool           string
+	priority       int
+	requiredLabels map[string]string
+}
+
+// scheduleOf resolves the pool, the priority and the required labels of the message from its mapped action,
+// the priority of the mapped action takes precedence over the priority of the alert.
+func (p *poller) scheduleOf(message *sqs.Message) *messageSchedule {
+	schedule := &messageSchedule{pool: worker_pool.DefaultPoolName, priority: worker_pool.DefaultPriority}
+
+	queuePayload := payload{}
+	if err := json.Unmarshal([]byte(aws.StringValue(message.Body)), &queuePayload); err != nil {
+		return schedule
+	}
+
+	if alertPriority, ok := parsePriority(queuePayload.Alert.Priority); ok {
+		schedule.priority = alertPriority
+	}
 
-		err := p.queueProvider.ChangeMessageVisibility(messages[i], 0)
+	actionName, _ := resolveActionName(&p.conf.ActionSpecifications, &queuePayload)
+	mappedAction, ok := p.conf.ActionMappings[actionName]
+	if !ok {
+		return schedule
+	}
+	if mappedAction.Pool != "" {
+		schedule.pool = mappedAction.Pool
+	}
+	if actionPriority, ok := parsePriority(mappedAction.Priority); ok {
+		schedule.priority = actionPriority
+	}
+	schedule.requiredLabels = mappedAction.RequiredLabels
+	return schedule
+}
+
+// numberOfAvailableWorker counts the workers of the pools which the mapped actions of the integration use, the pool group
+// may be shared by the other integrations. The workers which are already claimed by the prefetched messages are excluded.
+func (p *poller) numberOfAvailableWorker() int32 {
+	poolGroup, ok := p.workerPool.(worker_pool.PoolGroup)
+	if !ok {
+		return p.workerPool.NumberOfAvailableWorker()
+	}
+
+	pools := p.poolsOfActions()
+	pendingMessages := p.prefetchBuffer.CountByPool()
+
+	total := int32(0)
+	for pool, available := range poolGroup.NumberOfAvailableWorkerByPool() {
+		if _, ok := pools[pool]; !ok {
+			continue
+		}
+		if available > pendingMessages[pool] {
+			total += available - pendingMessages[pool]
+		}
+	}
+	return total
+}
+
+// poolsOfActions returns the pools of the mapped actions, the default pool runs the actions without a pool
+// and the messages without a mapped action.
+func (p *poller) poolsOfActions() map[string]struct{} {
+	pools := map[string]struct{}{worker_pool.DefaultPoolName: {}}
+	for _, mappedAction := range p.conf.ActionMappings {
+		if mappedAction.Pool != "" {
+			pools[mappedAction.Pool] = struct{}{}
+		}
+	}
+	return pools
+}
+
+func (p *poller) submitPrefetchedMessages() error {
+	if p.prefetchBuffer.Len() == 0 {
+		return nil
+	}
+
+	err := p.prefetchBuffer.Drain(p.submit)
+	if err != nil {
+		logrus.Debugf("Error occurred while submitting prefetched messages, they will be terminated: %s.", err.Error())
+		p.releasePrefetchedMessages()
+		return err
+	}
+	return nil
+}
+
+func (p *poller) extendPrefetchedMessages() {
+
+	now := time.Now()
+	messages := p.prefetchBuffer.ExpiringMessages(now)
+	if len(messages) == 0 {
+		return
+	}
+
+	region := p.queueProvider.Properties().Region()
+
+	err := p.queueProvider.ChangeMessageVisibilityBatch(messages, p.conf.PollerConf.VisibilityTimeoutInSeconds)
+	batchErr, isBatchErr := err.(*BatchError)
+	if err != nil && !isBatchErr {
+		logrus.Warnf("Poller[%s] could not extend visibility of %d prefetched messages, they are dropped from the buffer: %s.", region, len(messages), err.Error())
+		p.prefetchBuffer.Remove(messages)
+		return
+	}
+
+	failedMessages := make([]*sqs.Message, 0)
+	extendedMessages := make([]*sqs.Message, 0, len(messages))
+	for _, message := range messages {
+		messageId := aws.StringValue(message.MessageId)
+
+		if isBatchErr {
+			if err, failed := batchErr.Failed[message]; failed {
+				logrus.Warnf("Poller[%s] could not extend visibility of prefetched message[%s], it is dropped from the buffer: %s.", region, messageId, err.Error())
+				failedMessages = append(failedMessages, message)
+				continue
+			}
+		}
+		extendedMessages = append(extendedMessages, message)
+	}
+
+	p.prefetchBuffer.Remove(failedMessages)
+	p.prefetchBuffer.Extend(extendedMessages, now)
+	logrus.Debugf("Poller[%s] extended visibility of %d prefetched messages.", region, len(extendedMessages))
+}
+
+// matchesInstance reports whether the mapped action of the message can run on this instance according to its required labels.
+func (p *poller) matchesInstance(schedule *messageSchedule) bool {
+	return p.conf.InstanceConf.HasLabels(schedule.requiredLabels)
+}
+
+// deferNotMatchedMessages leaves the messages in the queue for the instances which have the required labels of their actions.
+func (p *poller) deferNotMatchedMessages(messages []*sqs.Message) {
+
+	if len(messages) == 0 {
+		return
+	}
+
+	region := p.queueProvider.Properties().Region()
+
+	deferrals := make(map[int64][]*sqs.Message)
+	for _, message := range messages {
+		deferralInSec := labelMismatchDeferral(messageReceiveCount(message))
+		if deferralInSec == maxLabelMismatchDeferralInSec {
+			logrus.Warnf("Poller[%s] left message[%s] in the queue %d times, it may not match the labels of any instance.",
+				region, aws.StringValue(message.MessageId), messageReceiveCount(message))
+		}
+		deferrals[deferralInSec] = append(deferrals[deferralInSec], message)
+	}
+
+	for deferralInSec, deferredMessages := range deferrals {
+		err := p.queueProvider.ChangeMessageVisibilityBatch(deferredMessages, deferralInSec)
 		if err != nil {
-			logrus.Warnf("Poller[%s] could not terminate visibility of message[%s]: %s.", region, messageId, err.Error())
+			logrus.Warnf("Poller[%s] could not leave %d messages which do not match the instance labels in the queue: %s.", region, len(deferredMessages), err.Error())
 			continue
 		}
+		logrus.Debugf("Poller[%s] left %d messages which do not match the instance labels in the queue for %d s.", region, len(deferredMessages), deferralInSec)
+	}
+}
 
-		logrus.Debugf("Poller[%s] terminated visibility of message[%s].", region, messageId)
+// labelMismatchDeferral doubles the deferral of the message with each receive up to the max deferral.
+func labelMismatchDeferral(receiveCount int64) int64 {
+	deferralInSec := int64(labelMismatchDeferralInSec)
+	for i := int64(1); i < receiveCount && deferralInSec < maxLabelMismatchDeferralInSec; i++ {
+		deferralInSec *= 2
+	}
+	if deferralInSec > maxLabelMismatchDeferralInSec {
+		return maxLabelMismatchDeferralInSec
 	}
+	return deferralInSec
+}
+
+func (p *poller) releasePrefetchedMessages() {
+	p.terminateMessageVisibility(p.prefetchBuffer.RemoveAll())
 }
 
 func (p *poller) poll() (shouldWait bool) {
 
-	availableWorkerCount := p.workerPool.NumberOfAvailableWorker()
-	if !(availableWorkerCount > 0) {
+	if err := p.submitPrefetchedMessages(); err != nil {
+		return true
+	}
+	p.extendPrefetchedMessages()
+
+	availableWorkerCount := p.numberOfAvailableWorker()
+	freeBufferCount := p.prefetchBuffer.Free()
+	if !(availableWorkerCount > 0) && !(freeBufferCount > 0) {
 		return true
 	}
 
 	region := p.queueProvider.Properties().Region()
-	maxNumberOfMessages := util.Min(p.conf.PollerConf.MaxNumberOfMessages, int64(availableWorkerCount))
+	maxNumberOfMessages := util.Min(p.conf.PollerConf.MaxNumberOfMessages, int64(availableWorkerCount)+int64(freeBufferCount))
 
-	messages, err := p.queueProvider.ReceiveMessage(maxNumberOfMessages, p.conf.PollerConf.VisibilityTimeoutInSeconds)
-	if err != nil { // todo check wait time according to error / check error
+	// do not block on long polling while prefetched messages are waiting for a worker
+	waitTimeSeconds := p.conf.PollerConf.WaitTimeInSeconds
+	if p.prefetchBuffer.Len() > 0 {
+		waitTimeSeconds = 0
+	}
+
+	messages, err := p.queueProvider.ReceiveMessage(
+		p.ctx,
+		maxNumberOfMessages,
+		p.conf.PollerConf.VisibilityTimeoutInSeconds,
+		waitTimeSeconds,
+	)
+	if err != nil && p.ctx.Err() != nil {
+		logrus.Debugf("Poller[%s] has canceled receiving message.", region)
+		return true
+	} else if err != nil { // todo check wait time according to error / check error
 		logrus.Errorf("Poller[%s] could not receive message: %s", region, err.Error())
 		return true
 	}
@@ -140,28 +391,38 @@ func (p *poller) poll() (shouldWait bool) {
 
 	logrus.Debugf("Received %d messages from the queue[%s].", messageLength, region)
 
+	receivedAt := time.Now()
+	notSubmittedMessages := make([]*sqs.Message, 0)
+	notMatchedMessages := make([]*sqs.Message, 0)
+	defer func() {
+		p.terminateMessageVisibility(notSubmittedMessages)
+		p.deferNotMatchedMessages(notMatchedMessages)
+	}()
+
 	for i := 0; i < messageLength; i++ {
 
 		p.queueMessageLogrus.
 			WithField("messageId", *messages[i].MessageId).
+			WithField("receiveCount", messageReceiveCount(messages[i])).
 			Info("Message body: ", *messages[i].Body)
 
-		job := newJob(
-			p.queueProvider,
-			p.messageHandler,
-			*messages[i],
-			p.conf.ApiKey,
-			p.conf.BaseUrl,
-			p.ownerId,
-		)
+		schedule := p.scheduleOf(messages[i])
+		if !p.matchesInstance(schedule) {
+			notMatchedMessages = append(notMatchedMessages, messages[i])
+			continue
+		}
 
-		isSubmitted, err := p.workerPool.Submit(job)
+		isSubmitted, err := p.submit(messages[i], schedule)
 		if err != nil {
 			logrus.Debugf("Error occurred while submitting, messages will be terminated: %s.", err.Error())
-			p.terminateMessageVisibility(messages[i:])
+			notSubmittedMessages = append(notSubmittedMessages, messages[i:]...)
 			return true
 		} else if !isSubmitted {
-			p.terminateMessageVisibility(messages[i : i+1])
+			if p.prefetchBuffer.Push(messages[i], schedule, receivedAt) {
+				logrus.Debugf("Message[%s] is held in the prefetch buffer of poller[%s] until a worker is available.", *messages[i].MessageId, region)
+				continue
+			}
+			notSubmittedMessages = append(notSubmittedMessages, messages[i])
 		}
 	}
 	return false
@@ -197,6 +458,7 @@ func (p *poller) run() {
 	for {
 		select {
 		case <-p.quit:
+			p.releasePrefetchedMessages()
 			logrus.Infof("Poller[%s] has stopped to poll.", queueUrl)
 			p.isRunningWg.Done()
 			return
@@ -212,8 +474,14 @@ func (p *poller) run() {
 	}
 }
 
-func newQueueMessageLogrus(region string) *logrus.Logger {
-	logFilePath := filepath.Join("/var", "log", "jec", "jecQueueMessages-"+region+"-"+strconv.Itoa(os.Getpid())+".log")
+// newQueueMessageLogrus creates the logger of the messages which are received from the queue of the region,
+// the pollers of the integrations in the same region write to different files.
+func newQueueMessageLogrus(integrationName string, region string) *logrus.Logger {
+	filename := "jecQueueMessages-" + region + "-" + strconv.Itoa(os.Getpid()) + ".log"
+	if integrationName != "" {
+		filename = "jecQueueMessages-" + integrationName + "-" + region + "-" + strconv.Itoa(os.Getpid()) + ".log"
+	}
+	logFilePath := filepath.Join("/var", "log", "jec", filename)
 	queueMessageLogger := &lumberjack.Logger{
 		Filename:  logFilePath,
 		MaxSize:   3,  // MB
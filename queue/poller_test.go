@@ -1,29 +1,37 @@
 package queue
 
 import (
+	"context"
 	"github.com/atlassian/jec/conf"
 	"github.com/atlassian/jec/worker_pool"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"sync"
 	"testing"
+	"time"
 )
 
 var mockPollerConf = &conf.PollerConf{
-	pollingWaitIntervalInMillis,
-	visibilityTimeoutInSec,
-	maxNumberOfMessages,
+	PollingWaitIntervalInMillis: pollingWaitIntervalInMillis,
+	VisibilityTimeoutInSeconds:  visibilityTimeoutInSec,
+	MaxNumberOfMessages:         maxNumberOfMessages,
+	WaitTimeInSeconds:           waitTimeInSec,
 }
 
 func newPollerTest() *poller {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &poller{
-		quit:        make(chan struct{}),
-		wakeUp:      make(chan struct{}),
-		isRunning:   false,
-		isRunningWg: &sync.WaitGroup{},
-		startStopMu: &sync.Mutex{},
+		quit:           make(chan struct{}),
+		wakeUp:         make(chan struct{}),
+		isRunning:      false,
+		isRunningWg:    &sync.WaitGroup{},
+		startStopMu:    &sync.Mutex{},
+		ctx:            ctx,
+		cancel:         cancel,
+		prefetchBuffer: newPrefetchBuffer(0, visibilityTimeoutInSec, defaultIntegrationLabel, mockQueueProperties1.Region()),
 		conf: &conf.Configuration{
 			ApiKey:               mockApiKey,
 			BaseUrl:              mockBaseUrl,
@@ -56,6 +64,35 @@ func TestStartAndStopPolling(t *testing.T) {
 	assert.Equal(t, false, poller.isRunning)
 }
 
+func TestStopPollingCancelsReceiveMessage(t *testing.T) {
+
+	poller := newPollerTest()
+
+	poller.workerPool.(*MockWorkerPool).NumberOfAvailableWorkerFunc = func() int32 {
+		return 1
+	}
+
+	receiving := make(chan struct{})
+	var waitTimeSeconds int64
+	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(ctx context.Context, numOfMessage int64, visibilityTimeout int64, waitTime int64) ([]*sqs.Message, error) {
+		waitTimeSeconds = waitTime
+		close(receiving)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	err := poller.Start()
+	assert.Nil(t, err)
+
+	<-receiving
+	start := time.Now()
+	err = poller.Stop()
+
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, int64(waitTimeInSec), waitTimeSeconds)
+}
+
 func TestStopPollingNonPollingState(t *testing.T) {
 
 	poller := newPollerTest()
@@ -84,7 +121,7 @@ func TestPollWithReceiveError(t *testing.T) {
 	poller.workerPool.(*MockWorkerPool).NumberOfAvailableWorkerFunc = func() int32 {
 		return 1
 	}
-	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(i int64, i2 int64) ([]*sqs.Message, error) {
+	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(ctx context.Context, i int64, i2 int64, i3 int64) ([]*sqs.Message, error) {
 		return nil, errors.New("")
 	}
 
@@ -99,7 +136,7 @@ func TestPollZeroMessage(t *testing.T) {
 	poller.workerPool.(*MockWorkerPool).NumberOfAvailableWorkerFunc = func() int32 {
 		return 1
 	}
-	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(i int64, i2 int64) ([]*sqs.Message, error) {
+	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(ctx context.Context, i int64, i2 int64, i3 int64) ([]*sqs.Message, error) {
 		return []*sqs.Message{}, nil
 	}
 
@@ -119,7 +156,7 @@ func TestPollMaxMessage(t *testing.T) {
 	}
 
 	maxNumberOfMessages := 0
-	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(numOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error) {
+	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(ctx context.Context, numOfMessage int64, visibilityTimeout int64, waitTimeSeconds int64) ([]*sqs.Message, error) {
 		maxNumberOfMessages = int(numOfMessage)
 		return nil, errors.New("Receive Error")
 	}
@@ -140,7 +177,7 @@ func TestPollMaxMessageUpperBound(t *testing.T) {
 	}
 
 	maxNumberOfMessages := int64(0)
-	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(numOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error) {
+	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(ctx context.Context, numOfMessage int64, visibilityTimeout int64, waitTimeSeconds int64) ([]*sqs.Message, error) {
 		maxNumberOfMessages = numOfMessage
 		return nil, errors.New("Receive Error")
 	}
@@ -168,9 +205,11 @@ func TestPollMessageSubmitFail(t *testing.T) {
 	}
 
 	releaseCount := 0
-	poller.queueProvider.(*MockSQSProvider).ChangeMessageVisibilityFunc = func(message *sqs.Message, visibilityTimeout int64) error {
+	batchCount := 0
+	poller.queueProvider.(*MockSQSProvider).ChangeMessageVisibilityBatchFunc = func(messages []*sqs.Message, visibilityTimeout int64) error {
+		batchCount++
 		if visibilityTimeout == 0 {
-			releaseCount++
+			releaseCount += len(messages)
 		}
 		return nil
 	}
@@ -180,6 +219,7 @@ func TestPollMessageSubmitFail(t *testing.T) {
 	assert.False(t, shouldWait)
 	assert.Equal(t, expected, submitCount)
 	assert.Equal(t, expected, releaseCount)
+	assert.Equal(t, 1, batchCount)
 }
 
 func TestPollMessageSubmitError(t *testing.T) {
@@ -200,9 +240,9 @@ func TestPollMessageSubmitError(t *testing.T) {
 	}
 
 	releaseCount := 0
-	poller.queueProvider.(*MockSQSProvider).ChangeMessageVisibilityFunc = func(message *sqs.Message, visibilityTimeout int64) error {
+	poller.queueProvider.(*MockSQSProvider).ChangeMessageVisibilityBatchFunc = func(messages []*sqs.Message, visibilityTimeout int64) error {
 		if visibilityTimeout == 0 {
-			releaseCount++
+			releaseCount += len(messages)
 		}
 		return nil
 	}
@@ -232,6 +272,130 @@ func TestPollMessageSubmitSuccess(t *testing.T) {
 	assert.False(t, shouldWait)
 }
 
+func TestPollMessageSubmitFailWithPrefetchBuffer(t *testing.T) {
+
+	poller := newPollerTest()
+	poller.prefetchBuffer = newPrefetchBuffer(3, visibilityTimeoutInSec, defaultIntegrationLabel, mockQueueProperties1.Region())
+
+	poller.workerPool.(*MockWorkerPool).NumberOfAvailableWorkerFunc = func() int32 {
+		return 2
+	}
+
+	receivedCount := int64(0)
+	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(ctx context.Context, numOfMessage int64, visibilityTimeout int64, waitTimeSeconds int64) ([]*sqs.Message, error) {
+		receivedCount = numOfMessage
+		return mockSuccessReceiveFunc(ctx, numOfMessage, visibilityTimeout, waitTimeSeconds)
+	}
+	poller.workerPool.(*MockWorkerPool).SubmitFunc = func(job worker_pool.Job) (bool, error) {
+		return false, nil
+	}
+
+	releaseCount := 0
+	poller.queueProvider.(*MockSQSProvider).ChangeMessageVisibilityBatchFunc = func(messages []*sqs.Message, visibilityTimeout int64) error {
+		if visibilityTimeout == 0 {
+			releaseCount += len(messages)
+		}
+		return nil
+	}
+
+	shouldWait := poller.poll()
+
+	assert.False(t, shouldWait)
+	assert.Equal(t, int64(5), receivedCount)
+	assert.Equal(t, 3, poller.prefetchBuffer.Len())
+	assert.Equal(t, 2, releaseCount)
+}
+
+func TestPollSubmitsPrefetchedMessagesFirst(t *testing.T) {
+
+	poller := newPollerTest()
+	poller.prefetchBuffer = newPrefetchBuffer(3, visibilityTimeoutInSec, defaultIntegrationLabel, mockQueueProperties1.Region())
+
+	messages, _ := mockSuccessReceiveFunc(context.Background(), 2, visibilityTimeoutInSec, 0)
+	for _, message := range messages {
+		poller.prefetchBuffer.Push(message, poller.scheduleOf(message), time.Now())
+	}
+
+	submittedIds := make([]string, 0)
+	poller.workerPool.(*MockWorkerPool).SubmitFunc = func(job worker_pool.Job) (bool, error) {
+		submittedIds = append(submittedIds, job.Id())
+		return true, nil
+	}
+
+	var waitTime int64 = -1
+	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(ctx context.Context, numOfMessage int64, visibilityTimeout int64, waitTimeSeconds int64) ([]*sqs.Message, error) {
+		waitTime = waitTimeSeconds
+		return []*sqs.Message{}, nil
+	}
+	poller.workerPool.(*MockWorkerPool).NumberOfAvailableWorkerFunc = func() int32 {
+		return 1
+	}
+
+	shouldWait := poller.poll()
+
+	assert.True(t, shouldWait)
+	assert.Equal(t, []string{"0", "1"}, submittedIds)
+	assert.Equal(t, 0, poller.prefetchBuffer.Len())
+	assert.Equal(t, int64(waitTimeInSec), waitTime)
+}
+
+func TestPollExtendsVisibilityOfPrefetchedMessages(t *testing.T) {
+
+	poller := newPollerTest()
+	poller.prefetchBuffer = newPrefetchBuffer(3, visibilityTimeoutInSec, defaultIntegrationLabel, mockQueueProperties1.Region())
+
+	messages, _ := mockSuccessReceiveFunc(context.Background(), 2, visibilityTimeoutInSec, 0)
+	poller.prefetchBuffer.Push(messages[0], poller.scheduleOf(messages[0]), time.Now().Add(-20*time.Second))
+	poller.prefetchBuffer.Push(messages[1], poller.scheduleOf(messages[1]), time.Now())
+
+	var extendedMessages []*sqs.Message
+	poller.queueProvider.(*MockSQSProvider).ChangeMessageVisibilityBatchFunc = func(messages []*sqs.Message, visibilityTimeout int64) error {
+		if visibilityTimeout == int64(visibilityTimeoutInSec) {
+			extendedMessages = messages
+		}
+		return nil
+	}
+	var waitTime int64 = -1
+	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(ctx context.Context, numOfMessage int64, visibilityTimeout int64, waitTimeSeconds int64) ([]*sqs.Message, error) {
+		waitTime = waitTimeSeconds
+		return []*sqs.Message{}, nil
+	}
+
+	shouldWait := poller.poll()
+
+	assert.True(t, shouldWait)
+	assert.Equal(t, 1, len(extendedMessages))
+	assert.Equal(t, "0", *extendedMessages[0].MessageId)
+	assert.Equal(t, 2, poller.prefetchBuffer.Len())
+	assert.Equal(t, int64(0), waitTime)
+}
+
+func TestStopPollingReleasesPrefetchedMessages(t *testing.T) {
+
+	poller := newPollerTest()
+	poller.prefetchBuffer = newPrefetchBuffer(3, visibilityTimeoutInSec, defaultIntegrationLabel, mockQueueProperties1.Region())
+
+	messages, _ := mockSuccessReceiveFunc(context.Background(), 2, visibilityTimeoutInSec, 0)
+	for _, message := range messages {
+		poller.prefetchBuffer.Push(message, poller.scheduleOf(message), time.Now())
+	}
+
+	releaseCount := 0
+	poller.queueProvider.(*MockSQSProvider).ChangeMessageVisibilityBatchFunc = func(messages []*sqs.Message, visibilityTimeout int64) error {
+		if visibilityTimeout == 0 {
+			releaseCount += len(messages)
+		}
+		return nil
+	}
+
+	poller.isRunningWg.Add(1)
+	close(poller.quit)
+	poller.run()
+
+	assert.Equal(t, 2, releaseCount)
+	assert.Equal(t, 0, poller.prefetchBuffer.Len())
+}
+
 // Mock Poller
 type MockPoller struct {
 	StartPollingFunc func() error
@@ -246,7 +410,7 @@ func NewMockPoller() Poller {
 }
 
 func NewMockPollerForQueueProcessor(workerPool worker_pool.WorkerPool, queueProvider SQSProvider,
-	messageHandler MessageHandler, conf *conf.Configuration, ownerId string) Poller {
+	messageHandler MessageHandler, signatureVerifier SignatureVerifier, actionLimiter ActionLimiter, conf *conf.Configuration, ownerId string) Poller {
 	return NewMockPoller()
 }
 
@@ -277,3 +441,168 @@ func (p *MockPoller) QueueProvider() SQSProvider {
 	}
 	return NewMockQueueProvider()
 }
+
+func TestPollSubmitsMessagesToPoolsOfTheirActions(t *testing.T) {
+
+	poller := newPollerTest()
+	poller.prefetchBuffer = newPrefetchBuffer(3, visibilityTimeoutInSec, defaultIntegrationLabel, mockQueueProperties1.Region())
+	poller.conf.ActionMappings = conf.ActionMappings{
+		"Create":  conf.MappedAction{Type: CustomActionType},
+		"Restart": conf.MappedAction{Type: CustomActionType, Pool: "slow"},
+	}
+
+	defaultPool := NewMockWorkerPool()
+	defaultPool.NumberOfAvailableWorkerFunc = func() int32 { return 2 }
+	defaultPool.SubmitFunc = func(job worker_pool.Job) (bool, error) { return true, nil }
+
+	slowPool := NewMockWorkerPool()
+	slowPool.NumberOfAvailableWorkerFunc = func() int32 { return 1 }
+	slowPool.SubmitFunc = func(job worker_pool.Job) (bool, error) { return false, nil }
+
+	poolGroup, err := worker_pool.NewPoolGroup(map[string]worker_pool.WorkerPool{
+		worker_pool.DefaultPoolName: defaultPool,
+		"slow":                      slowPool,
+	})
+	assert.Nil(t, err)
+	poller.workerPool = poolGroup
+
+	createBody := `{"actionType":"custom", "action":"Create"}`
+	restartBody := `{"actionType":"custom", "action":"Restart"}`
+	messageIds := []string{"0", "1", "2"}
+	prefetchedMessages := []*sqs.Message{
+		{MessageId: &messageIds[0], Body: &restartBody},
+		{MessageId: &messageIds[1], Body: &createBody},
+		{MessageId: &messageIds[2], Body: &restartBody},
+	}
+	for _, message := range prefetchedMessages {
+		poller.prefetchBuffer.Push(message, poller.scheduleOf(message), time.Now())
+	}
+
+	var receivedCount int64
+	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(ctx context.Context, numOfMessage int64, visibilityTimeout int64, waitTimeSeconds int64) ([]*sqs.Message, error) {
+		receivedCount = numOfMessage
+		return []*sqs.Message{}, nil
+	}
+
+	poller.poll()
+
+	assert.Equal(t, []*sqs.Message{prefetchedMessages[0], prefetchedMessages[2]}, poller.prefetchBuffer.Messages())
+	assert.Equal(t, map[string]int32{"slow": 2}, poller.prefetchBuffer.CountByPool())
+
+	// 2 workers of the default pool and 1 free buffer slot, the worker of the slow pool is claimed by prefetched messages
+	assert.Equal(t, int64(3), receivedCount)
+}
+
+func TestNumberOfAvailableWorkerCountsOnlyPoolsOfActions(t *testing.T) {
+
+	poller := newPollerTest()
+	poller.conf.ActionMappings = conf.ActionMappings{
+		"Create":  conf.MappedAction{Type: CustomActionType},
+		"Restart": conf.MappedAction{Type: CustomActionType, Pool: "slow"},
+	}
+
+	newPool := func(available int32) worker_pool.WorkerPool {
+		pool := NewMockWorkerPool()
+		pool.NumberOfAvailableWorkerFunc = func() int32 { return available }
+		return pool
+	}
+
+	poolGroup, err := worker_pool.NewPoolGroup(map[string]worker_pool.WorkerPool{
+		worker_pool.DefaultPoolName: newPool(2),
+		"slow":                      newPool(3),
+		"other":                     newPool(5),
+	})
+	assert.Nil(t, err)
+	poller.workerPool = poolGroup
+
+	assert.Equal(t, int32(5), poller.numberOfAvailableWorker())
+}
+
+func TestPollLeavesMessagesNotMatchingInstanceLabels(t *testing.T) {
+
+	poller := newPollerTest()
+	poller.conf.InstanceConf = conf.InstanceConf{Labels: map[string]string{"zone": "eu-1"}}
+	poller.conf.ActionMappings = conf.ActionMappings{
+		"Create":  conf.MappedAction{Type: CustomActionType, RequiredLabels: map[string]string{"zone": "eu-1"}},
+		"Restart": conf.MappedAction{Type: CustomActionType, RequiredLabels: map[string]string{"zone": "us-1"}},
+	}
+
+	poller.workerPool.(*MockWorkerPool).NumberOfAvailableWorkerFunc = func() int32 { return 2 }
+	submittedJobs := make([]string, 0)
+	poller.workerPool.(*MockWorkerPool).SubmitFunc = func(job worker_pool.Job) (bool, error) {
+		submittedJobs = append(submittedJobs, job.Id())
+		return true, nil
+	}
+
+	createBody := `{"actionType":"custom", "action":"Create"}`
+	restartBody := `{"actionType":"custom", "action":"Restart"}`
+	messageIds := []string{"0", "1"}
+	messages := []*sqs.Message{
+		{MessageId: &messageIds[0], Body: &createBody},
+		{MessageId: &messageIds[1], Body: &restartBody},
+	}
+	poller.queueProvider.(*MockSQSProvider).ReceiveMessageFunc = func(ctx context.Context, numOfMessage int64, visibilityTimeout int64, waitTimeSeconds int64) ([]*sqs.Message, error) {
+		return messages, nil
+	}
+
+	var deferredMessages []*sqs.Message
+	var deferredVisibilityTimeout int64
+	poller.queueProvider.(*MockSQSProvider).ChangeMessageVisibilityBatchFunc = func(messages []*sqs.Message, visibilityTimeout int64) error {
+		deferredMessages = messages
+		deferredVisibilityTimeout = visibilityTimeout
+		return nil
+	}
+
+	poller.poll()
+
+	assert.Equal(t, []string{"0"}, submittedJobs)
+	assert.Equal(t, []*sqs.Message{messages[1]}, deferredMessages)
+	assert.Equal(t, int64(labelMismatchDeferralInSec), deferredVisibilityTimeout)
+
+	messages[1].Attributes = map[string]*string{approximateReceiveCount: aws.String("4")}
+	poller.poll()
+
+	assert.Equal(t, int64(8), deferredVisibilityTimeout)
+}
+
+func TestLabelMismatchDeferral(t *testing.T) {
+	assert.Equal(t, int64(1), labelMismatchDeferral(0))
+	assert.Equal(t, int64(1), labelMismatchDeferral(1))
+	assert.Equal(t, int64(2), labelMismatchDeferral(2))
+	assert.Equal(t, int64(32), labelMismatchDeferral(6))
+	assert.Equal(t, int64(maxLabelMismatchDeferralInSec), labelMismatchDeferral(7))
+	assert.Equal(t, int64(maxLabelMismatchDeferralInSec), labelMismatchDeferral(1000))
+}
+
+func TestScheduleOf(t *testing.T) {
+
+	poller := newPollerTest()
+	poller.conf.ActionMappings = conf.ActionMappings{
+		"Create":  conf.MappedAction{Type: CustomActionType},
+		"Restart": conf.MappedAction{Type: CustomActionType, Pool: "remediation", Priority: "P1"},
+		"Audit":   conf.MappedAction{Type: CustomActionType, Pool: "audit"},
+	}
+	poller.conf.FallbackAction = "Audit"
+
+	newMessage := func(body string) *sqs.Message {
+		return &sqs.Message{MessageId: &mockMessageId, Body: &body}
+	}
+
+	schedule := poller.scheduleOf(newMessage(`{"action":"Restart", "actionType":"custom", "alert": {"priority": "P4"}}`))
+	assert.Equal(t, "remediation", schedule.pool)
+	assert.Equal(t, 1, schedule.priority)
+
+	schedule = poller.scheduleOf(newMessage(`{"action":"Create", "actionType":"custom", "alert": {"priority": "P4"}}`))
+	assert.Equal(t, worker_pool.DefaultPoolName, schedule.pool)
+	assert.Equal(t, 4, schedule.priority)
+
+	schedule = poller.scheduleOf(newMessage(`{"action":"Create", "actionType":"custom"}`))
+	assert.Equal(t, worker_pool.DefaultPoolName, schedule.pool)
+	assert.Equal(t, worker_pool.DefaultPriority, schedule.priority)
+
+	schedule = poller.scheduleOf(newMessage(`{"action":"Acknowledge", "actionType":"custom"}`))
+	assert.Equal(t, "audit", schedule.pool)
+
+	schedule = poller.scheduleOf(newMessage(`{"action":"Acknowledge", "actionType":"http"}`))
+	assert.Equal(t, worker_pool.DefaultPoolName, schedule.pool)
+}
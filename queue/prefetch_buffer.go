@@ -0,0 +1,146 @@
+package queue
+
+import (
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"time"
+)
+
+type prefetchedMessage struct {
+	message   *sqs.Message
+	schedule  *messageSchedule
+	visibleAt time.Time
+}
+
+// prefetchBuffer holds received messages which could not be submitted to the worker pool yet.
+// It is only accessed by the polling goroutine of its poller.
+type prefetchBuffer struct {
+	capacity          int
+	visibilityTimeout time.Duration
+	messages          []*prefetchedMessage
+	integration       string
+	region            string
+}
+
+func newPrefetchBuffer(capacity int64, visibilityTimeoutInSeconds int64, integration string, region string) *prefetchBuffer {
+	prefetchBufferCapacity.WithLabelValues(integration, region).Set(float64(capacity))
+	prefetchBufferMessages.WithLabelValues(integration, region).Set(0)
+
+	return &prefetchBuffer{
+		capacity:          int(capacity),
+		visibilityTimeout: time.Duration(visibilityTimeoutInSeconds) * time.Second,
+		messages:          make([]*prefetchedMessage, 0, capacity),
+		integration:       integration,
+		region:            region,
+	}
+}
+
+func (b *prefetchBuffer) Len() int {
+	return len(b.messages)
+}
+
+func (b *prefetchBuffer) Free() int {
+	return b.capacity - len(b.messages)
+}
+
+func (b *prefetchBuffer) Push(message *sqs.Message, schedule *messageSchedule, receivedAt time.Time) bool {
+	if b.Free() <= 0 {
+		return false
+	}
+
+	b.messages = append(b.messages, &prefetchedMessage{
+		message:   message,
+		schedule:  schedule,
+		visibleAt: receivedAt.Add(b.visibilityTimeout),
+	})
+	b.updateMetrics()
+	return true
+}
+
+// Drain submits buffered messages in arrival order. The messages which submitFunc refuses, e.g. because the pool
+// of their action is busy, stay in the buffer so that they do not block the messages of the other pools.
+func (b *prefetchBuffer) Drain(submitFunc func(message *sqs.Message, schedule *messageSchedule) (bool, error)) error {
+	defer b.updateMetrics()
+
+	remaining := make([]*prefetchedMessage, 0, b.capacity)
+	for i, prefetched := range b.messages {
+		isSubmitted, err := submitFunc(prefetched.message, prefetched.schedule)
+		if err != nil {
+			b.messages = append(remaining, b.messages[i:]...)
+			return err
+		}
+		if !isSubmitted {
+			remaining = append(remaining, prefetched)
+		}
+	}
+	b.messages = remaining
+	return nil
+}
+
+func (b *prefetchBuffer) Messages() []*sqs.Message {
+	messages := make([]*sqs.Message, 0, len(b.messages))
+	for _, prefetched := range b.messages {
+		messages = append(messages, prefetched.message)
+	}
+	return messages
+}
+
+// CountByPool returns the number of the buffered messages waiting for a worker of each pool.
+func (b *prefetchBuffer) CountByPool() map[string]int32 {
+	counts := make(map[string]int32)
+	for _, prefetched := range b.messages {
+		counts[prefetched.schedule.pool]++
+	}
+	return counts
+}
+
+// ExpiringMessages returns the messages whose visibility timeout is about to end, namely the ones with less than
+// half of the visibility timeout left.
+func (b *prefetchBuffer) ExpiringMessages(now time.Time) []*sqs.Message {
+	expiring := make([]*sqs.Message, 0)
+	for _, prefetched := range b.messages {
+		if prefetched.visibleAt.Sub(now) < b.visibilityTimeout/2 {
+			expiring = append(expiring, prefetched.message)
+		}
+	}
+	return expiring
+}
+
+func (b *prefetchBuffer) Extend(messages []*sqs.Message, now time.Time) {
+	extended := make(map[*sqs.Message]struct{}, len(messages))
+	for _, message := range messages {
+		extended[message] = struct{}{}
+	}
+
+	for _, prefetched := range b.messages {
+		if _, ok := extended[prefetched.message]; ok {
+			prefetched.visibleAt = now.Add(b.visibilityTimeout)
+		}
+	}
+}
+
+func (b *prefetchBuffer) Remove(messages []*sqs.Message) {
+	removed := make(map[*sqs.Message]struct{}, len(messages))
+	for _, message := range messages {
+		removed[message] = struct{}{}
+	}
+
+	remaining := make([]*prefetchedMessage, 0, b.capacity)
+	for _, prefetched := range b.messages {
+		if _, ok := removed[prefetched.message]; !ok {
+			remaining = append(remaining, prefetched)
+		}
+	}
+	b.messages = remaining
+	b.updateMetrics()
+}
+
+func (b *prefetchBuffer) RemoveAll() []*sqs.Message {
+	messages := b.Messages()
+	b.messages = make([]*prefetchedMessage, 0, b.capacity)
+	b.updateMetrics()
+	return messages
+}
+
+func (b *prefetchBuffer) updateMetrics() {
+	prefetchBufferMessages.WithLabelValues(b.integration, b.region).Set(float64(len(b.messages)))
+}
@@ -2,10 +2,15 @@ package queue
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/atlassian/jec/conf"
+	"github.com/atlassian/jec/dedupe"
 	"github.com/atlassian/jec/git"
+	"github.com/atlassian/jec/health"
 	"github.com/atlassian/jec/retryer"
+	"github.com/atlassian/jec/runbook"
+	"github.com/atlassian/jec/util"
 	"github.com/atlassian/jec/worker_pool"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -24,11 +29,16 @@ const (
 	pollingWaitIntervalInMillis = 100
 	visibilityTimeoutInSec      = 30
 	maxNumberOfMessages         = 10
+	waitTimeInSec               = 20
+
+	dedupeTtlInSec = 24 * 60 * 60
 
 	successRefreshPeriod = time.Minute
 	errorRefreshPeriod   = time.Minute
 
 	repositoryRefreshPeriod = time.Minute
+
+	degradedRetryPeriod = time.Second
 )
 
 const tokenPath = "/jsm/ops/jec/v1/credentials"
@@ -40,11 +50,31 @@ type Processor interface {
 	Stop() error
 }
 
+// PoolManager is implemented by the processors whose worker pools can be resized at runtime.
+type PoolManager interface {
+	PoolConfs() map[string]conf.PoolConf
+	ResizePool(name string, poolConf conf.PoolConf) error
+	ReloadPools(configuration *conf.Configuration) error
+}
+
 type processor struct {
+	name       string
 	workerPool worker_pool.WorkerPool
 	pollers    map[string]Poller
 
-	retryer *retryer.Retryer
+	// the shared worker pool and result outbox are started and stopped by the multi processor
+	isWorkerPoolShared   bool
+	isResultOutboxShared bool
+
+	retryer           *retryer.Retryer
+	signatureVerifier SignatureVerifier
+	actionLimiter     ActionLimiter
+	dedupeStore       dedupe.Store
+	resultOutbox      *runbook.Outbox
+	tokenCache        *tokenCache
+	sqsHttpClient     *http.Client
+	inFlightRequests  *util.KeyedMutex
+	executionLocks    *util.KeyedMutex
 
 	configuration *conf.Configuration
 	repositories  git.Repositories
@@ -53,6 +83,10 @@ type processor struct {
 	successRefreshPeriod time.Duration
 	errorRefreshPeriod   time.Duration
 
+	readinessErr   error
+	readinessMu    *sync.RWMutex
+	circuitBreaker *retryer.CircuitBreaker
+
 	isRunning   bool
 	isRunningWg *sync.WaitGroup
 	startStopMu *sync.Mutex
@@ -76,10 +110,74 @@ func NewProcessor(conf *conf.Configuration) Processor {
 		conf.PollerConf.VisibilityTimeoutInSeconds = visibilityTimeoutInSec
 	}
 
-	return &processor{
+	if conf.PollerConf.WaitTimeInSeconds <= 0 || conf.PollerConf.WaitTimeInSeconds > 20 {
+		logrus.Infof("Long polling wait time should be between 1 and 20 seconds, default value[%d s.] is set.", waitTimeInSec)
+		conf.PollerConf.WaitTimeInSeconds = waitTimeInSec
+	}
+
+	if conf.DedupeConf.Enabled && conf.DedupeConf.TtlInSeconds <= 0 {
+		logrus.Infof("Dedupe time to live should be greater than 0, default value[%d s.] is set.", dedupeTtlInSec)
+		conf.DedupeConf.TtlInSeconds = dedupeTtlInSec
+	}
+
+	if conf.PollerConf.PrefetchBufferSize < 0 {
+		logrus.Infof("Prefetch buffer size cannot be lesser than zero, prefetch buffer is disabled.")
+		conf.PollerConf.PrefetchBufferSize = 0
+	}
+
+	clients := newSharedClients(conf)
+	runbook.SetRetryer(retryer.New(conf.RetryConf.Callback, nil, clients.transport))
+	runbook.SetInstance(conf.InstanceConf.Id, conf.InstanceConf.Labels)
+
+	if len(conf.Integrations) != 0 {
+		return newMultiProcessor(conf, clients)
+	}
+	return newProcessor(conf, newWorkerPool(conf, ""), clients)
+}
+
+// sharedClients are used by the processors of all integrations to connect Jira Service Management and the queues.
+type sharedClients struct {
+	transport     http.RoundTripper
+	sqsHttpClient *http.Client
+}
+
+func newSharedClients(conf *conf.Configuration) *sharedClients {
+	clients := &sharedClients{}
+	if !conf.TransportConf.IsEmpty() {
+		httpTransport, err := conf.TransportConf.HttpTransport()
+		if err != nil {
+			logrus.Errorf("Transport configuration could not be applied, default transport is used: %s", err)
+		} else {
+			clients.transport = httpTransport
+			clients.sqsHttpClient = &http.Client{Transport: httpTransport}
+			git.SetHttpTransport(httpTransport)
+		}
+	}
+	return clients
+}
+
+// newCircuitBreaker creates the circuit breaker of the requests of the integration to Jira Service Management,
+// each integration has its own breaker so that a failing api key or base url does not stop the others.
+func newCircuitBreaker(conf *conf.Configuration) *retryer.CircuitBreaker {
+	if !conf.CircuitBreakerConf.Enabled {
+		return nil
+	}
+	if conf.IntegrationName == "" {
+		return retryer.NewCircuitBreaker("jsm", conf.CircuitBreakerConf)
+	}
+	return retryer.NewCircuitBreaker("jsm["+conf.IntegrationName+"]", conf.CircuitBreakerConf)
+}
+
+func newProcessor(conf *conf.Configuration, workerPool worker_pool.WorkerPool, clients *sharedClients) *processor {
+
+	circuitBreaker := newCircuitBreaker(conf)
+	runbook.SetIntegration(conf.IntegrationName, conf.ApiKey, retryer.New(conf.RetryConf.Callback, circuitBreaker, clients.transport))
+
+	qp := &processor{
+		name:                 conf.IntegrationName,
 		successRefreshPeriod: successRefreshPeriod,
 		errorRefreshPeriod:   errorRefreshPeriod,
-		workerPool:           worker_pool.New(&conf.PoolConf),
+		workerPool:           workerPool,
 		configuration:        conf,
 		repositories:         git.NewRepositories(),
 		actionLoggers:        newActionLoggers(conf.ActionMappings),
@@ -88,8 +186,41 @@ func NewProcessor(conf *conf.Configuration) Processor {
 		isRunning:            false,
 		isRunningWg:          &sync.WaitGroup{},
 		startStopMu:          &sync.Mutex{},
-		retryer:              &retryer.Retryer{},
+		retryer:              retryer.New(conf.RetryConf.Token, circuitBreaker, clients.transport),
+		sqsHttpClient:        clients.sqsHttpClient,
+		inFlightRequests:     util.NewKeyedMutex(),
+		executionLocks:       util.NewKeyedMutex(),
+		actionLimiter:        NewActionLimiter(integrationLabel(conf.IntegrationName), conf.ActionSpecifications),
+		readinessErr:         errors.New("Queue processor is not started."),
+		readinessMu:          &sync.RWMutex{},
+		circuitBreaker:       circuitBreaker,
+	}
+	if conf.TokenCacheConf.Enabled {
+		qp.tokenCache = newTokenCache(conf.TokenCacheConf.Filepath, conf.ApiKey)
 	}
+	health.Register(qp.healthCheckName(), qp.checkReadiness)
+	return qp
+}
+
+// newWorkerPool creates the worker pools of the configuration, the pool names in the metrics are prefixed
+// with the given prefix to distinguish the dedicated pools of the integrations.
+func newWorkerPool(configuration *conf.Configuration, metricsPrefix string) worker_pool.WorkerPool {
+	defaultPool := worker_pool.NewNamed(metricsPrefix+worker_pool.DefaultPoolName, &configuration.PoolConf)
+	if len(configuration.Pools) == 0 {
+		return defaultPool
+	}
+
+	pools := map[string]worker_pool.WorkerPool{
+		worker_pool.DefaultPoolName: defaultPool,
+	}
+	for name, poolConf := range configuration.Pools {
+		poolConf := poolConf
+		pools[name] = worker_pool.NewNamed(metricsPrefix+name, &poolConf)
+		configuration.Pools[name] = poolConf
+	}
+
+	poolGroup, _ := worker_pool.NewPoolGroup(pools) // the default pool is always in the group
+	return poolGroup
 }
 
 func (qp *processor) Start() error {
@@ -101,31 +232,214 @@ func (qp *processor) Start() error {
 	}
 
 	logrus.Infof("Queue processor is starting.")
-	token, err := qp.receiveToken()
+	signatureVerifier, err := NewSignatureVerifier(qp.configuration.MessageSigning)
 	if err != nil {
-		logrus.Errorf("Queue processor could not get initial token and will terminate.")
+		logrus.Errorf("Queue processor could not create message signature verifier and will terminate.")
 		return err
 	}
+	qp.signatureVerifier = signatureVerifier
+
+	if qp.configuration.DedupeConf.Enabled {
+		dedupeStore, err := dedupe.Open(
+			qp.configuration.DedupeConf.Filepath,
+			time.Duration(qp.configuration.DedupeConf.TtlInSeconds)*time.Second,
+		)
+		if err != nil {
+			logrus.Errorf("Queue processor could not open dedupe store and will terminate.")
+			return err
+		}
+		qp.dedupeStore = dedupeStore
+	}
+
+	if qp.configuration.CircuitBreakerConf.Enabled && !qp.isResultOutboxShared {
+		resultOutbox, err := runbook.OpenOutbox(qp.configuration.CircuitBreakerConf.OutboxFilepath)
+		if err != nil {
+			logrus.Errorf("Queue processor could not open result outbox and will terminate.")
+			qp.closeDedupeStore()
+			return err
+		}
+		qp.resultOutbox = resultOutbox
+		runbook.SetOutbox(resultOutbox)
+	}
+
+	if !qp.isWorkerPoolShared {
+		qp.workerPool.Start()
+	}
+	qp.isRunningWg.Add(1) // one for receiving token
 
-	err = qp.repositories.DownloadAll(qp.configuration.ActionMappings.GitActions())
+	token, isCached, err := qp.prepare()
 	if err != nil {
-		logrus.Errorf("Queue processor could not clone a git repository and will terminate.")
-		return err
+		logrus.Warnf("Queue processor is starting in degraded state, it will retry until it is ready: %s", err)
+		qp.setReadiness(err)
+		go qp.runDegraded()
+	} else {
+		qp.activate(token, isCached)
+		go qp.run(isCached)
+	}
+
+	qp.registerCircuitBreakerDetail()
+	qp.isRunning = true
+	return nil
+}
+
+// registerCircuitBreakerDetail reports the state of the circuit breaker in the health report, an open breaker of
+// an integration does not fail the health of the process since the other integrations may still work.
+func (qp *processor) registerCircuitBreakerDetail() {
+	if qp.circuitBreaker == nil {
+		return
+	}
+	health.RegisterDetail(qp.circuitBreakerDetailName(), func() string {
+		return qp.circuitBreaker.State().String()
+	})
+}
+
+func (qp *processor) circuitBreakerDetailName() string {
+	return "circuitBreaker[" + qp.circuitBreaker.Name() + "]"
+}
+
+// prepare clones the git repositories and receives the token which are required to poll the queues,
+// a still valid cached token is used instead of receiving one.
+func (qp *processor) prepare() (*token, bool, error) {
+	err := qp.repositories.DownloadAll(qp.configuration.ActionMappings.GitActions())
+	if err != nil {
+		return nil, false, errors.Errorf("Git repositories could not be cloned: %s", err)
+	}
+
+	if cachedToken := qp.loadCachedToken(); cachedToken != nil {
+		logrus.Infof("Pollers are started with the cached token, a fresh token will be received in the background.")
+		return cachedToken, true, nil
+	}
+
+	token, err := qp.receiveToken()
+	if err != nil {
+		return nil, false, errors.Errorf("Initial token could not be received: %s", err)
 	}
+	return token, false, nil
+}
 
+// quitContext returns a context which is canceled when the processor is stopped.
+func (qp *processor) quitContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-qp.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+func (qp *processor) loadCachedToken() *token {
+	if qp.tokenCache == nil {
+		return nil
+	}
+	cachedToken, err := qp.tokenCache.Load()
+	if err != nil {
+		logrus.Warnf("Cached token could not be loaded: %s", err)
+		qp.tokenCache.Remove()
+		return nil
+	}
+	return cachedToken
+}
+
+// saveTokenCache caches the current credentials of the pollers since the refreshed tokens only contain the renewed ones.
+func (qp *processor) saveTokenCache(ownerId string) {
+	if qp.tokenCache == nil {
+		return
+	}
+
+	cachedToken := &token{
+		OwnerId:             ownerId,
+		QueuePropertiesList: make([]Properties, 0, len(qp.pollers)),
+	}
+	for _, poller := range qp.pollers {
+		cachedToken.QueuePropertiesList = append(cachedToken.QueuePropertiesList, poller.QueueProvider().Properties())
+	}
+
+	err := qp.tokenCache.Save(cachedToken)
+	if err != nil {
+		logrus.Warnf("Token could not be cached: %s", err)
+	}
+}
+
+func (qp *processor) activate(token *token, isCached bool) {
 	if qp.repositories.NotEmpty() {
 		qp.isRunningWg.Add(1) // one for pulling repositories
 		go qp.startPullingRepositories(repositoryRefreshPeriod)
 
 		conf.AddRepositoryPathToGitActionFilepaths(qp.configuration.ActionMappings, qp.repositories)
 	}
-	qp.workerPool.Start()
 	qp.refreshPollers(token)
-	qp.isRunningWg.Add(1) // one for receiving token
-	go qp.run()
+	if !isCached {
+		qp.saveTokenCache(token.OwnerId)
+		qp.flushResultOutbox()
+	}
+	qp.setReadiness(nil)
+}
 
-	qp.isRunning = true
-	return nil
+// runDegraded retries preparing with backoff until it succeeds or the processor is stopped.
+func (qp *processor) runDegraded() {
+
+	waitDuration := degradedRetryPeriod
+	timer := time.NewTimer(waitDuration)
+
+	for {
+		select {
+		case <-qp.quit:
+			timer.Stop()
+			qp.isRunningWg.Done()
+			return
+		case <-timer.C:
+			token, isCached, err := qp.prepare()
+			if err != nil {
+				qp.setReadiness(err)
+
+				waitDuration *= 2
+				if waitDuration > qp.errorRefreshPeriod {
+					waitDuration = qp.errorRefreshPeriod
+				}
+				logrus.Warnf("Queue processor is still in degraded state, it will retry after %s: %s", waitDuration.String(), err)
+				timer = time.NewTimer(waitDuration)
+				break
+			}
+
+			logrus.Infof("Queue processor has recovered from degraded state.")
+			qp.activate(token, isCached)
+			qp.run(isCached)
+			return
+		}
+	}
+}
+
+func (qp *processor) setReadiness(err error) {
+	qp.readinessMu.Lock()
+	defer qp.readinessMu.Unlock()
+
+	qp.readinessErr = err
+	if err == nil {
+		processorReady.WithLabelValues(qp.integrationLabel()).Set(1)
+	} else {
+		processorReady.WithLabelValues(qp.integrationLabel()).Set(0)
+	}
+}
+
+func (qp *processor) healthCheckName() string {
+	if qp.name == "" {
+		return "queueProcessor"
+	}
+	return "queueProcessor[" + qp.name + "]"
+}
+
+func (qp *processor) integrationLabel() string {
+	return integrationLabel(qp.name)
+}
+
+// checkReadiness returns the reason why the processor cannot process messages yet, or nil if it is ready.
+func (qp *processor) checkReadiness() error {
+	qp.readinessMu.RLock()
+	defer qp.readinessMu.RUnlock()
+	return qp.readinessErr
 }
 
 func (qp *processor) Stop() error {
@@ -136,19 +450,139 @@ func (qp *processor) Stop() error {
 		return errors.New("Queue processor is not running.")
 	}
 
+	qp.stopPolling()
+	qp.release()
+	return nil
+}
+
+// stopPolling stops the pollers, and the worker pool unless it is shared. The jobs in the pool are completed
+// before it stops, so that the resources they use should be released afterwards.
+func (qp *processor) stopPolling() {
 	logrus.Infof("Queue processor is stopping.")
 
 	close(qp.quit)
 	qp.isRunningWg.Wait()
 
-	qp.workerPool.Stop()
+	if !qp.isWorkerPoolShared {
+		qp.workerPool.Stop()
+	}
+}
+
+// release removes the repositories and closes the stores which are used by the jobs of the processor.
+func (qp *processor) release() {
+	if qp.circuitBreaker != nil {
+		health.Unregister(qp.circuitBreakerDetailName())
+	}
 	qp.repositories.RemoveAll()
+	qp.closeDedupeStore()
+	qp.closeResultOutbox()
+	qp.setReadiness(errors.New("Queue processor is not running."))
 
 	qp.isRunning = false
 	logrus.Infof("Queue processor has stopped.")
+}
+
+func (qp *processor) PoolConfs() map[string]conf.PoolConf {
+	return workerPoolConfs(qp.workerPool)
+}
+
+func (qp *processor) ResizePool(name string, poolConf conf.PoolConf) error {
+	return resizeWorkerPool(qp.workerPool, name, poolConf)
+}
+
+// ReloadPools resizes the worker pools according to the given configuration. Adding or removing a pool
+// requires a restart since the actions are bound to their pools when the processor is created.
+func (qp *processor) ReloadPools(configuration *conf.Configuration) error {
+	return reloadWorkerPools(qp.workerPool, configuration)
+}
+
+func workerPoolConfs(workerPool worker_pool.WorkerPool) map[string]conf.PoolConf {
+	if poolGroup, ok := workerPool.(worker_pool.PoolGroup); ok {
+		return poolGroup.PoolConfs()
+	}
+	return map[string]conf.PoolConf{
+		worker_pool.DefaultPoolName: workerPool.PoolConf(),
+	}
+}
+
+func resizeWorkerPool(workerPool worker_pool.WorkerPool, name string, poolConf conf.PoolConf) error {
+	if poolGroup, ok := workerPool.(worker_pool.PoolGroup); ok {
+		return poolGroup.ResizePool(name, poolConf)
+	}
+	if name != worker_pool.DefaultPoolName {
+		return errors.Errorf("Worker pool[%s] does not exist.", name)
+	}
+	return workerPool.Resize(poolConf)
+}
+
+func reloadWorkerPools(workerPool worker_pool.WorkerPool, configuration *conf.Configuration) error {
+	poolConfs := map[string]conf.PoolConf{
+		worker_pool.DefaultPoolName: configuration.PoolConf,
+	}
+	for name, poolConf := range configuration.Pools {
+		poolConfs[name] = poolConf
+	}
+
+	currentPoolConfs := workerPoolConfs(workerPool)
+	for name := range currentPoolConfs {
+		if _, ok := poolConfs[name]; !ok {
+			logrus.Warnf("Worker pool[%s] is removed from the configuration, it will be kept until JEC is restarted.", name)
+		}
+	}
+
+	for name, poolConf := range poolConfs {
+		if _, ok := currentPoolConfs[name]; !ok {
+			logrus.Warnf("Worker pool[%s] is added to the configuration, it will be created when JEC is restarted.", name)
+			continue
+		}
+
+		worker_pool.ApplyDefaults(&poolConf)
+		err := resizeWorkerPool(workerPool, name, poolConf)
+		if err != nil {
+			return errors.Errorf("Worker pool[%s] could not be resized: %s", name, err)
+		}
+	}
 	return nil
 }
 
+func (qp *processor) closeDedupeStore() {
+	if qp.dedupeStore == nil {
+		return
+	}
+	err := qp.dedupeStore.Close()
+	if err != nil {
+		logrus.Warnf("Dedupe store could not be closed: %s", err)
+	}
+	qp.dedupeStore = nil
+}
+
+func (qp *processor) closeResultOutbox() {
+	if qp.resultOutbox == nil || qp.isResultOutboxShared {
+		return
+	}
+	runbook.SetOutbox(nil)
+	err := qp.resultOutbox.Close()
+	if err != nil {
+		logrus.Warnf("Result outbox could not be closed: %s", err)
+	}
+	qp.resultOutbox = nil
+}
+
+// flushResultOutbox sends the results which are kept while Jira Service Management was unreachable.
+func (qp *processor) flushResultOutbox() {
+	if qp.resultOutbox == nil || qp.resultOutbox.Len() == 0 {
+		return
+	}
+
+	sentCount, err := qp.resultOutbox.Flush()
+	if err != nil {
+		logrus.Warnf("Results in the outbox could not be sent: %s", err)
+	}
+	if sentCount > 0 {
+		logrus.Infof("%d results in the outbox are sent, %d results are left.", sentCount, qp.resultOutbox.Len())
+	}
+}
+
 func (qp *processor) receiveToken() (*token, error) {
 
 	tokenUrl := qp.configuration.BaseUrl + tokenPath
@@ -160,6 +594,12 @@ func (qp *processor) receiveToken() (*token, error) {
 
 	request.Header.Add("Authorization", "GenieKey "+qp.configuration.ApiKey)
 	request.Header.Add("X-JEC-Client-Info", UserAgentHeader)
+	if qp.configuration.InstanceConf.Id != "" {
+		request.Header.Add("X-JEC-Instance-Id", qp.configuration.InstanceConf.Id)
+	}
+	if len(qp.configuration.InstanceConf.Labels) != 0 {
+		request.Header.Add("X-JEC-Instance-Labels", qp.configuration.InstanceConf.FormatLabels())
+	}
 
 	query := request.URL.Query()
 	for _, poller := range qp.pollers {
@@ -171,6 +611,11 @@ func (qp *processor) receiveToken() (*token, error) {
 	}
 	request.URL.RawQuery = query.Encode()
 
+	// stopping the processor should not wait for the retries of the token request
+	ctx, cancel := qp.quitContext()
+	defer cancel()
+	request.Request = request.WithContext(ctx)
+
 	response, err := qp.retryer.Do(request)
 	if err != nil {
 		return nil, err
@@ -195,21 +640,34 @@ func (qp *processor) receiveToken() (*token, error) {
 
 func (qp *processor) addPoller(queueProperties Properties, ownerId string) (Poller, error) {
 
-	queueProvider, err := NewSqsProvider(queueProperties)
+	queueProvider, err := NewSqsProvider(queueProperties, qp.sqsHttpClient)
 	if err != nil {
 		return nil, err
 	}
 
 	messageHandler := &messageHandler{
-		repositories:  qp.repositories,
-		actionSpecs:   qp.configuration.ActionSpecifications,
-		actionLoggers: qp.actionLoggers,
+		repositories:     qp.repositories,
+		actionSpecs:      qp.configuration.ActionSpecifications,
+		actionLoggers:    qp.actionLoggers,
+		dedupeStore:      qp.dedupeStore,
+		inFlightRequests: qp.inFlightRequests,
+		workerPool:       qp.workerPool,
+		actionLimiter:    qp.actionLimiter,
+		integration:      qp.name,
+		quit:             qp.quit,
+	}
+
+	if qp.configuration.SerializationConf.Enabled {
+		messageHandler.serializationKey = qp.configuration.SerializationConf.Key
+		messageHandler.executionLocks = qp.executionLocks
 	}
 
 	poller := newPollerFunc(
 		qp.workerPool,
 		queueProvider,
 		messageHandler,
+		qp.signatureVerifier,
+		qp.actionLimiter,
 		qp.configuration,
 		ownerId,
 	)
@@ -268,11 +726,16 @@ func (qp *processor) refreshPollers(token *token) {
 	}
 }
 
-func (qp *processor) run() {
+// run refreshes the token periodically, refreshNow makes the first refresh before waiting for the period.
+func (qp *processor) run(refreshNow bool) {
 
 	logrus.Infof("Queue processor has started to run. Refresh client period: %s.", qp.successRefreshPeriod.String())
 
-	ticker := time.NewTicker(qp.successRefreshPeriod)
+	refreshPeriod := qp.successRefreshPeriod
+	if refreshNow {
+		refreshPeriod = qp.refresh()
+	}
+	ticker := time.NewTicker(refreshPeriod)
 
 	for {
 		select {
@@ -285,20 +748,26 @@ func (qp *processor) run() {
 			return
 		case <-ticker.C:
 			ticker.Stop()
-			token, err := qp.receiveToken()
-			if err != nil {
-				logrus.Warnf("Refresh cycle of queue processor has failed: %s", err)
-				logrus.Debugf("Will refresh token after %s", qp.errorRefreshPeriod.String())
-				ticker = time.NewTicker(qp.errorRefreshPeriod)
-				break
-			}
-			qp.refreshPollers(token)
-
-			ticker = time.NewTicker(qp.successRefreshPeriod)
+			ticker = time.NewTicker(qp.refresh())
 		}
 	}
 }
 
+// refresh receives a token to refresh the pollers and returns the period to wait until the next refresh.
+func (qp *processor) refresh() time.Duration {
+	token, err := qp.receiveToken()
+	if err != nil {
+		logrus.Warnf("Refresh cycle of queue processor has failed: %s", err)
+		logrus.Debugf("Will refresh token after %s", qp.errorRefreshPeriod.String())
+		return qp.errorRefreshPeriod
+	}
+	qp.refreshPollers(token)
+	qp.saveTokenCache(token.OwnerId)
+	qp.flushResultOutbox()
+
+	return qp.successRefreshPeriod
+}
+
 func (qp *processor) startPullingRepositories(pullPeriod time.Duration) {
 
 	logrus.Infof("Repositories will be updated in every %s.", pullPeriod.String())
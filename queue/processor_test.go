@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"github.com/atlassian/jec/conf"
 	"github.com/atlassian/jec/git"
+	"github.com/atlassian/jec/health"
 	"github.com/atlassian/jec/retryer"
 	"github.com/atlassian/jec/worker_pool"
 	"github.com/pkg/errors"
@@ -14,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -43,6 +45,7 @@ func newQueueProcessorTest() *processor {
 		isRunningWg:          &sync.WaitGroup{},
 		startStopMu:          &sync.Mutex{},
 		retryer:              &retryer.Retryer{},
+		readinessMu:          &sync.RWMutex{},
 	}
 }
 
@@ -86,6 +89,7 @@ func TestValidateNewQueueProcessor(t *testing.T) {
 	assert.Equal(t, int64(maxNumberOfMessages), processor.configuration.PollerConf.MaxNumberOfMessages)
 	assert.Equal(t, int64(visibilityTimeoutInSec), processor.configuration.PollerConf.VisibilityTimeoutInSeconds)
 	assert.Equal(t, time.Duration(pollingWaitIntervalInMillis), processor.configuration.PollerConf.PollingWaitIntervalInMillis)
+	assert.Equal(t, int64(waitTimeInSec), processor.configuration.PollerConf.WaitTimeInSeconds)
 }
 
 func TestStartAndStopQueueProcessor(t *testing.T) {
@@ -108,6 +112,59 @@ func TestStartAndStopQueueProcessor(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestQueueProcessorReportsCircuitBreakerWhileRunning(t *testing.T) {
+
+	defer func() {
+		newPollerFunc = NewPoller
+	}()
+
+	processor := newQueueProcessorTest()
+	processor.circuitBreaker = retryer.NewCircuitBreaker("jsm[test]", conf.CircuitBreakerConf{})
+
+	processor.retryer.DoFunc = mockHttpGet
+	newPollerFunc = NewMockPollerForQueueProcessor
+
+	err := processor.Start()
+	assert.Nil(t, err)
+	assert.Equal(t, "closed", health.Status().Details["circuitBreaker[jsm[test]]"])
+
+	err = processor.Stop()
+	assert.Nil(t, err)
+	assert.NotContains(t, health.Status().Details, "circuitBreaker[jsm[test]]")
+}
+
+func TestReloadPools(t *testing.T) {
+
+	processor := newQueueProcessorTest()
+
+	var resizedConf conf.PoolConf
+	processor.workerPool.(*MockWorkerPool).PoolConfFunc = func() conf.PoolConf {
+		return *mockPoolConf
+	}
+	processor.workerPool.(*MockWorkerPool).ResizeFunc = func(poolConf conf.PoolConf) error {
+		resizedConf = poolConf
+		return nil
+	}
+
+	err := processor.ReloadPools(&conf.Configuration{
+		PoolConf: conf.PoolConf{MaxNumberOfWorker: 8, MinNumberOfWorker: 4},
+		Pools:    map[string]conf.PoolConf{"slow": {MaxNumberOfWorker: 1}},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, int32(8), resizedConf.MaxNumberOfWorker)
+	assert.Equal(t, int32(4), resizedConf.MinNumberOfWorker)
+}
+
+func TestResizeUnknownPool(t *testing.T) {
+
+	processor := newQueueProcessorTest()
+
+	err := processor.ResizePool("slow", conf.PoolConf{MaxNumberOfWorker: 1})
+
+	assert.EqualError(t, err, "Worker pool[slow] does not exist.")
+}
+
 func TestStartQueueProcessorAndRefresh(t *testing.T) {
 
 	defer func() {
@@ -132,7 +189,7 @@ func TestStartQueueProcessorAndRefresh(t *testing.T) {
 	assert.Nil(t, err)
 }
 
-func TestStartQueueProcessorInitialError(t *testing.T) {
+func TestStartQueueProcessorInDegradedState(t *testing.T) {
 
 	defer func() {
 		newPollerFunc = NewPoller
@@ -140,13 +197,108 @@ func TestStartQueueProcessorInitialError(t *testing.T) {
 
 	processor := newQueueProcessorTest()
 
-	processor.retryer.DoFunc = mockHttpGetError
+	tokenRequestCount := int32(0)
+	processor.retryer.DoFunc = func(r *retryer.Retryer, request *retryer.Request) (*http.Response, error) {
+		if atomic.AddInt32(&tokenRequestCount, 1) == 1 {
+			return mockHttpGetError(r, request)
+		}
+		return mockHttpGet(r, request)
+	}
 	newPollerFunc = NewMockPollerForQueueProcessor
 
 	err := processor.Start()
 
-	assert.NotNil(t, err)
-	assert.Equal(t, "Test http error has occurred while getting token.", err.Error())
+	assert.Nil(t, err)
+	assert.EqualError(t, processor.checkReadiness(), "Initial token could not be received: Test http error has occurred while getting token.")
+
+	for i := 0; i < 50 && processor.checkReadiness() != nil; i++ {
+		time.Sleep(degradedRetryPeriod / 10)
+	}
+	assert.Nil(t, processor.checkReadiness())
+
+	err = processor.Stop()
+	assert.Nil(t, err)
+	assert.EqualError(t, processor.checkReadiness(), "Queue processor is not running.")
+}
+
+func TestStartQueueProcessorWithCachedToken(t *testing.T) {
+
+	defer func() {
+		newPollerFunc = NewPoller
+	}()
+
+	cache, cleanup := newTokenCacheTest(t, mockConf.ApiKey)
+	defer cleanup()
+	cache.Save(&mockToken)
+
+	processor := newQueueProcessorTest()
+	processor.tokenCache = cache
+
+	tokenReceived := make(chan struct{})
+	processor.retryer.DoFunc = func(r *retryer.Retryer, request *retryer.Request) (*http.Response, error) {
+		<-tokenReceived
+		return mockHttpGet(r, request)
+	}
+	newPollerFunc = NewMockPollerForQueueProcessor
+
+	err := processor.Start()
+
+	assert.Nil(t, err)
+	assert.Nil(t, processor.checkReadiness())
+	assert.Equal(t, 2, len(processor.pollers))
+
+	close(tokenReceived)
+	err = processor.Stop()
+	assert.Nil(t, err)
+}
+
+func TestStopQueueProcessorInDegradedState(t *testing.T) {
+
+	processor := newQueueProcessorTest()
+	processor.retryer.DoFunc = mockHttpGetError
+
+	err := processor.Start()
+	assert.Nil(t, err)
+	assert.NotNil(t, processor.checkReadiness())
+
+	err = processor.Stop()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(processor.pollers))
+}
+
+func TestStopQueueProcessorInterruptsRetriesOfPreparing(t *testing.T) {
+
+	processor := newQueueProcessorTest()
+
+	tokenRequestCount := int32(0)
+	retriedTokenRequest := make(chan struct{})
+	processor.retryer.DoFunc = func(r *retryer.Retryer, request *retryer.Request) (*http.Response, error) {
+		if atomic.AddInt32(&tokenRequestCount, 1) == 1 {
+			return mockHttpGetError(r, request)
+		}
+		close(retriedTokenRequest)
+		<-request.Context().Done()
+		return nil, request.Context().Err()
+	}
+
+	err := processor.Start()
+	assert.Nil(t, err)
+
+	select {
+	case <-retriedTokenRequest:
+	case <-time.After(5 * degradedRetryPeriod):
+		t.Fatal("Token request is not retried.")
+	}
+
+	stopped := make(chan error)
+	go func() { stopped <- processor.Stop() }()
+
+	select {
+	case err = <-stopped:
+		assert.Nil(t, err)
+	case <-time.After(degradedRetryPeriod):
+		t.Fatal("Queue processor did not stop while preparing.")
+	}
 }
 
 func TestStopQueueProcessorWhileNotRunning(t *testing.T) {
@@ -189,6 +341,29 @@ func TestReceiveToken(t *testing.T) {
 	assert.Equal(t, "/jsm/ops/jec/v1/credentials", actualRequest.URL.Path)
 }
 
+func TestReceiveTokenWithInstanceIdentity(t *testing.T) {
+
+	processor := newQueueProcessorTest()
+	configuration := *mockConf
+	configuration.InstanceConf = conf.InstanceConf{
+		Id:     "jec-1",
+		Labels: map[string]string{"zone": "eu-1", "env": "prod"},
+	}
+	processor.configuration = &configuration
+
+	var actualRequest *http.Request
+	processor.retryer.DoFunc = func(retryer *retryer.Retryer, request *retryer.Request) (*http.Response, error) {
+		actualRequest = request.Request
+		return mockHttpGet(retryer, request)
+	}
+
+	_, err := processor.receiveToken()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "jec-1", actualRequest.Header.Get("X-JEC-Instance-Id"))
+	assert.Equal(t, "env=prod,zone=eu-1", actualRequest.Header.Get("X-JEC-Instance-Labels"))
+}
+
 func TestReceiveTokenInvalidJson(t *testing.T) {
 
 	processor := newQueueProcessorTest()
@@ -411,6 +586,8 @@ type MockWorkerPool struct {
 	StartFunc                   func() error
 	StopFunc                    func() error
 	SubmitFunc                  func(worker_pool.Job) (bool, error)
+	PoolConfFunc                func() conf.PoolConf
+	ResizeFunc                  func(poolConf conf.PoolConf) error
 }
 
 func NewMockWorkerPool() *MockWorkerPool {
@@ -444,3 +621,17 @@ func (m *MockWorkerPool) Submit(job worker_pool.Job) (bool, error) {
 	}
 	return false, nil
 }
+
+func (m *MockWorkerPool) PoolConf() conf.PoolConf {
+	if m.PoolConfFunc != nil {
+		return m.PoolConfFunc()
+	}
+	return conf.PoolConf{}
+}
+
+func (m *MockWorkerPool) Resize(poolConf conf.PoolConf) error {
+	if m.ResizeFunc != nil {
+		return m.ResizeFunc(poolConf)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"github.com/atlassian/jec/conf"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// resolveActionName returns the mapped action which handles the payload with the reason why the routed mapped
+// action cannot handle it, if so. The fallback action handles the requests which the routed mapped action cannot,
+// if it is compatible with them; the returned name is empty if neither of them can handle the request.
+func resolveActionName(actionSpecs *conf.ActionSpecifications, queuePayload *payload) (conf.ActionName, error) {
+	action := queuePayload.actionName()
+	name := mappedActionName(actionSpecs.Routes, queuePayload)
+
+	var reason error
+	mappedAction, ok := actionSpecs.ActionMappings[name]
+	if !ok {
+		reason = errors.Errorf("No mapped action is configured for requested action[%s].", action)
+	} else if !isCompatible(mappedAction.Type, queuePayload.ActionType) {
+		reason = errors.Errorf("The type[%s] of the mapped action[%s] is not compatible with requested type[%s].",
+			mappedAction.Type, name, queuePayload.ActionType)
+	} else {
+		return name, nil
+	}
+
+	fallbackAction, ok := actionSpecs.ActionMappings[actionSpecs.FallbackAction]
+	if !ok || !isCompatible(fallbackAction.Type, queuePayload.ActionType) {
+		return "", reason
+	}
+	return actionSpecs.FallbackAction, reason
+}
+
+// mappedActionName resolves the mapped action of the payload, the first route of the action which matches
+// the payload takes precedence over the mapped action with the same name as the action.
+func mappedActionName(routes []conf.Route, queuePayload *payload) conf.ActionName {
+	action := queuePayload.actionName()
+	for index := range routes {
+		if routeMatches(&routes[index], action, queuePayload) {
+			return routes[index].MappedAction
+		}
+	}
+	return conf.ActionName(action)
+}
+
+func routeMatches(route *conf.Route, action string, queuePayload *payload) bool {
+	if route.Action != action {
+		return false
+	}
+	if route.EntityType != "" && !strings.EqualFold(route.EntityType, queuePayload.Entity.Type) {
+		return false
+	}
+	if route.Priority != "" && !strings.EqualFold(route.Priority, queuePayload.Alert.Priority) {
+		return false
+	}
+	if route.ExtraField != "" && route.ExtraField != queuePayload.MappedAction.ExtraField {
+		return false
+	}
+	for _, tag := range route.Tags {
+		if !containsTag(queuePayload.Alert.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, alertTag := range tags {
+		if alertTag == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package queue
+
+import (
+	"github.com/atlassian/jec/conf"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMappedActionName(t *testing.T) {
+	routes := []conf.Route{
+		{Action: "Restart", EntityType: "alert", ExtraField: "api", MappedAction: "RestartApi"},
+		{Action: "Restart", Tags: []string{"db", "prod"}, MappedAction: "RestartDatabase"},
+		{Action: "Restart", MappedAction: "RestartAny"},
+	}
+
+	testCases := []struct {
+		payload  payload
+		expected conf.ActionName
+	}{
+		{payload{Action: "Restart", Entity: entity{Type: "Alert"}, MappedAction: mappedAction{ExtraField: "api"}}, "RestartApi"},
+		{payload{Action: "Restart", Entity: entity{Type: "incident"}, MappedAction: mappedAction{ExtraField: "api"}}, "RestartAny"},
+		{payload{Action: "Restart", Alert: alert{Tags: []string{"prod", "db"}}}, "RestartDatabase"},
+		{payload{Action: "Restart", Alert: alert{Tags: []string{"db"}}}, "RestartAny"},
+		{payload{MappedAction: mappedAction{Name: "Restart"}}, "RestartAny"},
+		{payload{Action: "Create"}, "Create"},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, mappedActionName(routes, &testCase.payload))
+	}
+}
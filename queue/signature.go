@@ -0,0 +1,96 @@
+package queue
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/atlassian/jec/conf"
+	"github.com/pkg/errors"
+	"io/ioutil"
+)
+
+const signature = "signature"
+
+type SignatureVerifier interface {
+	Verify(content []byte, encodedSignature string) error
+}
+
+func NewSignatureVerifier(signingConf conf.MessageSigningConf) (SignatureVerifier, error) {
+	switch signingConf.Algorithm {
+	case "":
+		return nil, nil
+	case conf.HmacSha256SigningAlgorithm:
+		return &hmacVerifier{key: []byte(signingConf.Key)}, nil
+	case conf.RsaSha256SigningAlgorithm:
+		publicKey, err := readRsaPublicKey(signingConf.PublicKeyFilepath)
+		if err != nil {
+			return nil, errors.Errorf("Public key of message signing could not be read: %s", err)
+		}
+		return &rsaVerifier{publicKey: publicKey}, nil
+	default:
+		return nil, errors.Errorf("Unknown message signing algorithm[%s].", signingConf.Algorithm)
+	}
+}
+
+type hmacVerifier struct {
+	key []byte
+}
+
+func (v *hmacVerifier) Verify(content []byte, encodedSignature string) error {
+	signature, err := base64.StdEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return errors.Errorf("Signature could not be decoded: %s", err)
+	}
+
+	mac := hmac.New(sha256.New, v.key)
+	mac.Write(content)
+
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return errors.New("Signature does not match.")
+	}
+	return nil
+}
+
+type rsaVerifier struct {
+	publicKey *rsa.PublicKey
+}
+
+func (v *rsaVerifier) Verify(content []byte, encodedSignature string) error {
+	signature, err := base64.StdEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return errors.Errorf("Signature could not be decoded: %s", err)
+	}
+
+	hashed := sha256.Sum256(content)
+	if err := rsa.VerifyPKCS1v15(v.publicKey, crypto.SHA256, hashed[:], signature); err != nil {
+		return errors.New("Signature does not match.")
+	}
+	return nil
+}
+
+func readRsaPublicKey(filepath string) (*rsa.PublicKey, error) {
+	content, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("No PEM encoded key is found.")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Public key is not an RSA key.")
+	}
+	return publicKey, nil
+}
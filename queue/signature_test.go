@@ -0,0 +1,87 @@
+package queue
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/atlassian/jec/conf"
+	"github.com/atlassian/jec/util"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func signHmac(key []byte, content string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(content))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestNewSignatureVerifierDisabled(t *testing.T) {
+	verifier, err := NewSignatureVerifier(conf.MessageSigningConf{})
+
+	assert.Nil(t, err)
+	assert.Nil(t, verifier)
+}
+
+func TestNewSignatureVerifierUnknownAlgorithm(t *testing.T) {
+	_, err := NewSignatureVerifier(conf.MessageSigningConf{Algorithm: "md5"})
+
+	assert.EqualError(t, err, "Unknown message signing algorithm[md5].")
+}
+
+func TestHmacVerifier(t *testing.T) {
+	verifier, err := NewSignatureVerifier(conf.MessageSigningConf{
+		Algorithm: conf.HmacSha256SigningAlgorithm,
+		Key:       "secret",
+	})
+	assert.Nil(t, err)
+
+	content := `{"action":"Create"}`
+
+	assert.Nil(t, verifier.Verify([]byte(content), signHmac([]byte("secret"), content)))
+	assert.EqualError(t, verifier.Verify([]byte(content), signHmac([]byte("wrong"), content)), "Signature does not match.")
+	assert.EqualError(t, verifier.Verify([]byte(`{"action":"Close"}`), signHmac([]byte("secret"), content)), "Signature does not match.")
+	assert.NotNil(t, verifier.Verify([]byte(content), "not base64!"))
+}
+
+func TestRsaVerifier(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	assert.Nil(t, err)
+	publicKeyPem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes})
+
+	publicKeyFilepath, err := util.CreateTempTestFile(publicKeyPem, ".pem")
+	assert.Nil(t, err)
+	defer os.Remove(publicKeyFilepath)
+
+	verifier, err := NewSignatureVerifier(conf.MessageSigningConf{
+		Algorithm:         conf.RsaSha256SigningAlgorithm,
+		PublicKeyFilepath: publicKeyFilepath,
+	})
+	assert.Nil(t, err)
+
+	content := []byte(`{"action":"Create"}`)
+	hashed := sha256.Sum256(content)
+	rawSignature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hashed[:])
+	assert.Nil(t, err)
+
+	assert.Nil(t, verifier.Verify(content, base64.StdEncoding.EncodeToString(rawSignature)))
+	assert.EqualError(t, verifier.Verify([]byte(`{"action":"Close"}`), base64.StdEncoding.EncodeToString(rawSignature)), "Signature does not match.")
+}
+
+func TestRsaVerifierWithMissingPublicKey(t *testing.T) {
+	_, err := NewSignatureVerifier(conf.MessageSigningConf{
+		Algorithm:         conf.RsaSha256SigningAlgorithm,
+		PublicKeyFilepath: "/path/to/missing.pem",
+	})
+
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,188 @@
+package queue
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxBatchSize        = 10
+	batchWindowInMillis = 20
+)
+
+type BatchError struct {
+	Failed map[*sqs.Message]error // keyed by the sent message, the same message may be delivered more than once
+}
+
+func (e *BatchError) Error() string {
+	entries := make([]string, 0, len(e.Failed))
+	for message, err := range e.Failed {
+		entries = append(entries, fmt.Sprintf("message[%s]: %s", aws.StringValue(message.MessageId), err))
+	}
+	sort.Strings(entries)
+	return fmt.Sprintf("%d batch entries failed; %s", len(e.Failed), strings.Join(entries, ", "))
+}
+
+func (e *BatchError) merge(other *BatchError) {
+	if other == nil {
+		return
+	}
+	for message, err := range other.Failed {
+		e.Failed[message] = err
+	}
+}
+
+func newBatchError(messages []*sqs.Message, failed []*sqs.BatchResultErrorEntry) *BatchError {
+	if len(failed) == 0 {
+		return nil
+	}
+
+	batchErr := &BatchError{Failed: make(map[*sqs.Message]error, len(failed))}
+	for _, entry := range failed {
+		index := batchEntryIndex(aws.StringValue(entry.Id))
+		if index < 0 || index >= len(messages) {
+			continue
+		}
+		batchErr.Failed[messages[index]] = errors.Errorf("%s: %s", aws.StringValue(entry.Code), aws.StringValue(entry.Message))
+	}
+	return batchErr
+}
+
+func batchEntryId(index int) *string {
+	return aws.String(strconv.Itoa(index))
+}
+
+func batchEntryIndex(id string) int {
+	index, err := strconv.Atoi(id)
+	if err != nil {
+		return -1
+	}
+	return index
+}
+
+func splitIntoBatches(messages []*sqs.Message) [][]*sqs.Message {
+	batches := make([][]*sqs.Message, 0, (len(messages)+maxBatchSize-1)/maxBatchSize)
+	for len(messages) > maxBatchSize {
+		batches = append(batches, messages[:maxBatchSize])
+		messages = messages[maxBatchSize:]
+	}
+	if len(messages) > 0 {
+		batches = append(batches, messages)
+	}
+	return batches
+}
+
+// sendInBatches sends the messages in batches of the maximum size, the failures of a batch call and of its entries
+// are collected into a BatchError keyed by the sent messages.
+func sendInBatches(messages []*sqs.Message, sendFunc func(batch []*sqs.Message) ([]*sqs.BatchResultErrorEntry, error)) error {
+	failed := &BatchError{Failed: make(map[*sqs.Message]error)}
+
+	for _, batch := range splitIntoBatches(messages) {
+		failedEntries, err := sendFunc(batch)
+		if err != nil {
+			for _, message := range batch {
+				failed.Failed[message] = err
+			}
+			continue
+		}
+		failed.merge(newBatchError(batch, failedEntries))
+	}
+
+	if len(failed.Failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+type batchEntry struct {
+	message *sqs.Message
+	result  chan error
+}
+
+// messageBatcher coalesces single message operations which arrive within a short window into one batch call.
+type messageBatcher struct {
+	flushFunc func(messages []*sqs.Message) error
+	window    time.Duration
+
+	pending []*batchEntry
+	timer   *time.Timer
+	mu      *sync.Mutex
+}
+
+func newMessageBatcher(flushFunc func(messages []*sqs.Message) error, window time.Duration) *messageBatcher {
+	return &messageBatcher{
+		flushFunc: flushFunc,
+		window:    window,
+		pending:   make([]*batchEntry, 0, maxBatchSize),
+		mu:        &sync.Mutex{},
+	}
+}
+
+func (b *messageBatcher) Add(message *sqs.Message) error {
+	entry := &batchEntry{
+		message: message,
+		result:  make(chan error, 1),
+	}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, entry)
+	if len(b.pending) >= maxBatchSize {
+		entries := b.takePending()
+		b.mu.Unlock()
+		b.flush(entries)
+	} else {
+		if len(b.pending) == 1 {
+			b.timer = time.AfterFunc(b.window, b.flushPending)
+		}
+		b.mu.Unlock()
+	}
+
+	return <-entry.result
+}
+
+func (b *messageBatcher) flushPending() {
+	b.mu.Lock()
+	entries := b.takePending()
+	b.mu.Unlock()
+
+	b.flush(entries)
+}
+
+func (b *messageBatcher) takePending() []*batchEntry {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	entries := b.pending
+	b.pending = make([]*batchEntry, 0, maxBatchSize)
+	return entries
+}
+
+func (b *messageBatcher) flush(entries []*batchEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	messages := make([]*sqs.Message, 0, len(entries))
+	for _, entry := range entries {
+		messages = append(messages, entry.message)
+	}
+
+	err := b.flushFunc(messages)
+	batchErr, isBatchErr := err.(*BatchError)
+
+	for _, entry := range entries {
+		if isBatchErr {
+			entry.result <- batchErr.Failed[entry.message]
+		} else {
+			entry.result <- err
+		}
+	}
+}
@@ -1,28 +1,37 @@
 package queue
 
 import (
+	"context"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	aws_credentials "github.com/aws/aws-sdk-go/aws/credentials"
+	aws_request "github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"net/http"
 	"strings"
 	"sync"
+	"time"
 )
 
 const ownerId = "ownerId"
 const channelId = "channelId"
 
+const sentTimestamp = sqs.MessageSystemAttributeNameSentTimestamp
+const approximateReceiveCount = sqs.MessageSystemAttributeNameApproximateReceiveCount
+
 type SQSClient interface {
-	ChangeMessageVisibility(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error)
-	DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error)
-	ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error)
+	ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error)
+	DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error)
+	ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...aws_request.Option) (*sqs.ReceiveMessageOutput, error)
 }
 
 type SQSProvider interface {
 	ChangeMessageVisibility(message *sqs.Message, visibilityTimeout int64) error
+	ChangeMessageVisibilityBatch(messages []*sqs.Message, visibilityTimeout int64) error
 	DeleteMessage(message *sqs.Message) error
-	ReceiveMessage(numOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error)
+	DeleteMessageBatch(messages []*sqs.Message) error
+	ReceiveMessage(ctx context.Context, numOfMessage int64, visibilityTimeout int64, waitTimeSeconds int64) ([]*sqs.Message, error)
 
 	RefreshClient(assumeRoleResult AssumeRoleResult) error
 	Properties() Properties
@@ -33,17 +42,28 @@ type sqsProvider struct {
 	queueProperties Properties
 	client          SQSClient
 	isTokenExpired  bool
+	deleteBatcher   *messageBatcher
+	httpClient      *http.Client
+
+	visibilityBatchers   map[int64]*messageBatcher
+	visibilityBatchersMu *sync.Mutex
 
 	refreshClientMu *sync.RWMutex
 	expirationMu    *sync.RWMutex
 }
 
-func NewSqsProvider(queueProperties Properties) (SQSProvider, error) {
+// NewSqsProvider creates a provider whose sessions use the given http client, nil means the default client of the sdk.
+func NewSqsProvider(queueProperties Properties, httpClient *http.Client) (SQSProvider, error) {
 	provider := &sqsProvider{
 		queueProperties: queueProperties,
+		httpClient:      httpClient,
 		refreshClientMu: &sync.RWMutex{},
 		expirationMu:    &sync.RWMutex{},
+
+		visibilityBatchers:   make(map[int64]*messageBatcher),
+		visibilityBatchersMu: &sync.Mutex{},
 	}
+	provider.deleteBatcher = newMessageBatcher(provider.DeleteMessageBatch, batchWindowInMillis*time.Millisecond)
 
 	err := provider.RefreshClient(queueProperties.AssumeRoleResult)
 	if err != nil {
@@ -64,64 +84,114 @@ func (qp *sqsProvider) IsTokenExpired() bool {
 	return qp.isTokenExpired
 }
 
+// ChangeMessageVisibility coalesces the changes requested within a short window into a single batch call,
+// the changes are coalesced by a batcher per visibility timeout.
 func (qp *sqsProvider) ChangeMessageVisibility(message *sqs.Message, visibilityTimeout int64) error {
+	return qp.visibilityBatcherOf(visibilityTimeout).Add(message)
+}
 
-	queueUrl := qp.queueProperties.Url()
+func (qp *sqsProvider) visibilityBatcherOf(visibilityTimeout int64) *messageBatcher {
+	qp.visibilityBatchersMu.Lock()
+	defer qp.visibilityBatchersMu.Unlock()
 
-	request := &sqs.ChangeMessageVisibilityInput{
-		ReceiptHandle:     message.ReceiptHandle,
-		QueueUrl:          &queueUrl,
-		VisibilityTimeout: &visibilityTimeout,
+	batcher, ok := qp.visibilityBatchers[visibilityTimeout]
+	if !ok {
+		batcher = newMessageBatcher(func(messages []*sqs.Message) error {
+			return qp.ChangeMessageVisibilityBatch(messages, visibilityTimeout)
+		}, batchWindowInMillis*time.Millisecond)
+		qp.visibilityBatchers[visibilityTimeout] = batcher
 	}
+	return batcher
+}
 
-	qp.refreshClientMu.RLock()
-	_, err := qp.client.ChangeMessageVisibility(request)
-	qp.checkExpiration(err)
-	qp.refreshClientMu.RUnlock()
+func (qp *sqsProvider) ChangeMessageVisibilityBatch(messages []*sqs.Message, visibilityTimeout int64) error {
 
-	if err != nil {
-		return err
-	}
-	return nil
+	queueUrl := qp.queueProperties.Url()
+
+	return sendInBatches(messages, func(batch []*sqs.Message) ([]*sqs.BatchResultErrorEntry, error) {
+		entries := make([]*sqs.ChangeMessageVisibilityBatchRequestEntry, 0, len(batch))
+		for i, message := range batch {
+			entries = append(entries, &sqs.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                batchEntryId(i),
+				ReceiptHandle:     message.ReceiptHandle,
+				VisibilityTimeout: &visibilityTimeout,
+			})
+		}
+
+		request := &sqs.ChangeMessageVisibilityBatchInput{
+			Entries:  entries,
+			QueueUrl: &queueUrl,
+		}
+
+		qp.refreshClientMu.RLock()
+		result, err := qp.client.ChangeMessageVisibilityBatch(request)
+		qp.checkExpiration(err)
+		qp.refreshClientMu.RUnlock()
+
+		if err != nil || result == nil {
+			return nil, err
+		}
+		return result.Failed, nil
+	})
 }
 
+// DeleteMessage coalesces the deletions requested within a short window into a single batch call.
 func (qp *sqsProvider) DeleteMessage(message *sqs.Message) error {
+	return qp.deleteBatcher.Add(message)
+}
+
+func (qp *sqsProvider) DeleteMessageBatch(messages []*sqs.Message) error {
 
 	queueUrl := qp.queueProperties.Url()
 
-	request := &sqs.DeleteMessageInput{
-		QueueUrl:      &queueUrl,
-		ReceiptHandle: message.ReceiptHandle,
-	}
+	return sendInBatches(messages, func(batch []*sqs.Message) ([]*sqs.BatchResultErrorEntry, error) {
+		entries := make([]*sqs.DeleteMessageBatchRequestEntry, 0, len(batch))
+		for i, message := range batch {
+			entries = append(entries, &sqs.DeleteMessageBatchRequestEntry{
+				Id:            batchEntryId(i),
+				ReceiptHandle: message.ReceiptHandle,
+			})
+		}
 
-	qp.refreshClientMu.RLock()
-	_, err := qp.client.DeleteMessage(request)
-	qp.checkExpiration(err)
-	qp.refreshClientMu.RUnlock()
+		request := &sqs.DeleteMessageBatchInput{
+			Entries:  entries,
+			QueueUrl: &queueUrl,
+		}
 
-	if err != nil {
-		return err
-	}
-	return nil
+		qp.refreshClientMu.RLock()
+		result, err := qp.client.DeleteMessageBatch(request)
+		qp.checkExpiration(err)
+		qp.refreshClientMu.RUnlock()
+
+		if err != nil || result == nil {
+			return nil, err
+		}
+		return result.Failed, nil
+	})
 }
 
-func (qp *sqsProvider) ReceiveMessage(maxNumOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error) {
+func (qp *sqsProvider) ReceiveMessage(ctx context.Context, maxNumOfMessage int64, visibilityTimeout int64, waitTimeSeconds int64) ([]*sqs.Message, error) {
 
 	queueUrl := qp.queueProperties.Url()
 
 	request := &sqs.ReceiveMessageInput{
+		AttributeNames: []*string{
+			aws.String(sentTimestamp),
+			aws.String(approximateReceiveCount),
+		},
 		MessageAttributeNames: []*string{
 			aws.String(ownerId),
 			aws.String(channelId),
+			aws.String(signature),
 		},
 		QueueUrl:            &queueUrl,
 		MaxNumberOfMessages: aws.Int64(maxNumOfMessage),
 		VisibilityTimeout:   aws.Int64(visibilityTimeout),
-		WaitTimeSeconds:     aws.Int64(20),
+		WaitTimeSeconds:     aws.Int64(waitTimeSeconds),
 	}
 
 	qp.refreshClientMu.RLock()
-	result, err := qp.client.ReceiveMessage(request)
+	result, err := qp.client.ReceiveMessageWithContext(ctx, request)
 	qp.checkExpiration(err)
 	qp.refreshClientMu.RUnlock()
 
@@ -164,6 +234,10 @@ func (qp *sqsProvider) newConfig(assumeRoleResult AssumeRoleResult) *aws.Config
 		WithRegion(qp.queueProperties.Region()).
 		WithCredentials(credentials)
 
+	if qp.httpClient != nil {
+		awsConfig = awsConfig.WithHTTPClient(qp.httpClient)
+	}
+
 	return awsConfig
 }
 
@@ -1,23 +1,32 @@
 package queue
 
 import (
+	"context"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	aws_request "github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func newQueueProviderTest() *sqsProvider {
-	return &sqsProvider{
+	provider := &sqsProvider{
 		queueProperties: mockQueueProperties1,
 		refreshClientMu: &sync.RWMutex{},
 		expirationMu:    &sync.RWMutex{},
 		client:          &mockSqsClient{},
+
+		visibilityBatchers:   make(map[int64]*messageBatcher),
+		visibilityBatchersMu: &sync.Mutex{},
 	}
+	provider.deleteBatcher = newMessageBatcher(provider.DeleteMessageBatch, batchWindowInMillis*time.Millisecond)
+	return provider
 }
 
 var mockAssumeRoleResult = mockAssumeRoleResult2
@@ -32,17 +41,18 @@ func TestChangeMessageVisibility(t *testing.T) {
 
 	provider := newQueueProviderTest()
 
-	var capturedInput *sqs.ChangeMessageVisibilityInput
-	provider.client.(*mockSqsClient).ChangeMessageVisibilityFunc = func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	var capturedInput *sqs.ChangeMessageVisibilityBatchInput
+	provider.client.(*mockSqsClient).ChangeMessageVisibilityBatchFunc = func(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
 		capturedInput = input
-		return nil, nil
+		return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
 	}
 
 	err := provider.ChangeMessageVisibility(&sqs.Message{ReceiptHandle: &mockReceiptHandle, MessageId: new(string)}, 0)
 
 	assert.Nil(t, err)
-	assert.Equal(t, mockReceiptHandle, *capturedInput.ReceiptHandle)
-	assert.Equal(t, int64(0), *capturedInput.VisibilityTimeout)
+	assert.Equal(t, 1, len(capturedInput.Entries))
+	assert.Equal(t, mockReceiptHandle, *capturedInput.Entries[0].ReceiptHandle)
+	assert.Equal(t, int64(0), *capturedInput.Entries[0].VisibilityTimeout)
 	assert.Equal(t, mockQueueUrl1, *capturedInput.QueueUrl)
 }
 
@@ -50,7 +60,7 @@ func TestChangeMessageVisibilityWithError(t *testing.T) {
 
 	provider := newQueueProviderTest()
 
-	provider.client.(*mockSqsClient).ChangeMessageVisibilityFunc = func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	provider.client.(*mockSqsClient).ChangeMessageVisibilityBatchFunc = func(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
 		return nil, errors.New("Test change message visibility error")
 	}
 
@@ -60,20 +70,96 @@ func TestChangeMessageVisibilityWithError(t *testing.T) {
 	assert.Equal(t, "Test change message visibility error", err.Error())
 }
 
+func TestChangeMessageVisibilityCoalescesChangesWithSameTimeout(t *testing.T) {
+
+	provider := newQueueProviderTest()
+
+	capturedTimeouts := make(chan int64, 10)
+	provider.client.(*mockSqsClient).ChangeMessageVisibilityBatchFunc = func(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+		for _, entry := range input.Entries {
+			assert.Equal(t, *input.Entries[0].VisibilityTimeout, *entry.VisibilityTimeout)
+		}
+		capturedTimeouts <- *input.Entries[0].VisibilityTimeout
+		return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+	}
+
+	messages := newMockMessages(6)
+
+	wg := &sync.WaitGroup{}
+	for i := range messages {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(t, provider.ChangeMessageVisibility(messages[i], int64(i%2)*30))
+		}(i)
+	}
+	wg.Wait()
+	close(capturedTimeouts)
+
+	timeouts := make([]int64, 0)
+	for timeout := range capturedTimeouts {
+		timeouts = append(timeouts, timeout)
+	}
+	assert.ElementsMatch(t, []int64{0, 30}, timeouts)
+}
+
+func TestChangeMessageVisibilityBatch(t *testing.T) {
+
+	provider := newQueueProviderTest()
+
+	capturedInputs := make([]*sqs.ChangeMessageVisibilityBatchInput, 0)
+	provider.client.(*mockSqsClient).ChangeMessageVisibilityBatchFunc = func(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+		capturedInputs = append(capturedInputs, input)
+		return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+	}
+
+	err := provider.ChangeMessageVisibilityBatch(newMockMessages(12), 0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(capturedInputs))
+	assert.Equal(t, 10, len(capturedInputs[0].Entries))
+	assert.Equal(t, 2, len(capturedInputs[1].Entries))
+	assert.Equal(t, mockReceiptHandle, *capturedInputs[0].Entries[0].ReceiptHandle)
+	assert.Equal(t, int64(0), *capturedInputs[0].Entries[0].VisibilityTimeout)
+	assert.Equal(t, mockQueueUrl1, *capturedInputs[0].QueueUrl)
+}
+
+func TestChangeMessageVisibilityBatchWithFailedEntries(t *testing.T) {
+
+	provider := newQueueProviderTest()
+
+	provider.client.(*mockSqsClient).ChangeMessageVisibilityBatchFunc = func(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+		return &sqs.ChangeMessageVisibilityBatchOutput{
+			Failed: []*sqs.BatchResultErrorEntry{
+				{Id: input.Entries[1].Id, Code: aws.String("ReceiptHandleIsInvalid"), Message: aws.String("invalid")},
+			},
+		}, nil
+	}
+
+	messages := newMockMessages(3)
+	err := provider.ChangeMessageVisibilityBatch(messages, 0)
+
+	batchErr, ok := err.(*BatchError)
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(batchErr.Failed))
+	assert.EqualError(t, batchErr.Failed[messages[1]], "ReceiptHandleIsInvalid: invalid")
+}
+
 func TestDeleteMessage(t *testing.T) {
 
 	provider := newQueueProviderTest()
 
-	var capturedInput *sqs.DeleteMessageInput
-	provider.client.(*mockSqsClient).DeleteMessageFunc = func(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	var capturedInput *sqs.DeleteMessageBatchInput
+	provider.client.(*mockSqsClient).DeleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
 		capturedInput = input
-		return nil, nil
+		return &sqs.DeleteMessageBatchOutput{}, nil
 	}
 
 	err := provider.DeleteMessage(&sqs.Message{ReceiptHandle: &mockReceiptHandle, MessageId: new(string)})
 
 	assert.Nil(t, err)
-	assert.Equal(t, mockReceiptHandle, *capturedInput.ReceiptHandle)
+	assert.Equal(t, 1, len(capturedInput.Entries))
+	assert.Equal(t, mockReceiptHandle, *capturedInput.Entries[0].ReceiptHandle)
 	assert.Equal(t, mockQueueUrl1, *capturedInput.QueueUrl)
 }
 
@@ -81,7 +167,7 @@ func TestDeleteMessageWithError(t *testing.T) {
 
 	provider := newQueueProviderTest()
 
-	provider.client.(*mockSqsClient).DeleteMessageFunc = func(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	provider.client.(*mockSqsClient).DeleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
 		return nil, errors.New("Test delete message error")
 	}
 
@@ -91,17 +177,91 @@ func TestDeleteMessageWithError(t *testing.T) {
 	assert.Equal(t, "Test delete message error", err.Error())
 }
 
+func TestDeleteMessageCoalescesConcurrentDeletions(t *testing.T) {
+
+	provider := newQueueProviderTest()
+
+	batchCount := int32(0)
+	provider.client.(*mockSqsClient).DeleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		atomic.AddInt32(&batchCount, 1)
+		failed := make([]*sqs.BatchResultErrorEntry, 0)
+		for _, entry := range input.Entries {
+			if *entry.ReceiptHandle == "failingReceiptHandle" {
+				failed = append(failed, &sqs.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("ReceiptHandleIsInvalid"), Message: aws.String("invalid")})
+			}
+		}
+		return &sqs.DeleteMessageBatchOutput{Failed: failed}, nil
+	}
+
+	messages := newMockMessages(10)
+	messages[3].ReceiptHandle = aws.String("failingReceiptHandle")
+
+	errs := make([]error, len(messages))
+	wg := &sync.WaitGroup{}
+	for i := range messages {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = provider.DeleteMessage(messages[i])
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), batchCount)
+	for i, err := range errs {
+		if i == 3 {
+			assert.EqualError(t, err, "ReceiptHandleIsInvalid: invalid")
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+}
+
+func TestDeleteMessageResolvesRedeliveredMessagesByTheirOwnEntries(t *testing.T) {
+
+	provider := newQueueProviderTest()
+
+	provider.client.(*mockSqsClient).DeleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		failed := make([]*sqs.BatchResultErrorEntry, 0)
+		for _, entry := range input.Entries {
+			if *entry.ReceiptHandle == "staleReceiptHandle" {
+				failed = append(failed, &sqs.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("ReceiptHandleIsInvalid"), Message: aws.String("invalid")})
+			}
+		}
+		return &sqs.DeleteMessageBatchOutput{Failed: failed}, nil
+	}
+
+	staleMessage := &sqs.Message{MessageId: aws.String("1"), ReceiptHandle: aws.String("staleReceiptHandle")}
+	redeliveredMessage := &sqs.Message{MessageId: aws.String("1"), ReceiptHandle: aws.String("redeliveredReceiptHandle")}
+
+	var staleErr, redeliveredErr error
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		staleErr = provider.DeleteMessage(staleMessage)
+	}()
+	go func() {
+		defer wg.Done()
+		redeliveredErr = provider.DeleteMessage(redeliveredMessage)
+	}()
+	wg.Wait()
+
+	assert.EqualError(t, staleErr, "ReceiptHandleIsInvalid: invalid")
+	assert.Nil(t, redeliveredErr)
+}
+
 func TestReceiveMessage(t *testing.T) {
 
 	provider := newQueueProviderTest()
 
 	var capturedInput *sqs.ReceiveMessageInput
-	provider.client.(*mockSqsClient).ReceiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	provider.client.(*mockSqsClient).ReceiveMessageFunc = func(ctx aws.Context, input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
 		capturedInput = input
 		return &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{{}, {}}}, nil
 	}
 
-	messages, err := provider.ReceiveMessage(10, 30)
+	messages, err := provider.ReceiveMessage(context.Background(), 10, 30, 20)
 
 	assert.Nil(t, err)
 	assert.Equal(t, 2, len(messages))
@@ -109,25 +269,59 @@ func TestReceiveMessage(t *testing.T) {
 	assert.Equal(t, mockQueueUrl1, *capturedInput.QueueUrl)
 	assert.Equal(t, int64(20), *capturedInput.WaitTimeSeconds)
 	assert.Equal(t, int64(10), *capturedInput.MaxNumberOfMessages)
-	assert.Equal(t, 2, len(capturedInput.MessageAttributeNames))
+	assert.Equal(t, 3, len(capturedInput.MessageAttributeNames))
 	assert.Equal(t, "ownerId", *capturedInput.MessageAttributeNames[0])
 	assert.Equal(t, "channelId", *capturedInput.MessageAttributeNames[1])
+	assert.Equal(t, "signature", *capturedInput.MessageAttributeNames[2])
+	assert.Equal(t, []*string{aws.String("SentTimestamp"), aws.String("ApproximateReceiveCount")}, capturedInput.AttributeNames)
 }
 
 func TestReceiveMessageWithError(t *testing.T) {
 
 	provider := newQueueProviderTest()
 
-	provider.client.(*mockSqsClient).ReceiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	provider.client.(*mockSqsClient).ReceiveMessageFunc = func(ctx aws.Context, input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
 		return nil, errors.New("Test receive message visibility error")
 	}
 
-	_, err := provider.ReceiveMessage(10, 30)
+	_, err := provider.ReceiveMessage(context.Background(), 10, 30, 20)
 
 	assert.NotNil(t, err)
 	assert.Equal(t, "Test receive message visibility error", err.Error())
 }
 
+func TestReceiveMessageWithCustomWaitTime(t *testing.T) {
+
+	provider := newQueueProviderTest()
+
+	var capturedInput *sqs.ReceiveMessageInput
+	provider.client.(*mockSqsClient).ReceiveMessageFunc = func(ctx aws.Context, input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		capturedInput = input
+		return &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{}}, nil
+	}
+
+	_, err := provider.ReceiveMessage(context.Background(), 10, 30, 5)
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), *capturedInput.WaitTimeSeconds)
+}
+
+func TestReceiveMessageWithCanceledContext(t *testing.T) {
+
+	provider := newQueueProviderTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	provider.client.(*mockSqsClient).ReceiveMessageFunc = func(ctx aws.Context, input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	cancel()
+	_, err := provider.ReceiveMessage(ctx, 10, 30, 20)
+
+	assert.Equal(t, context.Canceled, err)
+}
+
 func TestRefreshClient(t *testing.T) {
 
 	provider := newQueueProviderTest()
@@ -147,40 +341,42 @@ func TestRefreshClient(t *testing.T) {
 
 // Mock SqsClient
 type mockSqsClient struct {
-	DeleteMessageFunc           func(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error)
-	ChangeMessageVisibilityFunc func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error)
-	ReceiveMessageFunc          func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessageBatchFunc           func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error)
+	ChangeMessageVisibilityBatchFunc func(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error)
+	ReceiveMessageFunc               func(ctx aws.Context, input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error)
 }
 
-func (c *mockSqsClient) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
-	if c.DeleteMessageFunc != nil {
-		return c.DeleteMessageFunc(input)
+func (c *mockSqsClient) DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	if c.DeleteMessageBatchFunc != nil {
+		return c.DeleteMessageBatchFunc(input)
 	}
-	return nil, nil
+	return &sqs.DeleteMessageBatchOutput{}, nil
 }
 
-func (c *mockSqsClient) ChangeMessageVisibility(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
-	if c.ChangeMessageVisibilityFunc != nil {
-		return c.ChangeMessageVisibilityFunc(input)
+func (c *mockSqsClient) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	if c.ChangeMessageVisibilityBatchFunc != nil {
+		return c.ChangeMessageVisibilityBatchFunc(input)
 	}
-	return nil, nil
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
 }
 
-func (c *mockSqsClient) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+func (c *mockSqsClient) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...aws_request.Option) (*sqs.ReceiveMessageOutput, error) {
 	if c.ReceiveMessageFunc != nil {
-		return c.ReceiveMessageFunc(input)
+		return c.ReceiveMessageFunc(ctx, input)
 	}
 	return &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{}}, nil // empty slice of message
 }
 
 // Mock SQSProvider
 type MockSQSProvider struct {
-	ChangeMessageVisibilityFunc func(message *sqs.Message, visibilityTimeout int64) error
-	DeleteMessageFunc           func(message *sqs.Message) error
-	ReceiveMessageFunc          func(numOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error)
-	QueuePropertiesFunc         func() Properties
-	RefreshClientFunc           func(assumeRoleResult AssumeRoleResult) error
-	IsTokenExpiredFunc          func() bool
+	ChangeMessageVisibilityFunc      func(message *sqs.Message, visibilityTimeout int64) error
+	ChangeMessageVisibilityBatchFunc func(messages []*sqs.Message, visibilityTimeout int64) error
+	DeleteMessageFunc                func(message *sqs.Message) error
+	DeleteMessageBatchFunc           func(messages []*sqs.Message) error
+	ReceiveMessageFunc               func(ctx context.Context, numOfMessage int64, visibilityTimeout int64, waitTimeSeconds int64) ([]*sqs.Message, error)
+	QueuePropertiesFunc              func() Properties
+	RefreshClientFunc                func(assumeRoleResult AssumeRoleResult) error
+	IsTokenExpiredFunc               func() bool
 }
 
 func NewMockQueueProvider() SQSProvider {
@@ -201,6 +397,13 @@ func (mqp *MockSQSProvider) ChangeMessageVisibility(message *sqs.Message, visibi
 	return nil
 }
 
+func (mqp *MockSQSProvider) ChangeMessageVisibilityBatch(messages []*sqs.Message, visibilityTimeout int64) error {
+	if mqp.ChangeMessageVisibilityBatchFunc != nil {
+		return mqp.ChangeMessageVisibilityBatchFunc(messages, visibilityTimeout)
+	}
+	return nil
+}
+
 func (mqp *MockSQSProvider) DeleteMessage(message *sqs.Message) error {
 	if mqp.DeleteMessageFunc != nil {
 		return mqp.DeleteMessageFunc(message)
@@ -208,9 +411,16 @@ func (mqp *MockSQSProvider) DeleteMessage(message *sqs.Message) error {
 	return nil
 }
 
-func (mqp *MockSQSProvider) ReceiveMessage(numOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error) {
+func (mqp *MockSQSProvider) DeleteMessageBatch(messages []*sqs.Message) error {
+	if mqp.DeleteMessageBatchFunc != nil {
+		return mqp.DeleteMessageBatchFunc(messages)
+	}
+	return nil
+}
+
+func (mqp *MockSQSProvider) ReceiveMessage(ctx context.Context, numOfMessage int64, visibilityTimeout int64, waitTimeSeconds int64) ([]*sqs.Message, error) {
 	if mqp.ReceiveMessageFunc != nil {
-		return mqp.ReceiveMessageFunc(numOfMessage, visibilityTimeout)
+		return mqp.ReceiveMessageFunc(ctx, numOfMessage, visibilityTimeout, waitTimeSeconds)
 	}
 	return []*sqs.Message{}, nil
 }
@@ -229,7 +439,7 @@ func (mqp *MockSQSProvider) RefreshClient(assumeRoleResult AssumeRoleResult) err
 	return nil
 }
 
-var mockSuccessReceiveFunc = func(numOfMessage int64, visibilityTimeout int64) ([]*sqs.Message, error) {
+var mockSuccessReceiveFunc = func(ctx context.Context, numOfMessage int64, visibilityTimeout int64, waitTimeSeconds int64) ([]*sqs.Message, error) {
 	body := "body"
 	messages := make([]*sqs.Message, 0)
 	for i := int64(0); i < numOfMessage; i++ {
@@ -240,3 +450,11 @@ var mockSuccessReceiveFunc = func(numOfMessage int64, visibilityTimeout int64) (
 
 	return messages, nil
 }
+
+func newMockMessages(count int) []*sqs.Message {
+	messages := make([]*sqs.Message, 0, count)
+	for i := 0; i < count; i++ {
+		messages = append(messages, &sqs.Message{MessageId: aws.String(strconv.Itoa(i)), ReceiptHandle: &mockReceiptHandle})
+	}
+	return messages
+}
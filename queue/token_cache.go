@@ -0,0 +1,153 @@
+package queue
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cached credentials which expire sooner than this are not used, a fresh token is waited instead
+const tokenCacheExpiryMargin = 5 * time.Minute
+
+type tokenCacheFile struct {
+	ApiKeyHash string `json:"apiKeyHash"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// tokenCache keeps the last token in a file encrypted with a key derived from the api key,
+// so that the pollers can start before the credentials endpoint responds after a restart.
+type tokenCache struct {
+	path    string
+	apiKey  string
+	nowFunc func() time.Time
+}
+
+func newTokenCache(path, apiKey string) *tokenCache {
+	return &tokenCache{
+		path:    path,
+		apiKey:  apiKey,
+		nowFunc: time.Now,
+	}
+}
+
+func (c *tokenCache) hash(purpose string) []byte {
+	sum := sha256.Sum256([]byte("jec-token-cache:" + purpose + ":" + c.apiKey))
+	return sum[:]
+}
+
+func (c *tokenCache) newCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(c.hash("key"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Load returns the cached token if all of its credentials are still valid, otherwise nil.
+func (c *tokenCache) Load() (*token, error) {
+	content, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cacheFile := &tokenCacheFile{}
+	err = json.Unmarshal(content, cacheFile)
+	if err != nil {
+		return nil, errors.Errorf("Token cache[%s] is corrupted: %s", c.path, err)
+	}
+
+	if cacheFile.ApiKeyHash != hex.EncodeToString(c.hash("id")) {
+		logrus.Infof("Token cache[%s] is invalidated since the api key has changed.", c.path)
+		c.Remove()
+		return nil, nil
+	}
+
+	gcm, err := c.newCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcm.Open(nil, cacheFile.Nonce, cacheFile.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.Errorf("Token cache[%s] could not be decrypted: %s", c.path, err)
+	}
+
+	cachedToken := &token{}
+	err = json.Unmarshal(plaintext, cachedToken)
+	if err != nil {
+		return nil, errors.Errorf("Token cache[%s] is corrupted: %s", c.path, err)
+	}
+
+	if len(cachedToken.QueuePropertiesList) == 0 {
+		return nil, nil
+	}
+
+	minExpireTimeMillis := c.nowFunc().Add(tokenCacheExpiryMargin).UnixNano() / int64(time.Millisecond)
+	for _, queueProperties := range cachedToken.QueuePropertiesList {
+		if queueProperties.ExpireTimeMillis() <= minExpireTimeMillis {
+			logrus.Debugf("Token cache[%s] is not used since credentials of queue[%s] are about to expire.", c.path, queueProperties.Url())
+			return nil, nil
+		}
+	}
+	return cachedToken, nil
+}
+
+func (c *tokenCache) Save(cachedToken *token) error {
+	plaintext, err := json.Marshal(cachedToken)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := c.newCipher()
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(&tokenCacheFile{
+		ApiKeyHash: hex.EncodeToString(c.hash("id")),
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, nil),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(c.path), 0700)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := c.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, c.path)
+}
+
+func (c *tokenCache) Remove() {
+	err := os.Remove(c.path)
+	if err != nil && !os.IsNotExist(err) {
+		logrus.Warnf("Token cache[%s] could not be removed: %s", c.path, err)
+	}
+}
@@ -0,0 +1,82 @@
+package queue
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTokenCacheTest(t *testing.T, apiKey string) (*tokenCache, func()) {
+	dir, err := ioutil.TempDir("", "jec-token-cache")
+	assert.Nil(t, err)
+
+	cache := newTokenCache(filepath.Join(dir, "token-cache.json"), apiKey)
+	cache.nowFunc = func() time.Time {
+		return time.Unix(0, 0)
+	}
+	return cache, func() { os.RemoveAll(dir) }
+}
+
+func TestSaveAndLoadTokenCache(t *testing.T) {
+	cache, cleanup := newTokenCacheTest(t, mockApiKey)
+	defer cleanup()
+
+	err := cache.Save(&mockToken)
+	assert.Nil(t, err)
+
+	content, _ := ioutil.ReadFile(cache.path)
+	assert.NotContains(t, string(content), mockAssumeRoleResult1.Credentials.SecretAccessKey)
+
+	info, _ := os.Stat(cache.path)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	cachedToken, err := cache.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, &mockToken, cachedToken)
+}
+
+func TestLoadTokenCacheWithoutFile(t *testing.T) {
+	cache, cleanup := newTokenCacheTest(t, mockApiKey)
+	defer cleanup()
+
+	cachedToken, err := cache.Load()
+	assert.Nil(t, err)
+	assert.Nil(t, cachedToken)
+}
+
+func TestTokenCacheIsInvalidatedOnApiKeyChange(t *testing.T) {
+	cache, cleanup := newTokenCacheTest(t, mockApiKey)
+	defer cleanup()
+
+	err := cache.Save(&mockToken)
+	assert.Nil(t, err)
+
+	cache.apiKey = "anotherApiKey"
+	cachedToken, err := cache.Load()
+
+	assert.Nil(t, err)
+	assert.Nil(t, cachedToken)
+
+	_, err = os.Stat(cache.path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestTokenCacheIsNotUsedWhenCredentialsAreAboutToExpire(t *testing.T) {
+	cache, cleanup := newTokenCacheTest(t, mockApiKey)
+	defer cleanup()
+
+	err := cache.Save(&mockToken)
+	assert.Nil(t, err)
+
+	expireTime := time.Unix(0, mockAssumeRoleResult1.Credentials.ExpireTimeMillis*int64(time.Millisecond))
+	cache.nowFunc = func() time.Time {
+		return expireTime.Add(-tokenCacheExpiryMargin / 2)
+	}
+
+	cachedToken, err := cache.Load()
+	assert.Nil(t, err)
+	assert.Nil(t, cachedToken)
+}
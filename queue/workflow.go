@@ -0,0 +1,217 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/atlassian/jec/conf"
+	"github.com/atlassian/jec/runbook"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// The outcome of the previous step and the failed step are passed to the next steps in these env variables.
+const (
+	workflowPreviousStepEnv            = "JEC_WORKFLOW_PREVIOUS_STEP"
+	workflowPreviousOutputEnv          = "JEC_WORKFLOW_PREVIOUS_OUTPUT"
+	workflowPreviousCallbackContextEnv = "JEC_WORKFLOW_PREVIOUS_CALLBACK_CONTEXT"
+	workflowFailedStepEnv              = "JEC_WORKFLOW_FAILED_STEP"
+	workflowFailureMessageEnv          = "JEC_WORKFLOW_FAILURE_MESSAGE"
+)
+
+// maxStepOutputSize limits the stdout of a step which is passed to the next step, env variables cannot be too long.
+const maxStepOutputSize = 32 * 1024
+
+type stepOutcome struct {
+	name            string
+	output          string
+	callbackContext string
+	failureMessage  string
+}
+
+type workflowExecution struct {
+	handler  *messageHandler
+	workflow *conf.MappedAction
+	message  *sqs.Message
+	extraEnv []string
+	results  []runbook.StepResult
+	previous *stepOutcome
+	failed   *stepOutcome
+}
+
+// executeWorkflow runs the steps of the workflow in order and adds the outcome of each step to the result.
+// The workflow fails when one of its steps fails unless the step is allowed to fail and its failure branch succeeds.
+func (mh *messageHandler) executeWorkflow(workflow *conf.MappedAction, message *sqs.Message, extraEnv []string, result *runbook.ActionResultPayload) {
+	execution := &workflowExecution{
+		handler:  mh,
+		workflow: workflow,
+		message:  message,
+		extraEnv: extraEnv,
+		results:  make([]runbook.StepResult, 0, len(workflow.Steps)),
+	}
+
+	failed := execution.runSteps(workflow.Steps)
+
+	result.Steps = execution.results
+	if execution.previous != nil {
+		result.CallbackContext = execution.previous.callbackContext
+	}
+	result.IsSuccessful = failed == nil
+	if failed != nil {
+		result.FailureMessage = fmt.Sprintf("Step[%s] of the workflow failed: %s", failed.name, failed.failureMessage)
+	}
+}
+
+// runSteps returns the outcome of the step which aborted the steps, or nil if all of them have been completed.
+func (we *workflowExecution) runSteps(steps []conf.WorkflowStep) *stepOutcome {
+	for index := range steps {
+		step := &steps[index]
+
+		outcome := we.runStep(step)
+		we.previous = outcome
+		if outcome.failureMessage == "" {
+			continue
+		}
+
+		logrus.Debugf("Step[%s] of the workflow of message[%s] failed: %s", step.Name, *we.message.MessageId, outcome.failureMessage)
+
+		var branchFailed *stepOutcome
+		if len(step.OnFailure) != 0 {
+			previousFailed := we.failed
+			we.failed = outcome
+			branchFailed = we.runSteps(step.OnFailure)
+			we.failed = previousFailed
+		}
+		if !step.ContinueOnFailure {
+			return outcome
+		}
+		if branchFailed != nil {
+			return branchFailed
+		}
+	}
+	return nil
+}
+
+func (we *workflowExecution) runStep(step *conf.WorkflowStep) *stepOutcome {
+	start := time.Now()
+
+	output, callbackContext, attempts, err := we.executeStep(step)
+
+	outcome := &stepOutcome{
+		name:            step.Name,
+		output:          truncate(output, maxStepOutputSize),
+		callbackContext: callbackContext,
+	}
+	if err != nil {
+		outcome.failureMessage = executionFailureMessage(err)
+	}
+
+	we.results = append(we.results, runbook.StepResult{
+		Name:             step.Name,
+		IsSuccessful:     err == nil,
+		FailureMessage:   outcome.failureMessage,
+		CallbackContext:  callbackContext,
+		DurationInMillis: int64(time.Since(start) / time.Millisecond),
+		Attempts:         len(attempts),
+	})
+	return outcome
+}
+
+func (we *workflowExecution) executeStep(step *conf.WorkflowStep) (string, string, []runbook.AttemptResult, error) {
+	mh := we.handler
+	env := append(we.stepEnv(), we.extraEnv...)
+
+	if step.Action != "" {
+		mappedAction, ok := mh.actionSpecs.ActionMappings[step.Action]
+		if !ok {
+			return "", "", nil, errors.Errorf("Action[%s] is not found in the action mappings.", step.Action)
+		}
+		// the required labels of the action are validated to be required by the workflow too
+		if err := checkMessageAge(&mappedAction, we.message, time.Now()); err != nil {
+			return "", "", nil, err
+		}
+		release, err := mh.acquireAction(context.Background(), step.Action)
+		if err != nil {
+			return "", "", nil, err
+		}
+		defer release()
+
+		renderedAction, err := mappedAction.Render([]byte(*we.message.Body))
+		if err != nil {
+			return "", "", nil, err
+		}
+		return mh.executeWithRetry(context.Background(), *we.message.MessageId, renderedAction, we.message, env, true)
+	}
+
+	command, err := step.RenderCommand([]byte(*we.message.Body))
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	stdoutBuff := &bytes.Buffer{}
+	var stdout io.Writer = &limitedWriter{writer: stdoutBuff, remaining: maxStepOutputSize}
+	if actionLogger := mh.actionLoggers[we.workflow.Stdout]; actionLogger != nil {
+		stdout = io.MultiWriter(stdout, actionLogger)
+	}
+	stderr := mh.actionLoggers[we.workflow.Stderr]
+
+	// the command gets the global flags, args and env and the payload the same way as the mapped actions
+	args := append(mh.actionSpecs.GlobalFlags.Args(), []string{"-payload", *we.message.Body}...)
+	args = append(args, mh.actionSpecs.GlobalArgs...)
+	args = append(args, command.Args...)
+	env = append(append(append([]string{}, mh.actionSpecs.GlobalEnv...), command.Env...), env...)
+
+	callbackContext, err := runbook.ExecuteFunc(context.Background(), *we.message.MessageId, step.Command, args, env, stdout, stderr)
+	return stdoutBuff.String(), callbackContext, nil, err
+}
+
+func (we *workflowExecution) stepEnv() []string {
+	env := make([]string, 0, 5)
+	if we.previous != nil {
+		env = append(env,
+			workflowPreviousStepEnv+"="+we.previous.name,
+			workflowPreviousOutputEnv+"="+we.previous.output,
+			workflowPreviousCallbackContextEnv+"="+we.previous.callbackContext,
+		)
+	}
+	if we.failed != nil {
+		env = append(env,
+			workflowFailedStepEnv+"="+we.failed.name,
+			workflowFailureMessageEnv+"="+we.failed.failureMessage,
+		)
+	}
+	return env
+}
+
+// truncate cuts the text on a rune boundary so that the truncated text stays valid utf-8.
+func truncate(text string, size int) string {
+	if len(text) <= size {
+		return text
+	}
+	for size > 0 && !utf8.RuneStart(text[size]) {
+		size--
+	}
+	return text[:size]
+}
+
+// limitedWriter drops the bytes written after the limit is reached instead of failing the command.
+type limitedWriter struct {
+	writer    *bytes.Buffer
+	remaining int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.remaining > 0 {
+		n := len(p)
+		if n > w.remaining {
+			n = w.remaining
+		}
+		w.writer.Write(p[:n])
+		w.remaining -= n
+	}
+	return len(p), nil
+}
@@ -0,0 +1,214 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"github.com/atlassian/jec/conf"
+	"github.com/atlassian/jec/runbook"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var mockWorkflowActionSpecs = conf.ActionSpecifications{
+	ActionMappings: conf.ActionMappings{
+		"Diagnose": conf.MappedAction{Type: CustomActionType, SourceType: "local", Filepath: "/path/to/diagnose.sh"},
+		"Restart":  conf.MappedAction{Type: CustomActionType, SourceType: "local", Filepath: "/path/to/restart.sh"},
+		"Recover": conf.MappedAction{
+			Type: conf.WorkflowActionType,
+			Steps: []conf.WorkflowStep{
+				{Name: "diagnose", Action: "Diagnose", ContinueOnFailure: true},
+				{Name: "restart", Action: "Restart", OnFailure: []conf.WorkflowStep{
+					{Name: "rollback", Command: "/usr/bin/rollback", Args: []string{"{{ .entity.id }}"}},
+				}},
+				{Name: "verify", Command: "/usr/bin/verify", Env: []string{"ENTITY={{ .entity.id }}"}},
+			},
+		},
+	},
+}
+
+type stepExecution struct {
+	path string
+	args []string
+	env  []string
+}
+
+func mockWorkflowExecute(failedPaths ...string) (*[]stepExecution, func(context.Context, string, string, []string, []string, io.Writer, io.Writer) (string, error)) {
+	executions := &[]stepExecution{}
+	return executions, func(ctx context.Context, executionId string, path string, args, env []string, stdout, stderr io.Writer) (string, error) {
+		*executions = append(*executions, stepExecution{path, args, env})
+		stdout.Write([]byte("output of " + path))
+		for _, failedPath := range failedPaths {
+			if path == failedPath {
+				return "", errors.New("exit status 1")
+			}
+		}
+		return "context of " + path, nil
+	}
+}
+
+const mockWorkflowBody = `{"action":"Recover", "actionType":"custom", "requestId": "RequestId", "entity":{"id":"1234"}}`
+
+func handleWorkflowMessage(t *testing.T) *runbook.ActionResultPayload {
+	return handleWorkflowMessageWithSpecs(t, mockWorkflowActionSpecs)
+}
+
+func handleWorkflowMessageWithSpecs(t *testing.T, actionSpecs conf.ActionSpecifications) *runbook.ActionResultPayload {
+	body := mockWorkflowBody
+	id := "MessageId"
+
+	result, err := NewMessageHandler(nil, actionSpecs, mockActionLoggers).Handle(sqs.Message{Body: &body, MessageId: &id})
+
+	assert.Nil(t, err)
+	return result
+}
+
+func TestWorkflowSuccessfully(t *testing.T) {
+	defer func() { runbook.ExecuteFunc = runbook.Execute }()
+	var executions *[]stepExecution
+	executions, runbook.ExecuteFunc = mockWorkflowExecute()
+
+	result := handleWorkflowMessage(t)
+
+	assert.True(t, result.IsSuccessful)
+	assert.Equal(t, "context of /usr/bin/verify", result.CallbackContext)
+	assert.Equal(t, []string{"diagnose", "restart", "verify"}, stepNames(result.Steps))
+	assert.Len(t, *executions, 3)
+
+	verify := (*executions)[2]
+	assert.Equal(t, "/usr/bin/verify", verify.path)
+	assert.Equal(t, []string{"-payload", mockWorkflowBody}, verify.args)
+	assert.Equal(t, []string{
+		"ENTITY=1234",
+		"JEC_WORKFLOW_PREVIOUS_STEP=restart",
+		"JEC_WORKFLOW_PREVIOUS_OUTPUT=output of /path/to/restart.sh",
+		"JEC_WORKFLOW_PREVIOUS_CALLBACK_CONTEXT=context of /path/to/restart.sh",
+	}, verify.env)
+}
+
+func TestWorkflowRunsFailureBranch(t *testing.T) {
+	defer func() { runbook.ExecuteFunc = runbook.Execute }()
+	var executions *[]stepExecution
+	executions, runbook.ExecuteFunc = mockWorkflowExecute("/path/to/diagnose.sh", "/path/to/restart.sh")
+
+	result := handleWorkflowMessage(t)
+
+	assert.False(t, result.IsSuccessful)
+	assert.Equal(t, "Step[restart] of the workflow failed: exit status 1", result.FailureMessage)
+	assert.Equal(t, []string{"diagnose", "restart", "rollback"}, stepNames(result.Steps))
+	assert.False(t, result.Steps[0].IsSuccessful)
+	assert.False(t, result.Steps[1].IsSuccessful)
+	assert.True(t, result.Steps[2].IsSuccessful)
+
+	rollback := (*executions)[2]
+	assert.Equal(t, []string{"-payload", mockWorkflowBody, "1234"}, rollback.args)
+	assert.Contains(t, rollback.env, "JEC_WORKFLOW_FAILED_STEP=restart")
+	assert.Contains(t, rollback.env, "JEC_WORKFLOW_FAILURE_MESSAGE=exit status 1")
+}
+
+func TestWorkflowCommandGetsGlobalArgsAndEnv(t *testing.T) {
+	defer func() { runbook.ExecuteFunc = runbook.Execute }()
+	var executions *[]stepExecution
+	executions, runbook.ExecuteFunc = mockWorkflowExecute("/path/to/restart.sh")
+
+	actionSpecs := mockWorkflowActionSpecs
+	actionSpecs.GlobalFlags = conf.Flags{"apiKey": "key"}
+	actionSpecs.GlobalArgs = []string{"-global"}
+	actionSpecs.GlobalEnv = []string{"GLOBAL=env"}
+
+	result := handleWorkflowMessageWithSpecs(t, actionSpecs)
+
+	assert.False(t, result.IsSuccessful)
+	rollback := (*executions)[2]
+	assert.Equal(t, "/usr/bin/rollback", rollback.path)
+	assert.Equal(t, []string{"-apiKey", "key", "-payload", mockWorkflowBody, "-global", "1234"}, rollback.args)
+	assert.Equal(t, "GLOBAL=env", rollback.env[0])
+}
+
+func TestWorkflowStepChecksMessageAgeOfItsAction(t *testing.T) {
+	defer func() { runbook.ExecuteFunc = runbook.Execute }()
+	var executions *[]stepExecution
+	executions, runbook.ExecuteFunc = mockWorkflowExecute()
+
+	actionSpecs := conf.ActionSpecifications{ActionMappings: conf.ActionMappings{}}
+	for name, mappedAction := range mockWorkflowActionSpecs.ActionMappings {
+		actionSpecs.ActionMappings[name] = mappedAction
+	}
+	restart := actionSpecs.ActionMappings["Restart"]
+	restart.MaxMessageAgeInSeconds = 60
+	actionSpecs.ActionMappings["Restart"] = restart
+
+	body := `{"action":"Recover", "actionType":"custom", "requestId": "RequestId", "entity":{"id":"1234"}}`
+	id := "MessageId"
+	sentAt := strconv.FormatInt(time.Now().Add(-2*time.Minute).UnixNano()/int64(time.Millisecond), 10)
+	message := sqs.Message{Body: &body, MessageId: &id, Attributes: map[string]*string{sentTimestamp: &sentAt}}
+
+	result, err := NewMessageHandler(nil, actionSpecs, mockActionLoggers).Handle(message)
+
+	assert.Nil(t, err)
+	assert.False(t, result.IsSuccessful)
+	assert.Contains(t, result.Steps[1].FailureMessage, "Message[MessageId] is expired")
+	assert.Equal(t, []string{"/path/to/diagnose.sh", "/usr/bin/rollback"}, []string{(*executions)[0].path, (*executions)[1].path})
+}
+
+func TestWorkflowStepFailsWhenProcessorStopsWhileWaitingForActionLimiter(t *testing.T) {
+	defer func() { runbook.ExecuteFunc = runbook.Execute }()
+	_, runbook.ExecuteFunc = mockWorkflowExecute()
+
+	limiter := NewActionLimiter(defaultIntegrationLabel, conf.ActionSpecifications{ActionMappings: conf.ActionMappings{
+		"Restart": conf.MappedAction{MaxConcurrency: 1},
+	}})
+	release, _, ok := limiter.AcquireAction("Restart")
+	assert.True(t, ok)
+	defer release()
+
+	quit := make(chan struct{})
+	close(quit)
+
+	body := `{"action":"Recover", "actionType":"custom", "requestId": "RequestId", "entity":{"id":"1234"}}`
+	id := "MessageId"
+	handler := &messageHandler{
+		actionSpecs:   mockWorkflowActionSpecs,
+		actionLoggers: mockActionLoggers,
+		actionLimiter: limiter,
+		quit:          quit,
+	}
+	result, err := handler.Handle(sqs.Message{Body: &body, MessageId: &id})
+
+	assert.Nil(t, err)
+	assert.False(t, result.IsSuccessful)
+	assert.Equal(t, "Action[Restart] could not run within its limits before the processor stopped.", result.Steps[1].FailureMessage)
+}
+
+func TestTruncateOnRuneBoundary(t *testing.T) {
+	assert.Equal(t, "abc", truncate("abc", 5))
+	assert.Equal(t, "ab", truncate("abcd", 2))
+	assert.Equal(t, "a", truncate("aüb", 2))
+	assert.Equal(t, "aü", truncate("aüb", 3))
+}
+
+func TestLimitedWriter(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writer := &limitedWriter{writer: buffer, remaining: 5}
+
+	n, err := writer.Write([]byte("abc"))
+	assert.Equal(t, 3, n)
+	assert.Nil(t, err)
+
+	n, err = writer.Write([]byte("defgh"))
+	assert.Equal(t, 5, n)
+	assert.Nil(t, err)
+	assert.Equal(t, "abcde", buffer.String())
+}
+
+func stepNames(steps []runbook.StepResult) []string {
+	names := make([]string, 0, len(steps))
+	for _, step := range steps {
+		names = append(names, step.Name)
+	}
+	return names
+}
@@ -0,0 +1,162 @@
+package retryer
+
+import (
+	"fmt"
+	"github.com/atlassian/jec/conf"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	failureThreshold      = 5
+	openDurationInSeconds = 30
+	halfOpenMaxCalls      = 1
+)
+
+type CircuitState int32
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type CircuitOpenError struct {
+	Name string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("Circuit breaker[%s] is open, request is not sent.", e.Name)
+}
+
+func IsCircuitOpen(err error) bool {
+	_, ok := err.(*CircuitOpenError)
+	return ok
+}
+
+// CircuitBreaker stops the requests after FailureThreshold consecutive failures. Once it has been open for
+// OpenDurationInSeconds, it lets HalfOpenMaxCalls trial requests through and closes again if one of them succeeds.
+type CircuitBreaker struct {
+	name                string
+	conf                conf.CircuitBreakerConf
+	state               CircuitState
+	consecutiveFailures int32
+	trialCalls          int32
+	openedAt            time.Time
+	nowFunc             func() time.Time
+	mu                  *sync.Mutex
+}
+
+func NewCircuitBreaker(name string, breakerConf conf.CircuitBreakerConf) *CircuitBreaker {
+
+	if breakerConf.FailureThreshold <= 0 {
+		logrus.Infof("Failure threshold of the circuit breaker should be greater than zero, default value[%d] is set.", failureThreshold)
+		breakerConf.FailureThreshold = failureThreshold
+	}
+
+	if breakerConf.OpenDurationInSeconds <= 0 {
+		logrus.Infof("Open duration of the circuit breaker should be greater than zero, default value[%d s.] is set.", openDurationInSeconds)
+		breakerConf.OpenDurationInSeconds = openDurationInSeconds
+	}
+
+	if breakerConf.HalfOpenMaxCalls <= 0 {
+		logrus.Infof("Half open max calls of the circuit breaker should be greater than zero, default value[%d] is set.", halfOpenMaxCalls)
+		breakerConf.HalfOpenMaxCalls = halfOpenMaxCalls
+	}
+
+	cb := &CircuitBreaker{
+		name:    name,
+		conf:    breakerConf,
+		state:   CircuitClosed,
+		nowFunc: time.Now,
+		mu:      &sync.Mutex{},
+	}
+	circuitBreakerState.WithLabelValues(name).Set(float64(CircuitClosed))
+	return cb
+}
+
+func (cb *CircuitBreaker) Name() string {
+	return cb.name
+}
+
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// Allow returns a CircuitOpenError if the request should not be sent, otherwise the outcome of the request
+// should be reported with Success or Failure.
+func (cb *CircuitBreaker) Allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitOpen {
+		openDuration := time.Duration(cb.conf.OpenDurationInSeconds) * time.Second
+		if cb.nowFunc().Sub(cb.openedAt) < openDuration {
+			circuitBreakerShortCircuits.WithLabelValues(cb.name).Inc()
+			return &CircuitOpenError{Name: cb.name}
+		}
+		cb.setState(CircuitHalfOpen)
+		cb.trialCalls = 0
+	}
+
+	if cb.state == CircuitHalfOpen {
+		if cb.trialCalls >= cb.conf.HalfOpenMaxCalls {
+			circuitBreakerShortCircuits.WithLabelValues(cb.name).Inc()
+			return &CircuitOpenError{Name: cb.name}
+		}
+		cb.trialCalls++
+	}
+	return nil
+}
+
+func (cb *CircuitBreaker) Success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.consecutiveFailures = 0
+	if cb.state != CircuitClosed {
+		cb.setState(CircuitClosed)
+	}
+}
+
+func (cb *CircuitBreaker) Failure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.consecutiveFailures++
+	switch cb.state {
+	case CircuitHalfOpen:
+		cb.open()
+	case CircuitClosed:
+		if cb.consecutiveFailures >= cb.conf.FailureThreshold {
+			cb.open()
+		}
+	}
+}
+
+func (cb *CircuitBreaker) open() {
+	cb.openedAt = cb.nowFunc()
+	cb.setState(CircuitOpen)
+}
+
+func (cb *CircuitBreaker) setState(state CircuitState) {
+	logrus.Warnf("Circuit breaker[%s] is %s, it was %s.", cb.name, state, cb.state)
+	cb.state = state
+	circuitBreakerState.WithLabelValues(cb.name).Set(float64(state))
+	circuitBreakerTransitions.WithLabelValues(cb.name, state.String()).Inc()
+}
@@ -0,0 +1,90 @@
+package retryer
+
+import (
+	"context"
+	"github.com/atlassian/jec/conf"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newCircuitBreakerTest(now *time.Time) *CircuitBreaker {
+	cb := NewCircuitBreaker("test", conf.CircuitBreakerConf{
+		FailureThreshold:      2,
+		OpenDurationInSeconds: 10,
+		HalfOpenMaxCalls:      1,
+	})
+	cb.nowFunc = func() time.Time {
+		return *now
+	}
+	return cb
+}
+
+func TestCircuitBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreakerTest(&now)
+
+	cb.Failure()
+	cb.Success()
+	cb.Failure()
+	assert.Equal(t, CircuitClosed, cb.State())
+	assert.Nil(t, cb.Allow())
+
+	cb.Failure()
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	err := cb.Allow()
+	assert.True(t, IsCircuitOpen(err))
+	assert.EqualError(t, err, "Circuit breaker[test] is open, request is not sent.")
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreakerTest(&now)
+
+	cb.Failure()
+	cb.Failure()
+
+	now = now.Add(10 * time.Second)
+	assert.Nil(t, cb.Allow())
+	assert.Equal(t, CircuitHalfOpen, cb.State())
+	assert.True(t, IsCircuitOpen(cb.Allow())) // only one trial call is allowed
+
+	cb.Failure()
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	now = now.Add(10 * time.Second)
+	assert.Nil(t, cb.Allow())
+	cb.Success()
+	assert.Equal(t, CircuitClosed, cb.State())
+	assert.Nil(t, cb.Allow())
+}
+
+func TestDoWithCircuitBreaker(t *testing.T) {
+	defer func() { sleep = sleepWithContext }()
+	sleep = func(ctx context.Context, d time.Duration) error { return nil }
+
+	requestCount := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer testServer.Close()
+
+	now := time.Now()
+	cb := newCircuitBreakerTest(&now)
+
+	retryer := New(conf.RetryPolicy{MaxAttempts: 2}, cb, nil)
+	request, _ := NewRequest(http.MethodGet, testServer.URL, nil)
+
+	for i := 0; i < 3; i++ {
+		retryer.Do(request)
+	}
+
+	_, err := retryer.Do(request)
+
+	assert.True(t, IsCircuitOpen(err))
+	assert.Equal(t, 4, requestCount) // the breaker opens after two calls with two attempts each
+}
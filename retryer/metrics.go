@@ -0,0 +1,43 @@
+package retryer
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	circuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "jec",
+			Subsystem: "circuit_breaker",
+			Name:      "state",
+			Help:      "State of the circuit breaker; 0 is closed, 1 is open and 2 is half-open.",
+		},
+		[]string{"name"},
+	)
+	circuitBreakerTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "jec",
+			Subsystem: "circuit_breaker",
+			Name:      "transitions_total",
+			Help:      "Number of times the circuit breaker has moved to the state.",
+		},
+		[]string{"name", "state"},
+	)
+	circuitBreakerShortCircuits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "jec",
+			Subsystem: "circuit_breaker",
+			Name:      "short_circuited_requests_total",
+			Help:      "Number of requests which are not sent since the circuit breaker is open.",
+		},
+		[]string{"name"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		circuitBreakerState,
+		circuitBreakerTransitions,
+		circuitBreakerShortCircuits,
+	)
+}
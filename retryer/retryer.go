@@ -1,29 +1,94 @@
 package retryer
 
 import (
+	"context"
 	"fmt"
+	"github.com/atlassian/jec/conf"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
 	"math"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
 	"time"
 )
 
-const maxRetryCount = 5
-const timeout = 40 * time.Second
+const (
+	maxAttempts       = 5
+	baseDelayInMillis = 100
+	maxDelayInMillis  = 10000
+	timeoutInSeconds  = 40
+)
+
+const timeout = timeoutInSeconds * time.Second
 
 var DefaultClient = &http.Client{Timeout: timeout}
 
-var retryStatusCodes = map[int]struct{}{
-	429: {},
+var defaultPolicy = conf.RetryPolicy{
+	MaxAttempts:       maxAttempts,
+	BaseDelayInMillis: baseDelayInMillis,
+	MaxDelayInMillis:  maxDelayInMillis,
+	TimeoutInSeconds:  timeoutInSeconds,
 }
 
+var sleep = sleepWithContext
+var randInt63n = rand.Int63n
+
 type Retryer struct {
-	DoFunc func(retryer *Retryer, request *Request) (*http.Response, error)
-	client *http.Client
+	DoFunc         func(retryer *Retryer, request *Request) (*http.Response, error)
+	policy         *conf.RetryPolicy
+	client         *http.Client
+	circuitBreaker *CircuitBreaker
+}
+
+// New creates a retryer with the given policy, the zero valued Retryer uses the default policy.
+// The circuit breaker is optional and can be shared by several retryers, a nil transport means http.DefaultTransport.
+func New(policy conf.RetryPolicy, circuitBreaker *CircuitBreaker, transport http.RoundTripper) *Retryer {
+	ApplyDefaults(&policy)
+
+	return &Retryer{
+		policy: &policy,
+		client: &http.Client{
+			Timeout:   policy.TimeoutInSeconds * time.Second,
+			Transport: transport,
+		},
+		circuitBreaker: circuitBreaker,
+	}
+}
+
+// ApplyDefaults replaces the missing values of the retry policy with the default ones.
+func ApplyDefaults(policy *conf.RetryPolicy) {
+
+	if policy.MaxAttempts <= 0 {
+		logrus.Infof("Max attempts of the retry policy should be greater than zero, default value[%d] is set.", maxAttempts)
+		policy.MaxAttempts = maxAttempts
+	}
+
+	if policy.BaseDelayInMillis <= 0 {
+		logrus.Infof("Base delay of the retry policy should be greater than zero, default value[%d ms.] is set.", baseDelayInMillis)
+		policy.BaseDelayInMillis = baseDelayInMillis
+	}
+
+	if policy.MaxDelayInMillis <= 0 {
+		logrus.Infof("Max delay of the retry policy should be greater than zero, default value[%d ms.] is set.", maxDelayInMillis)
+		policy.MaxDelayInMillis = maxDelayInMillis
+	}
+
+	if policy.MaxDelayInMillis < policy.BaseDelayInMillis {
+		logrus.Infof("Max delay of the retry policy cannot be lesser than its base delay, base delay[%d ms.] is set.", policy.BaseDelayInMillis)
+		policy.MaxDelayInMillis = policy.BaseDelayInMillis
+	}
+
+	if policy.TimeoutInSeconds <= 0 {
+		logrus.Infof("Timeout of the retry policy should be greater than zero, default value[%d s.] is set.", timeoutInSeconds)
+		policy.TimeoutInSeconds = timeoutInSeconds
+	}
 }
 
 func (r *Retryer) Do(request *Request) (*http.Response, error) {
@@ -33,27 +98,151 @@ func (r *Retryer) Do(request *Request) (*http.Response, error) {
 	return DoWithExponentialBackoff(r, request)
 }
 
-func shouldRetry(statusCode int) bool {
-	_, shouldRetry := retryStatusCodes[statusCode]
+func (r *Retryer) retryPolicy() *conf.RetryPolicy {
+	if r.policy != nil {
+		return r.policy
+	}
+	return &defaultPolicy
+}
+
+func shouldRetry(policy *conf.RetryPolicy, statusCode int) bool {
+	if len(policy.RetryableStatusCodes) == 0 {
+		return statusCode == http.StatusTooManyRequests || (statusCode >= 500 && statusCode <= 599)
+	}
 
-	if (statusCode >= 500 && statusCode <= 599) || shouldRetry {
+	for _, retryableStatusCode := range policy.RetryableStatusCodes {
+		if statusCode == retryableStatusCode {
+			return true
+		}
+	}
+	return false
+}
+
+func shouldRetryError(policy *conf.RetryPolicy, err error) bool {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 		return true
 	}
+	return policy.RetryOnConnectionError && isConnectionError(err)
+}
+
+// isConnectionError reports whether the connection is refused or reset by the server.
+func isConnectionError(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		case syscall.Errno:
+			return e == syscall.ECONNREFUSED || e == syscall.ECONNRESET
+		default:
+			return err == io.EOF || err == io.ErrUnexpectedEOF
+		}
+	}
 	return false
 }
 
-func getWaitTime(retryCount int) time.Duration {
-	waitTime := math.Pow(2, float64(retryCount)) * 100
-	return time.Duration(waitTime) * time.Millisecond
+// Backoff returns the waits before the consecutive retries of the policy, see getWaitTime.
+type Backoff struct {
+	policy     *conf.RetryPolicy
+	retryCount int
+}
+
+func NewBackoff(policy *conf.RetryPolicy) *Backoff {
+	return &Backoff{policy: policy}
+}
+
+// Next returns how long to wait before the next retry.
+func (b *Backoff) Next() time.Duration {
+	waitDuration := getWaitTime(b.policy, b.retryCount)
+	b.retryCount++
+	return waitDuration
+}
+
+// getWaitTime doubles the base delay on every retry up to the max delay, with full jitter
+// the wait time is picked randomly between zero and that value.
+func getWaitTime(policy *conf.RetryPolicy, retryCount int) time.Duration {
+	waitTime := math.Min(
+		math.Pow(2, float64(retryCount))*float64(policy.BaseDelayInMillis),
+		float64(policy.MaxDelayInMillis),
+	)
+	waitDuration := time.Duration(waitTime) * time.Millisecond
+
+	if policy.Jitter && waitDuration > 0 {
+		waitDuration = time.Duration(randInt63n(int64(waitDuration) + 1))
+	}
+	return waitDuration
+}
+
+// sleepWithContext waits before the next attempt, the wait is interrupted when the context of the request is canceled.
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// getRetryAfter parses the Retry-After header which is either in seconds or an http date.
+func getRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.ParseInt(header, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	if date.Before(now) {
+		return 0, true
+	}
+	return date.Sub(now), true
 }
 
 func DoWithExponentialBackoff(retryer *Retryer, request *Request) (*http.Response, error) {
 
+	circuitBreaker := retryer.circuitBreaker
+	if circuitBreaker == nil {
+		return doWithExponentialBackoff(retryer, request)
+	}
+
+	err := circuitBreaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := doWithExponentialBackoff(retryer, request)
+	if err != nil {
+		circuitBreaker.Failure()
+	} else {
+		circuitBreaker.Success()
+	}
+	return response, err
+}
+
+func doWithExponentialBackoff(retryer *Retryer, request *Request) (*http.Response, error) {
+
+	policy := retryer.retryPolicy()
+
 	client := DefaultClient
 	if retryer.client != nil {
 		client = retryer.client
 	}
 
+	backoff := NewBackoff(policy)
 	retryCount := 0
 	errMessage := ""
 	for {
@@ -66,35 +255,49 @@ func DoWithExponentialBackoff(retryer *Retryer, request *Request) (*http.Respons
 		}
 		response, err := client.Do(request.Request)
 
-		if err, ok := err.(net.Error); ok {
+		retryAfter, hasRetryAfter := time.Duration(0), false
+		if err != nil {
 			// On error, any Response can be ignored.
-			if err.Timeout() {
-				logrus.Warn(err)
-			} else {
+			if !shouldRetryError(policy, err) {
 				return nil, err
 			}
-		} else if shouldRetry(response.StatusCode) {
+			logrus.Warn(err)
+			errMessage = fmt.Sprintf("last error: %s", err)
+		} else if shouldRetry(policy, response.StatusCode) {
+			if policy.HonorRetryAfter {
+				retryAfter, hasRetryAfter = getRetryAfter(response.Header.Get("Retry-After"), time.Now())
+			}
 			// If the returned error is nil, the Response will contain a non-nil
 			// Body which the user is expected to close.
 			io.Copy(ioutil.Discard, response.Body)
 			response.Body.Close()
+			errMessage = fmt.Sprintf("status code: %d", response.StatusCode)
 		} else {
-			return response, err
+			return response, nil
 		}
 
 		retryCount++
-		if retryCount == maxRetryCount {
-			if err != nil {
-				errMessage = fmt.Sprintf("last error: %s", err)
-			} else {
-				errMessage = fmt.Sprintf("status code: %d", response.StatusCode)
-			}
+		if retryCount >= policy.MaxAttempts {
 			break
 		}
 
-		waitDuration := getWaitTime(retryCount - 1)
-		time.Sleep(waitDuration)
+		// another request may have opened the breaker meanwhile, there is no point to keep retrying
+		if retryer.circuitBreaker != nil && retryer.circuitBreaker.State() == CircuitOpen {
+			return nil, &CircuitOpenError{Name: retryer.circuitBreaker.name}
+		}
+
+		waitDuration := backoff.Next()
+		if hasRetryAfter {
+			// the server knows better when to retry, but it should not stall the caller longer than the max delay
+			waitDuration = retryAfter
+			if maxDelay := policy.MaxDelayInMillis * time.Millisecond; waitDuration > maxDelay {
+				waitDuration = maxDelay
+			}
+		}
+		if err := sleep(request.Context(), waitDuration); err != nil {
+			return nil, err
+		}
 	}
 
-	return nil, errors.Errorf("Couldn't get a success response, maximum retry count[%d] is exceeded, %s", maxRetryCount, errMessage)
+	return nil, errors.Errorf("Couldn't get a success response, maximum retry count[%d] is exceeded, %s", policy.MaxAttempts, errMessage)
 }
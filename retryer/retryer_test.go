@@ -1,7 +1,14 @@
 package retryer
 
 import (
+	"context"
+	"github.com/atlassian/jec/conf"
 	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -20,7 +27,157 @@ func TestGetWaitTime(t *testing.T) {
 	}
 
 	for _, testCase := range testCases {
-		waitTime := getWaitTime(testCase.retryCount)
+		waitTime := getWaitTime(&defaultPolicy, testCase.retryCount)
 		assert.Equal(t, testCase.waitTime, waitTime)
 	}
 }
+
+func TestGetWaitTimeWithMaxDelayAndJitter(t *testing.T) {
+	defer func() { randInt63n = rand.Int63n }()
+
+	policy := &conf.RetryPolicy{BaseDelayInMillis: 100, MaxDelayInMillis: 500}
+	assert.Equal(t, 500*time.Millisecond, getWaitTime(policy, 4))
+
+	var upperBound int64
+	randInt63n = func(n int64) int64 {
+		upperBound = n
+		return n / 2
+	}
+	policy.Jitter = true
+
+	assert.Equal(t, 250*time.Millisecond, getWaitTime(policy, 4))
+	assert.Equal(t, int64(500*time.Millisecond)+1, upperBound)
+}
+
+func TestBackoff(t *testing.T) {
+	backoff := NewBackoff(&conf.RetryPolicy{BaseDelayInMillis: 100, MaxDelayInMillis: 300})
+
+	assert.Equal(t, 100*time.Millisecond, backoff.Next())
+	assert.Equal(t, 200*time.Millisecond, backoff.Next())
+	assert.Equal(t, 300*time.Millisecond, backoff.Next())
+}
+
+func TestGetRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	retryAfter, ok := getRetryAfter("3", now)
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, retryAfter)
+
+	retryAfter, ok = getRetryAfter(now.Add(5*time.Second).Format(http.TimeFormat), now)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, retryAfter)
+
+	_, ok = getRetryAfter("", now)
+	assert.False(t, ok)
+
+	_, ok = getRetryAfter("soon", now)
+	assert.False(t, ok)
+}
+
+func TestShouldRetry(t *testing.T) {
+	assert.True(t, shouldRetry(&defaultPolicy, 429))
+	assert.True(t, shouldRetry(&defaultPolicy, 503))
+	assert.False(t, shouldRetry(&defaultPolicy, 404))
+
+	policy := &conf.RetryPolicy{RetryableStatusCodes: []int{409}}
+	assert.True(t, shouldRetry(policy, 409))
+	assert.False(t, shouldRetry(policy, 503))
+}
+
+func TestDoHonorsRetryAfter(t *testing.T) {
+	defer func() { sleep = sleepWithContext }()
+
+	waitDurations := make([]time.Duration, 0)
+	sleep = func(ctx context.Context, d time.Duration) error {
+		waitDurations = append(waitDurations, d)
+		return nil
+	}
+
+	requestCount := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		if requestCount == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if requestCount == 2 {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	retryer := New(conf.RetryPolicy{MaxDelayInMillis: 10000, HonorRetryAfter: true}, nil, nil)
+	request, _ := NewRequest(http.MethodPost, testServer.URL, strings.NewReader("body"))
+
+	response, err := retryer.Do(request)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, []time.Duration{2 * time.Second, 10 * time.Second}, waitDurations)
+}
+
+func TestDoExceedsMaxAttempts(t *testing.T) {
+	defer func() { sleep = sleepWithContext }()
+	sleep = func(ctx context.Context, d time.Duration) error { return nil }
+
+	requestCount := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer testServer.Close()
+
+	retryer := New(conf.RetryPolicy{MaxAttempts: 3}, nil, nil)
+	request, _ := NewRequest(http.MethodGet, testServer.URL, nil)
+
+	_, err := retryer.Do(request)
+
+	assert.EqualError(t, err, "Couldn't get a success response, maximum retry count[3] is exceeded, status code: 502")
+	assert.Equal(t, 3, requestCount)
+}
+
+func TestDoStopsRetryingWhenRequestIsCanceled(t *testing.T) {
+
+	requestCount := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer testServer.Close()
+
+	retryer := New(conf.RetryPolicy{MaxAttempts: 3, BaseDelayInMillis: 60000, MaxDelayInMillis: 60000}, nil, nil)
+	request, _ := NewRequest(http.MethodGet, testServer.URL, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	request.Request = request.WithContext(ctx)
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := retryer.Do(request)
+
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, requestCount)
+	assert.True(t, time.Since(start) < 10*time.Second)
+}
+
+func TestDoRetriesRefusedConnection(t *testing.T) {
+	defer func() { sleep = sleepWithContext }()
+	sleep = func(ctx context.Context, d time.Duration) error { return nil }
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	request, _ := NewRequest(http.MethodGet, "http://"+address, nil)
+
+	_, err = New(conf.RetryPolicy{MaxAttempts: 2}, nil, nil).Do(request)
+	assert.NotContains(t, err.Error(), "maximum retry count")
+
+	_, err = New(conf.RetryPolicy{MaxAttempts: 2, RetryOnConnectionError: true}, nil, nil).Do(request)
+	assert.Contains(t, err.Error(), "maximum retry count[2] is exceeded")
+}
@@ -2,10 +2,13 @@ package runbook
 
 import (
 	"bytes"
+	"context"
+	"github.com/pkg/errors"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -26,7 +29,30 @@ type ExecError struct {
 	error
 }
 
-func Execute(executionId string, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) (string, error) {
+// NewExecError returns the failure of an executable which exited with the exit code, without running a process.
+func NewExecError(exitCode int, stderr string) *ExecError {
+	return &ExecError{stderr, exitStatusError(exitCode)}
+}
+
+// ExitCode returns the exit code of the executable, or -1 if it could not be run or it was terminated by a signal.
+func (e *ExecError) ExitCode() int {
+	switch err := e.error.(type) {
+	case *exec.ExitError:
+		return err.ExitCode()
+	case exitStatusError:
+		return int(err)
+	}
+	return -1
+}
+
+type exitStatusError int
+
+func (e exitStatusError) Error() string {
+	return "exit status " + strconv.Itoa(int(e))
+}
+
+// Execute runs the executable, the process is killed when the context is done before it exits.
+func Execute(ctx context.Context, executionId string, executablePath string, args, environmentVars []string, stdout, stderr io.Writer) (string, error) {
 
 	callbackContextHandler := NewCallbackContextHandler(executionId)
 	callbackContextHandler.CreatePipe()
@@ -47,9 +73,9 @@ func Execute(executionId string, executablePath string, args, environmentVars []
 
 	if exist {
 		args = append(append(command[1:], executablePath), args...)
-		cmd = exec.Command(command[0], args...)
+		cmd = exec.CommandContext(ctx, command[0], args...)
 	} else {
-		cmd = exec.Command(executablePath, args...)
+		cmd = exec.CommandContext(ctx, executablePath, args...)
 	}
 
 	cmd.Env = append(os.Environ(), environmentVars...)
@@ -68,6 +94,9 @@ func Execute(executionId string, executablePath string, args, environmentVars []
 	callbackContextHandler.ClosePipe()
 
 	if err != nil {
+		if ctx.Err() != nil {
+			return "", &ExecError{stderrBuff.String(), errors.Errorf("Execution is stopped: %s", ctx.Err())}
+		}
 		return "", &ExecError{stderrBuff.String(), err}
 	}
 
@@ -2,11 +2,13 @@ package runbook
 
 import (
 	"bytes"
+	"context"
 	"github.com/atlassian/jec/util"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"runtime"
 	"testing"
+	"time"
 )
 
 const shFileExt = ".sh"
@@ -25,7 +27,7 @@ func TestExecuteSuccess(t *testing.T) {
 		}
 
 		cmdOutput, cmdErr := &bytes.Buffer{}, &bytes.Buffer{}
-		_, err = Execute("executionId", tmpFilePath, nil, testEnvironmentVariables, cmdOutput, cmdErr)
+		_, err = Execute(context.Background(), "executionId", tmpFilePath, nil, testEnvironmentVariables, cmdOutput, cmdErr)
 
 		assert.NoError(t, err, "Error from Execute operation was not empty.")
 		assert.Equal(t, "", cmdErr.String(), "Error stream from executed file was not empty.")
@@ -41,7 +43,7 @@ func TestExecuteSuccess(t *testing.T) {
 		}
 
 		cmdOutput, cmdErr := &bytes.Buffer{}, &bytes.Buffer{}
-		_, err = Execute("executionId", tmpFilePath, nil, testEnvironmentVariables, cmdOutput, cmdErr)
+		_, err = Execute(context.Background(), "executionId", tmpFilePath, nil, testEnvironmentVariables, cmdOutput, cmdErr)
 
 		assert.NoError(t, err, "Error from Execute operation was not empty.")
 		assert.Equal(t, "", cmdErr.String(), "Error stream from executed file was not empty.")
@@ -61,7 +63,7 @@ func TestExecuteWithErrorStream(t *testing.T) {
 		}
 
 		cmdOutput, cmdErr := &bytes.Buffer{}, &bytes.Buffer{}
-		_, err = Execute("executionId", tmpFilePath, nil, nil, cmdOutput, cmdErr)
+		_, err = Execute(context.Background(), "executionId", tmpFilePath, nil, nil, cmdOutput, cmdErr)
 
 		assert.NoError(t, err, "Error from Execute operation was not empty.")
 		assert.Equal(t, "", cmdOutput.String(), "Output stream from executed file was not empty.")
@@ -77,7 +79,7 @@ func TestExecuteWithErrorStream(t *testing.T) {
 		}
 
 		cmdOutput, cmdErr := &bytes.Buffer{}, &bytes.Buffer{}
-		_, err = Execute("executionId", tmpFilePath, nil, nil, cmdOutput, cmdErr)
+		_, err = Execute(context.Background(), "executionId", tmpFilePath, nil, nil, cmdOutput, cmdErr)
 
 		assert.NoError(t, err, "Error from Execute operation was not empty.")
 		assert.Equal(t, "", cmdOutput.String(), "Output stream from executed file was not empty.")
@@ -96,7 +98,7 @@ func TestExecuteWithError(t *testing.T) {
 		}
 
 		cmdOutput, cmdErr := &bytes.Buffer{}, &bytes.Buffer{}
-		_, err = Execute("executionId", tmpFilePath, nil, nil, cmdOutput, cmdErr)
+		_, err = Execute(context.Background(), "executionId", tmpFilePath, nil, nil, cmdOutput, cmdErr)
 
 		assert.IsType(t, &ExecError{}, err)
 		assert.Error(t, err, "Error from Execute operation was empty.")
@@ -114,7 +116,7 @@ func TestExecuteWithError(t *testing.T) {
 		}
 
 		cmdOutput, cmdErr := &bytes.Buffer{}, &bytes.Buffer{}
-		_, err = Execute("executionId", tmpFilePath, nil, nil, cmdOutput, cmdErr)
+		_, err = Execute(context.Background(), "executionId", tmpFilePath, nil, nil, cmdOutput, cmdErr)
 
 		assert.IsType(t, &ExecError{}, err)
 		assert.Error(t, err, "Error from Execute operation was empty.")
@@ -133,13 +135,41 @@ func TestExecuteWithError(t *testing.T) {
 		}
 
 		cmdOutput, cmdErr := &bytes.Buffer{}, &bytes.Buffer{}
-		_, err = Execute("executionId", tmpFilePath, nil, nil, cmdOutput, cmdErr)
+		_, err = Execute(context.Background(), "executionId", tmpFilePath, nil, nil, cmdOutput, cmdErr)
 
 		assert.IsType(t, &ExecError{}, err)
 		assert.Error(t, err, "Error from Execute operation was empty.")
 		assert.Equal(t, err.Error(), "exit status 127", "Error message was not equal to expected.")
+		assert.Equal(t, 127, err.(*ExecError).ExitCode())
 		assert.Equal(t, "", cmdOutput.String(), "Output stream from executed file was not empty.")
 		assert.Contains(t, cmdErr.String(), "not found", "Error stream from executed file does not contain err message.")
 		assert.Contains(t, err.(*ExecError).Stderr, cmdErr.String(), "ExecError is not same as cmdErr.")
 	}
 }
+
+func TestNewExecError(t *testing.T) {
+	err := NewExecError(75, "connection reset")
+
+	assert.Equal(t, 75, err.ExitCode())
+	assert.Equal(t, "connection reset", err.Stderr)
+	assert.EqualError(t, err, "exit status 75")
+}
+
+func TestExecuteStopsWhenContextIsDone(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+
+	tmpFilePath, err := util.CreateTempTestFile([]byte("exec sleep 10\n"), shFileExt)
+	defer os.Remove(tmpFilePath)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = Execute(ctx, "executionId", tmpFilePath, nil, nil, &bytes.Buffer{}, &bytes.Buffer{})
+
+	assert.EqualError(t, err, "Execution is stopped: context deadline exceeded")
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
@@ -0,0 +1,231 @@
+package runbook
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// outboxRecord keeps the name of the integration instead of its api key, so that the api keys are not written
+// to the disk, the api key is looked up by the name when the result is sent.
+type outboxRecord struct {
+	Result      *ActionResultPayload `json:"result"`
+	Integration string               `json:"integration"`
+	BaseUrl     string               `json:"baseUrl"`
+}
+
+// Outbox keeps the action results which could not be sent to Jira Service Management in a json lines file,
+// so that they can be sent once it is reachable again, even after a restart.
+type Outbox struct {
+	path    string
+	file    *os.File
+	records []*outboxRecord
+	mu      *sync.Mutex
+	flushMu *sync.Mutex
+}
+
+func OpenOutbox(path string) (*Outbox, error) {
+	outbox := &Outbox{
+		path:    path,
+		records: make([]*outboxRecord, 0),
+		mu:      &sync.Mutex{},
+		flushMu: &sync.Mutex{},
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	err = outbox.load()
+	if err != nil {
+		return nil, errors.Errorf("Result outbox[%s] could not be loaded: %s", path, err)
+	}
+
+	outbox.file, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Result outbox[%s] is opened with %d results.", path, len(outbox.records))
+	return outbox, nil
+}
+
+func (o *Outbox) Put(result *ActionResultPayload, apiKey, baseUrl string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.file == nil {
+		return errors.New("Result outbox is closed.")
+	}
+
+	integrationName, ok := integrationNameOf(apiKey)
+	if !ok {
+		return errors.New("Integration of the api key is not known.")
+	}
+
+	r := &outboxRecord{
+		Result:      result,
+		Integration: integrationName,
+		BaseUrl:     baseUrl,
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = o.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	o.records = append(o.records, r)
+	return nil
+}
+
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.records)
+}
+
+// Flush sends the results in the order they are put and stops at the first failure which may be recovered,
+// the results which are not sent stay in the outbox. The results which are rejected by Jira Service Management
+// or whose integration is not known anymore are dropped. The results are sent without holding the outbox,
+// so that the results can be put into it meanwhile.
+func (o *Outbox) Flush() (int, error) {
+	o.flushMu.Lock()
+	defer o.flushMu.Unlock()
+
+	o.mu.Lock()
+	if o.file == nil || len(o.records) == 0 {
+		o.mu.Unlock()
+		return 0, nil
+	}
+	records := append([]*outboxRecord{}, o.records...)
+	o.mu.Unlock()
+
+	sentCount := 0
+	processedCount := 0
+	var sendErr error
+	for _, r := range records {
+		apiKey, ok := apiKeyOf(r.Integration)
+		if !ok {
+			logrus.Warnf("Result of request[%s] is dropped from the outbox, its integration[%s] is not known.", r.Result.RequestId, r.Integration)
+			processedCount++
+			continue
+		}
+
+		err := sendResult(r.Result, apiKey, r.BaseUrl)
+		if isRejected(err) {
+			logrus.Warnf("Result of request[%s] is dropped from the outbox, it is rejected: %s", r.Result.RequestId, err)
+			processedCount++
+			continue
+		}
+		if err != nil {
+			sendErr = err
+			break
+		}
+		sentCount++
+		processedCount++
+	}
+
+	if processedCount == 0 {
+		return 0, sendErr
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// the records which are put while flushing are appended after the processed ones
+	o.records = o.records[processedCount:]
+	if o.file == nil {
+		return sentCount, sendErr
+	}
+	err := o.rewrite()
+	if err != nil {
+		return sentCount, errors.Errorf("Result outbox[%s] could not be rewritten: %s", o.path, err)
+	}
+	return sentCount, sendErr
+}
+
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.file == nil {
+		return nil
+	}
+	err := o.file.Close()
+	o.file = nil
+	return err
+}
+
+func (o *Outbox) load() error {
+	file, err := os.Open(o.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		r := &outboxRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil || r.Result == nil {
+			logrus.Warnf("Result outbox[%s] skipped a corrupted record.", o.path)
+			continue
+		}
+		o.records = append(o.records, r)
+	}
+	return scanner.Err()
+}
+
+// rewrite replaces the file with the records which are not sent yet and reopens it for appending.
+func (o *Outbox) rewrite() error {
+	tmpPath := o.path + ".tmp"
+
+	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmpFile)
+	for _, r := range o.records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			tmpFile.Close()
+			return err
+		}
+		writer.Write(append(line, '\n'))
+	}
+
+	if err := writer.Flush(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	o.file.Close()
+
+	// the original file is reopened if it cannot be replaced, so that the outbox is still usable
+	renameErr := os.Rename(tmpPath, o.path)
+
+	o.file, err = os.OpenFile(o.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if renameErr != nil {
+		os.Remove(tmpPath)
+		return renameErr
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+package runbook
+
+import (
+	"github.com/atlassian/jec/retryer"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSendResultToOutboxWhileCircuitIsOpen(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "outbox")
+	defer os.RemoveAll(dir)
+
+	outboxPath := filepath.Join(dir, "result-outbox.jsonl")
+	resultOutbox, err := OpenOutbox(outboxPath)
+	assert.Nil(t, err)
+
+	SetOutbox(resultOutbox)
+	defer SetOutbox(nil)
+
+	client := &retryer.Retryer{}
+	SetIntegration("billing", "apiKey", client)
+	defer removeIntegration("billing")
+
+	client.DoFunc = func(r *retryer.Retryer, request *retryer.Request) (*http.Response, error) {
+		return nil, &retryer.CircuitOpenError{Name: "jsm"}
+	}
+
+	err = SendResultToJsm(&ActionResultPayload{RequestId: "first"}, "apiKey", "baseUrl")
+	assert.Nil(t, err)
+	err = SendResultToJsm(&ActionResultPayload{RequestId: "second"}, "apiKey", "baseUrl")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, resultOutbox.Len())
+
+	sentCount, err := resultOutbox.Flush()
+	assert.Equal(t, 0, sentCount)
+	assert.True(t, retryer.IsCircuitOpen(err))
+	resultOutbox.Close()
+
+	resultOutbox, err = OpenOutbox(outboxPath)
+	assert.Nil(t, err)
+	defer resultOutbox.Close()
+	assert.Equal(t, 2, resultOutbox.Len())
+
+	sentRequestIds := make([]string, 0)
+	client.DoFunc = func(r *retryer.Retryer, request *retryer.Request) (*http.Response, error) {
+		if len(sentRequestIds) == 1 {
+			return nil, &retryer.CircuitOpenError{Name: "jsm"}
+		}
+		body, _ := ioutil.ReadAll(request.Body)
+		sentRequestIds = append(sentRequestIds, string(body))
+		return &http.Response{StatusCode: http.StatusAccepted, Body: ioutil.NopCloser(nil)}, nil
+	}
+
+	sentCount, err = resultOutbox.Flush()
+	assert.Equal(t, 1, sentCount)
+	assert.True(t, retryer.IsCircuitOpen(err))
+	assert.Contains(t, sentRequestIds[0], `"requestId":"first"`)
+	assert.Equal(t, 1, resultOutbox.Len())
+
+	content, _ := ioutil.ReadFile(outboxPath)
+	assert.Contains(t, string(content), `"requestId":"second"`)
+	assert.NotContains(t, string(content), `"requestId":"first"`)
+	assert.Contains(t, string(content), `"integration":"billing"`)
+	assert.NotContains(t, string(content), "apiKey")
+}
+
+func TestFlushOutboxDropsRejectedResults(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "outbox")
+	defer os.RemoveAll(dir)
+
+	outboxPath := filepath.Join(dir, "result-outbox.jsonl")
+	resultOutbox, err := OpenOutbox(outboxPath)
+	assert.Nil(t, err)
+	defer resultOutbox.Close()
+
+	client := &retryer.Retryer{}
+	SetIntegration("billing", "apiKey", client)
+	defer removeIntegration("billing")
+
+	resultOutbox.Put(&ActionResultPayload{RequestId: "rejected"}, "apiKey", "baseUrl")
+	resultOutbox.Put(&ActionResultPayload{RequestId: "sent"}, "apiKey", "baseUrl")
+	resultOutbox.Put(&ActionResultPayload{RequestId: "kept"}, "apiKey", "baseUrl")
+	resultOutbox.records = append(resultOutbox.records, &outboxRecord{
+		Result:      &ActionResultPayload{RequestId: "unknown"},
+		Integration: "removed",
+	})
+
+	client.DoFunc = func(r *retryer.Retryer, request *retryer.Request) (*http.Response, error) {
+		body, _ := ioutil.ReadAll(request.Body)
+		switch {
+		case strings.Contains(string(body), `"requestId":"rejected"`):
+			return &http.Response{StatusCode: http.StatusBadRequest, Body: ioutil.NopCloser(strings.NewReader("invalid"))}, nil
+		case strings.Contains(string(body), `"requestId":"sent"`):
+			return &http.Response{StatusCode: http.StatusAccepted, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+		}
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	}
+
+	sentCount, err := resultOutbox.Flush()
+	assert.Equal(t, 1, sentCount)
+	assert.EqualError(t, err, "Unexpected response status: 503, error message: ")
+	assert.Equal(t, 2, resultOutbox.Len())
+
+	client.DoFunc = func(r *retryer.Retryer, request *retryer.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusAccepted, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	}
+
+	sentCount, err = resultOutbox.Flush()
+	assert.Equal(t, 1, sentCount)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, resultOutbox.Len())
+
+	content, _ := ioutil.ReadFile(outboxPath)
+	assert.Empty(t, string(content))
+}
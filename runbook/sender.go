@@ -5,29 +5,136 @@ import (
 	"encoding/json"
 	"github.com/atlassian/jec/retryer"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"sync"
 )
 
 const resultPath = "/jsm/ops/jec/v1/callback"
 
+const (
+	MessageExpiredFailureReason = "MessageExpired"
+)
+
 var SendResultToJsmFunc = SendResultToJsm
 
 var client = &retryer.Retryer{}
 
+// integrationClients are keyed by the integration names, the api keys are looked up by the names
+// so that they are not written into the outbox.
+var integrationClients = make(map[string]*integrationClient)
+var integrationClientsMu = &sync.RWMutex{}
+
+type integrationClient struct {
+	apiKey  string
+	retryer *retryer.Retryer
+}
+
+var outbox *Outbox
+var outboxMu = &sync.RWMutex{}
+
+var instanceId string
+var instanceLabels map[string]string
+
+// SetRetryer sets the retryer of the requests which send the action results to Jira Service Management.
+func SetRetryer(resultRetryer *retryer.Retryer) {
+	client = resultRetryer
+}
+
+// SetIntegration sets the api key of the integration and the retryer of the results which are sent with it,
+// so that the integrations do not share the circuit breakers of their retryers.
+func SetIntegration(integrationName, apiKey string, resultRetryer *retryer.Retryer) {
+	integrationClientsMu.Lock()
+	defer integrationClientsMu.Unlock()
+	integrationClients[integrationName] = &integrationClient{apiKey: apiKey, retryer: resultRetryer}
+}
+
+func retryerOf(apiKey string) *retryer.Retryer {
+	integrationClientsMu.RLock()
+	defer integrationClientsMu.RUnlock()
+
+	for _, integrationClient := range integrationClients {
+		if integrationClient.apiKey == apiKey {
+			return integrationClient.retryer
+		}
+	}
+	return client
+}
+
+func integrationNameOf(apiKey string) (string, bool) {
+	integrationClientsMu.RLock()
+	defer integrationClientsMu.RUnlock()
+
+	for integrationName, integrationClient := range integrationClients {
+		if integrationClient.apiKey == apiKey {
+			return integrationName, true
+		}
+	}
+	return "", false
+}
+
+func apiKeyOf(integrationName string) (string, bool) {
+	integrationClientsMu.RLock()
+	defer integrationClientsMu.RUnlock()
+
+	if integrationClient, ok := integrationClients[integrationName]; ok {
+		return integrationClient.apiKey, true
+	}
+	return "", false
+}
+
+// SetOutbox sets the outbox which keeps the results while the circuit breaker of the retryer is open, nil disables it.
+func SetOutbox(resultOutbox *Outbox) {
+	outboxMu.Lock()
+	defer outboxMu.Unlock()
+	outbox = resultOutbox
+}
+
+// SetInstance sets the identity of the JEC instance which is added to all of the action results.
+func SetInstance(id string, labels map[string]string) {
+	instanceId = id
+	instanceLabels = labels
+}
+
 type ActionResultPayload struct {
-	RequestId       string `json:"requestId,omitempty"`
-	IsSuccessful    bool   `json:"isSuccessful,omitempty"`
-	EntityId        string `json:"entityId,omitempty"`
-	EntityType      string `json:"entityType,omitempty"`
-	Action          string `json:"action,omitempty"`
-	ActionType      string `json:"actionType,omitempty"`
-	FailureMessage  string `json:"failureMessage,omitempty"`
-	CallbackContext string `json:"callbackContext"`
+	RequestId       string            `json:"requestId,omitempty"`
+	IsSuccessful    bool              `json:"isSuccessful,omitempty"`
+	EntityId        string            `json:"entityId,omitempty"`
+	EntityType      string            `json:"entityType,omitempty"`
+	Action          string            `json:"action,omitempty"`
+	ActionType      string            `json:"actionType,omitempty"`
+	FailureMessage  string            `json:"failureMessage,omitempty"`
+	FailureReason   string            `json:"failureReason,omitempty"`
+	CallbackContext string            `json:"callbackContext"`
+	InstanceId      string            `json:"instanceId,omitempty"`
+	InstanceLabels  map[string]string `json:"instanceLabels,omitempty"`
+	Steps           []StepResult      `json:"steps,omitempty"`
+	Targets         []StepResult      `json:"targets,omitempty"`
+	Attempts        []AttemptResult   `json:"attempts,omitempty"`
 	*HttpResponse
 }
 
+// StepResult is the outcome of one of the executions which make up the result of an action,
+// i.e. a workflow step or a fan-out target.
+type StepResult struct {
+	Name             string `json:"name"`
+	IsSuccessful     bool   `json:"isSuccessful"`
+	FailureMessage   string `json:"failureMessage,omitempty"`
+	CallbackContext  string `json:"callbackContext,omitempty"`
+	DurationInMillis int64  `json:"durationInMillis"`
+	Attempts         int    `json:"attempts,omitempty"`
+}
+
+// AttemptResult is the outcome of one of the executions of an action which is retried on failure.
+type AttemptResult struct {
+	Attempt          int    `json:"attempt"`
+	IsSuccessful     bool   `json:"isSuccessful"`
+	FailureMessage   string `json:"failureMessage,omitempty"`
+	DurationInMillis int64  `json:"durationInMillis"`
+}
+
 type HttpResponse struct {
 	Headers    map[string]string `json:"headers"`
 	Body       string            `json:"body"`
@@ -36,6 +143,34 @@ type HttpResponse struct {
 
 func SendResultToJsm(resultPayload *ActionResultPayload, apiKey, baseUrl string) error {
 
+	if resultPayload.InstanceId == "" {
+		resultPayload.InstanceId = instanceId
+		resultPayload.InstanceLabels = instanceLabels
+	}
+
+	err := sendResult(resultPayload, apiKey, baseUrl)
+	if !retryer.IsCircuitOpen(err) {
+		return err
+	}
+
+	outboxMu.RLock()
+	defer outboxMu.RUnlock()
+
+	if outbox == nil {
+		return err
+	}
+
+	putErr := outbox.Put(resultPayload, apiKey, baseUrl)
+	if putErr != nil {
+		return errors.Errorf("%s Result could not be put into the outbox: %s", err, putErr)
+	}
+
+	logrus.Infof("Result of request[%s] is kept in the outbox until Jira Service Management is reachable.", resultPayload.RequestId)
+	return nil
+}
+
+func sendResult(resultPayload *ActionResultPayload, apiKey, baseUrl string) error {
+
 	body, err := json.Marshal(resultPayload)
 	if err != nil {
 		return errors.Errorf("Cannot marshall payload: %s", err)
@@ -50,7 +185,7 @@ func SendResultToJsm(resultPayload *ActionResultPayload, apiKey, baseUrl string)
 	request.Header.Add("Authorization", "GenieKey "+apiKey)
 	request.Header.Add("Content-Type", "application/json; charset=UTF-8")
 
-	response, err := client.Do(request)
+	response, err := retryerOf(apiKey).Do(request)
 	if err != nil {
 		return err
 	}
@@ -58,16 +193,34 @@ func SendResultToJsm(resultPayload *ActionResultPayload, apiKey, baseUrl string)
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusAccepted {
-
-		errorMessage := "Unexpected response status: " + strconv.Itoa(response.StatusCode)
-
 		body, err := ioutil.ReadAll(response.Body)
-		if err == nil {
-			return errors.Errorf("%s, error message: %s", errorMessage, string(body))
-		} else {
-			return errors.Errorf("%s, also could not read response body: %s", errorMessage, err)
-		}
+		return &ResponseStatusError{StatusCode: response.StatusCode, body: string(body), readErr: err}
 	}
 
 	return nil
 }
+
+// ResponseStatusError is returned when Jira Service Management does not accept the result.
+type ResponseStatusError struct {
+	StatusCode int
+	body       string
+	readErr    error
+}
+
+func (e *ResponseStatusError) Error() string {
+	errorMessage := "Unexpected response status: " + strconv.Itoa(e.StatusCode)
+	if e.readErr != nil {
+		return errorMessage + ", also could not read response body: " + e.readErr.Error()
+	}
+	return errorMessage + ", error message: " + e.body
+}
+
+// isRejected reports whether the result is rejected by Jira Service Management, sending it again would not help.
+func isRejected(err error) bool {
+	statusErr, ok := err.(*ResponseStatusError)
+	if !ok {
+		return false
+	}
+	return statusErr.StatusCode >= 400 && statusErr.StatusCode < 500 &&
+		statusErr.StatusCode != http.StatusRequestTimeout && statusErr.StatusCode != http.StatusTooManyRequests
+}
@@ -96,3 +96,49 @@ func TestSendResultToJsmClientError(t *testing.T) {
 
 	assert.Error(t, err, "Could not send action result to Jira Service Management. Reason: Test client error")
 }
+
+func TestSendResultToJsmWithInstance(t *testing.T) {
+	SetInstance("jec-1", map[string]string{"zone": "eu-1"})
+	defer SetInstance("", nil)
+
+	actionResult := &ActionResultPayload{}
+	ts := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(body, actionResult)
+		res.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	err := SendResultToJsm(&ActionResultPayload{Action: "testAction"}, "testKey", ts.URL)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "jec-1", actionResult.InstanceId)
+	assert.Equal(t, map[string]string{"zone": "eu-1"}, actionResult.InstanceLabels)
+}
+
+func TestSendResultToJsmWithIntegration(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	failingRetryer := &retryer.Retryer{
+		DoFunc: func(retryer *retryer.Retryer, request *retryer.Request) (*http.Response, error) {
+			return nil, errors.New("Test integration client error")
+		},
+	}
+	SetIntegration("billing", "billingKey", failingRetryer)
+	defer removeIntegration("billing")
+
+	err := SendResultToJsm(new(ActionResultPayload), "billingKey", ts.URL)
+	assert.EqualError(t, err, "Test integration client error")
+
+	err = SendResultToJsm(new(ActionResultPayload), "paymentsKey", ts.URL)
+	assert.Nil(t, err)
+}
+
+func removeIntegration(integrationName string) {
+	integrationClientsMu.Lock()
+	defer integrationClientsMu.Unlock()
+	delete(integrationClients, integrationName)
+}
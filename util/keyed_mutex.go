@@ -0,0 +1,59 @@
+package util
+
+import "sync"
+
+type keyedLock struct {
+	mu       sync.Mutex
+	refCount int
+}
+
+// KeyedMutex serializes the callers which lock the same key while callers with different keys run concurrently.
+type KeyedMutex struct {
+	locks map[string]*keyedLock
+	mu    sync.Mutex
+}
+
+func NewKeyedMutex() *KeyedMutex {
+	return &KeyedMutex{
+		locks: make(map[string]*keyedLock),
+	}
+}
+
+func (m *KeyedMutex) Lock(key string) {
+	m.mu.Lock()
+	lock, ok := m.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		m.locks[key] = lock
+	}
+	lock.refCount++
+	m.mu.Unlock()
+
+	lock.mu.Lock()
+}
+
+func (m *KeyedMutex) Unlock(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lock, ok := m.locks[key]
+	if !ok {
+		panic("util: unlock of unlocked key " + key)
+	}
+	lock.refCount--
+	if lock.refCount == 0 {
+		delete(m.locks, key)
+	}
+	lock.mu.Unlock()
+}
+
+// Waiting returns the number of callers which hold or wait for the given key.
+func (m *KeyedMutex) Waiting(key string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if lock, ok := m.locks[key]; ok {
+		return lock.refCount
+	}
+	return 0
+}
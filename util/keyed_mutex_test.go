@@ -0,0 +1,60 @@
+package util
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeyedMutexSerializesSameKey(t *testing.T) {
+	keyedMutex := NewKeyedMutex()
+
+	var running int32
+	var maxRunning int32
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keyedMutex.Lock("key")
+			defer keyedMutex.Unlock("key")
+
+			current := atomic.AddInt32(&running, 1)
+			for observed := atomic.LoadInt32(&maxRunning); current > observed; observed = atomic.LoadInt32(&maxRunning) {
+				if atomic.CompareAndSwapInt32(&maxRunning, observed, current) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), maxRunning)
+	assert.Equal(t, 0, keyedMutex.Waiting("key"))
+	assert.Equal(t, 0, len(keyedMutex.locks))
+}
+
+func TestKeyedMutexDifferentKeys(t *testing.T) {
+	keyedMutex := NewKeyedMutex()
+
+	keyedMutex.Lock("key1")
+	done := make(chan struct{})
+	go func() {
+		keyedMutex.Lock("key2")
+		keyedMutex.Unlock("key2")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Different keys should not block each other.")
+	}
+	assert.Equal(t, 1, keyedMutex.Waiting("key1"))
+	keyedMutex.Unlock("key1")
+}
@@ -16,6 +16,13 @@ func Min(x, y int64) int64 {
 	return x
 }
 
+func Max(x, y int64) int64 {
+	if x < y {
+		return y
+	}
+	return x
+}
+
 func CreateTempTestFile(content []byte, fileExtension string) (string, error) {
 
 	tempFile, err := ioutil.TempFile("", "*"+fileExtension)
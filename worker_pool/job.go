@@ -4,3 +4,8 @@ type Job interface {
 	Id() string
 	Execute() error
 }
+
+// PanicHandler is implemented by the jobs which should clean up after their Execute panics.
+type PanicHandler interface {
+	HandlePanic(recovered interface{})
+}
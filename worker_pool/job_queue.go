@@ -0,0 +1,151 @@
+package worker_pool
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	HighestPriority = 1
+	LowestPriority  = 5
+	DefaultPriority = 3
+)
+
+// PrioritizedJob is implemented by the jobs which should not wait behind the jobs with lower priorities.
+// Priorities range from HighestPriority(1) to LowestPriority(5), the jobs without a priority get DefaultPriority.
+type PrioritizedJob interface {
+	Job
+	Priority() int
+}
+
+type pendingJob struct {
+	job        Job
+	priority   int
+	enqueuedAt time.Time
+}
+
+// jobQueue orders the pending jobs by their priorities. A pending job is promoted one priority level for each
+// aging interval it waits, so that low priority jobs are not starved by a steady flow of high priority ones.
+// The popped jobs are still counted as pending until a worker claims them.
+type jobQueue struct {
+	jobs          []*pendingJob
+	dispatching   int
+	agingInterval time.Duration
+	notEmpty      chan struct{}
+	nowFunc       func() time.Time
+	mu            *sync.Mutex
+}
+
+func newJobQueue(agingInterval time.Duration) *jobQueue {
+	return &jobQueue{
+		jobs:          make([]*pendingJob, 0),
+		agingInterval: agingInterval,
+		notEmpty:      make(chan struct{}, 1),
+		nowFunc:       time.Now,
+		mu:            &sync.Mutex{},
+	}
+}
+
+func jobPriority(job Job) int {
+	prioritizedJob, ok := job.(PrioritizedJob)
+	if !ok {
+		return DefaultPriority
+	}
+
+	priority := prioritizedJob.Priority()
+	if priority < HighestPriority || priority > LowestPriority {
+		return DefaultPriority
+	}
+	return priority
+}
+
+func (q *jobQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.jobs)
+}
+
+// Waiting returns the number of the pending jobs, including the ones which are popped but not claimed by a worker yet.
+func (q *jobQueue) Waiting() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.jobs) + q.dispatching
+}
+
+func (q *jobQueue) Push(job Job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.push(job)
+}
+
+// TryPush pushes the job only if there are less than limit pending jobs, including the ones which are popped
+// but not claimed by a worker yet.
+func (q *jobQueue) TryPush(job Job, limit int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.jobs)+q.dispatching >= limit {
+		return false
+	}
+	q.push(job)
+	return true
+}
+
+func (q *jobQueue) push(job Job) {
+	q.jobs = append(q.jobs, &pendingJob{
+		job:        job,
+		priority:   jobPriority(job),
+		enqueuedAt: q.nowFunc(),
+	})
+
+	select {
+	case q.notEmpty <- struct{}{}:
+	default:
+	}
+}
+
+// Pop removes the job with the highest effective priority, the jobs with the same priority are popped in arrival order.
+func (q *jobQueue) Pop() (*pendingJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.jobs) == 0 {
+		return nil, false
+	}
+
+	now := q.nowFunc()
+	next := 0
+	for i := 1; i < len(q.jobs); i++ {
+		priority, nextPriority := q.effectivePriority(q.jobs[i], now), q.effectivePriority(q.jobs[next], now)
+		if priority < nextPriority || priority == nextPriority && q.jobs[i].enqueuedAt.Before(q.jobs[next].enqueuedAt) {
+			next = i
+		}
+	}
+
+	pending := q.jobs[next]
+	q.jobs = append(q.jobs[:next], q.jobs[next+1:]...)
+	q.dispatching++
+	return pending, true
+}
+
+// Requeue puts a popped job back without changing its arrival time.
+func (q *jobQueue) Requeue(pending *pendingJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs = append(q.jobs, pending)
+	q.dispatching--
+}
+
+// Claim is called when a worker takes a popped job.
+func (q *jobQueue) Claim() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dispatching--
+}
+
+func (q *jobQueue) effectivePriority(pending *pendingJob, now time.Time) int {
+	if q.agingInterval <= 0 {
+		return pending.priority
+	}
+	return pending.priority - int(now.Sub(pending.enqueuedAt)/q.agingInterval)
+}
@@ -0,0 +1,137 @@
+package worker_pool
+
+import (
+	"github.com/atlassian/jec/conf"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type mockPrioritizedJob struct {
+	MockJob
+	priority int
+}
+
+func (j *mockPrioritizedJob) Priority() int {
+	return j.priority
+}
+
+func newMockPrioritizedJob(id string, priority int) *mockPrioritizedJob {
+	job := &mockPrioritizedJob{priority: priority}
+	job.JobIdFunc = func() string {
+		return id
+	}
+	return job
+}
+
+func popJobIds(q *jobQueue) []string {
+	ids := make([]string, 0)
+	for pending, ok := q.Pop(); ok; pending, ok = q.Pop() {
+		ids = append(ids, pending.job.Id())
+	}
+	return ids
+}
+
+func TestJobQueuePopsHighestPriorityFirst(t *testing.T) {
+	q := newJobQueue(time.Minute)
+
+	q.Push(newMockPrioritizedJob("p5", 5))
+	q.Push(NewMockJob())
+	q.Push(newMockPrioritizedJob("p1-first", 1))
+	q.Push(newMockPrioritizedJob("p1-second", 1))
+	q.Push(newMockPrioritizedJob("invalid", 9))
+
+	assert.Equal(t, []string{"p1-first", "p1-second", "mockJobId", "invalid", "p5"}, popJobIds(q))
+}
+
+func TestJobQueuePromotesWaitingJobs(t *testing.T) {
+	q := newJobQueue(time.Second)
+
+	now := time.Now()
+	q.nowFunc = func() time.Time { return now }
+	q.Push(newMockPrioritizedJob("p5", 5))
+
+	now = now.Add(3 * time.Second)
+	q.Push(newMockPrioritizedJob("p3", 3))
+	q.Push(newMockPrioritizedJob("p1", 1))
+
+	// p5 has waited 3 aging intervals, so it is promoted to P2
+	assert.Equal(t, []string{"p1", "p5", "p3"}, popJobIds(q))
+}
+
+func TestJobQueueTryPush(t *testing.T) {
+	q := newJobQueue(time.Second)
+
+	assert.True(t, q.TryPush(NewMockJob(), 1))
+	assert.False(t, q.TryPush(NewMockJob(), 1))
+	assert.Equal(t, 1, q.Len())
+}
+
+func TestJobQueueRequeuedJobKeepsItsOrder(t *testing.T) {
+	q := newJobQueue(time.Minute)
+
+	now := time.Now()
+	q.nowFunc = func() time.Time { return now }
+	q.Push(newMockPrioritizedJob("first", 3))
+
+	pending, _ := q.Pop()
+	now = now.Add(time.Millisecond)
+	q.Push(newMockPrioritizedJob("second", 3))
+	q.Requeue(pending)
+
+	assert.Equal(t, []string{"first", "second"}, popJobIds(q))
+}
+
+func TestJobQueueTryPushCountsUnclaimedJobs(t *testing.T) {
+	q := newJobQueue(time.Second)
+
+	assert.True(t, q.TryPush(NewMockJob(), 1))
+	q.Pop()
+	assert.False(t, q.TryPush(NewMockJob(), 1))
+
+	q.Claim()
+	assert.True(t, q.TryPush(NewMockJob(), 1))
+}
+
+func TestPoolRunsHighPriorityJobsFirst(t *testing.T) {
+	pool := New(&conf.PoolConf{MaxNumberOfWorker: 1, MinNumberOfWorker: 1, QueueSize: 2}).(*workerPool)
+
+	err := pool.Start()
+	assert.Nil(t, err)
+
+	release := make(chan struct{})
+	blockingJob := NewMockJob()
+	blockingJob.ExecuteFunc = func() error {
+		<-release
+		return nil
+	}
+
+	executedIds := make(chan string, 2)
+	newJob := func(id string, priority int) Job {
+		job := newMockPrioritizedJob(id, priority)
+		job.ExecuteFunc = func() error {
+			executedIds <- id
+			return nil
+		}
+		return job
+	}
+
+	for isSubmitted, _ := pool.Submit(blockingJob); !isSubmitted; isSubmitted, _ = pool.Submit(blockingJob) {
+	}
+	for pool.NumberOfIdleWorker() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	isSubmitted, _ := pool.Submit(newJob("p5", 5))
+	assert.True(t, isSubmitted)
+	time.Sleep(10 * time.Millisecond) // lets the dispatcher pick p5 up before p1 is submitted
+	isSubmitted, _ = pool.Submit(newJob("p1", 1))
+	assert.True(t, isSubmitted)
+
+	close(release)
+	assert.Equal(t, "p1", <-executedIds)
+	assert.Equal(t, "p5", <-executedIds)
+
+	err = pool.Stop()
+	assert.Nil(t, err)
+}
@@ -0,0 +1,63 @@
+package worker_pool
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	poolMaxWorkers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "jec",
+			Subsystem: "worker_pool",
+			Name:      "max_workers",
+			Help:      "Max number of workers of the worker pool.",
+		},
+		[]string{"pool"},
+	)
+	poolCurrentWorkers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "jec",
+			Subsystem: "worker_pool",
+			Name:      "current_workers",
+			Help:      "Number of spawned workers of the worker pool.",
+		},
+		[]string{"pool"},
+	)
+	poolIdleWorkers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "jec",
+			Subsystem: "worker_pool",
+			Name:      "idle_workers",
+			Help:      "Number of workers of the worker pool which wait for a job.",
+		},
+		[]string{"pool"},
+	)
+	poolQueuedJobs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "jec",
+			Subsystem: "worker_pool",
+			Name:      "queued_jobs",
+			Help:      "Number of jobs waiting in the queue of the worker pool.",
+		},
+		[]string{"pool"},
+	)
+	poolJobPanics = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "jec",
+			Subsystem: "worker_pool",
+			Name:      "job_panics_total",
+			Help:      "Number of jobs which panicked while executing in the worker pool.",
+		},
+		[]string{"pool"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		poolMaxWorkers,
+		poolCurrentWorkers,
+		poolIdleWorkers,
+		poolQueuedJobs,
+		poolJobPanics,
+	)
+}
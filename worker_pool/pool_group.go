@@ -0,0 +1,122 @@
+package worker_pool
+
+import (
+	"github.com/atlassian/jec/conf"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// PooledJob is implemented by the jobs which should be run by a specific pool of a pool group.
+type PooledJob interface {
+	Job
+	Pool() string
+}
+
+// PoolGroup isolates the jobs of different pools from each other, so that the jobs of a busy pool
+// cannot take the workers of the others.
+type PoolGroup interface {
+	WorkerPool
+	NumberOfAvailableWorkerByPool() map[string]int32
+	PoolConfs() map[string]conf.PoolConf
+	ResizePool(name string, poolConf conf.PoolConf) error
+}
+
+type poolGroup struct {
+	pools map[string]WorkerPool
+}
+
+func NewPoolGroup(pools map[string]WorkerPool) (PoolGroup, error) {
+	if _, ok := pools[DefaultPoolName]; !ok {
+		return nil, errors.Errorf("Pool group does not contain the %s pool.", DefaultPoolName)
+	}
+
+	return &poolGroup{
+		pools: pools,
+	}, nil
+}
+
+func (g *poolGroup) Start() error {
+	started := make([]WorkerPool, 0, len(g.pools))
+	for name, pool := range g.pools {
+		err := pool.Start()
+		if err != nil {
+			for _, startedPool := range started {
+				startedPool.Stop()
+			}
+			return errors.Errorf("Worker pool[%s] could not be started: %s", name, err)
+		}
+		started = append(started, pool)
+	}
+	return nil
+}
+
+func (g *poolGroup) Stop() error {
+	var stopErr error
+	for name, pool := range g.pools {
+		err := pool.Stop()
+		if err != nil && stopErr == nil {
+			stopErr = errors.Errorf("Worker pool[%s] could not be stopped: %s", name, err)
+		}
+	}
+	return stopErr
+}
+
+func (g *poolGroup) Submit(job Job) (bool, error) {
+	return g.poolOf(job).Submit(job)
+}
+
+func (g *poolGroup) NumberOfAvailableWorker() int32 {
+	total := int32(0)
+	for _, pool := range g.pools {
+		total += pool.NumberOfAvailableWorker()
+	}
+	return total
+}
+
+func (g *poolGroup) NumberOfAvailableWorkerByPool() map[string]int32 {
+	available := make(map[string]int32, len(g.pools))
+	for name, pool := range g.pools {
+		available[name] = pool.NumberOfAvailableWorker()
+	}
+	return available
+}
+
+// PoolConf returns the configuration of the default pool.
+func (g *poolGroup) PoolConf() conf.PoolConf {
+	return g.pools[DefaultPoolName].PoolConf()
+}
+
+func (g *poolGroup) PoolConfs() map[string]conf.PoolConf {
+	poolConfs := make(map[string]conf.PoolConf, len(g.pools))
+	for name, pool := range g.pools {
+		poolConfs[name] = pool.PoolConf()
+	}
+	return poolConfs
+}
+
+// Resize resizes the default pool.
+func (g *poolGroup) Resize(poolConf conf.PoolConf) error {
+	return g.ResizePool(DefaultPoolName, poolConf)
+}
+
+func (g *poolGroup) ResizePool(name string, poolConf conf.PoolConf) error {
+	pool, ok := g.pools[name]
+	if !ok {
+		return errors.Errorf("Worker pool[%s] does not exist.", name)
+	}
+	return pool.Resize(poolConf)
+}
+
+func (g *poolGroup) poolOf(job Job) WorkerPool {
+	pooledJob, ok := job.(PooledJob)
+	if !ok || pooledJob.Pool() == "" {
+		return g.pools[DefaultPoolName]
+	}
+
+	pool, ok := g.pools[pooledJob.Pool()]
+	if !ok {
+		logrus.Warnf("Worker pool[%s] of job[%s] does not exist, the job is submitted to the %s pool.", pooledJob.Pool(), job.Id(), DefaultPoolName)
+		return g.pools[DefaultPoolName]
+	}
+	return pool
+}
@@ -0,0 +1,56 @@
+package worker_pool
+
+import (
+	"github.com/atlassian/jec/conf"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type mockPooledJob struct {
+	MockJob
+	pool string
+}
+
+func (j *mockPooledJob) Pool() string {
+	return j.pool
+}
+
+func newTestPoolGroup() (PoolGroup, map[string]*workerPool) {
+	pools := map[string]*workerPool{
+		DefaultPoolName: New(&conf.PoolConf{MaxNumberOfWorker: 2, MinNumberOfWorker: 2}).(*workerPool),
+		"slow":          NewNamed("slow", &conf.PoolConf{MaxNumberOfWorker: 1, MinNumberOfWorker: 1}).(*workerPool),
+	}
+
+	workerPools := make(map[string]WorkerPool, len(pools))
+	for name, pool := range pools {
+		workerPools[name] = pool
+	}
+	poolGroup, _ := NewPoolGroup(workerPools)
+	return poolGroup, pools
+}
+
+func TestNewPoolGroupWithoutDefaultPool(t *testing.T) {
+	_, err := NewPoolGroup(map[string]WorkerPool{"slow": New(testPoolConf)})
+	assert.EqualError(t, err, "Pool group does not contain the default pool.")
+}
+
+func TestPoolGroupRoutesJobsToTheirPools(t *testing.T) {
+	group, pools := newTestPoolGroup()
+	routes := group.(*poolGroup)
+
+	assert.Equal(t, pools["slow"], routes.poolOf(&mockPooledJob{pool: "slow"}))
+	assert.Equal(t, pools[DefaultPoolName], routes.poolOf(&mockPooledJob{pool: ""}))
+	assert.Equal(t, pools[DefaultPoolName], routes.poolOf(&mockPooledJob{pool: "unknown"}))
+	assert.Equal(t, pools[DefaultPoolName], routes.poolOf(NewMockJob()))
+}
+
+func TestPoolGroupAvailability(t *testing.T) {
+	poolGroup, _ := newTestPoolGroup()
+
+	err := poolGroup.Start()
+	assert.Nil(t, err)
+	defer poolGroup.Stop()
+
+	assert.Equal(t, int32(3), poolGroup.NumberOfAvailableWorker())
+	assert.Equal(t, map[string]int32{DefaultPoolName: 2, "slow": 1}, poolGroup.NumberOfAvailableWorkerByPool())
+}
@@ -3,6 +3,7 @@ package worker_pool
 import (
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"runtime/debug"
 	"time"
 )
 
@@ -21,10 +22,13 @@ func newWorker(workerPool *workerPool) worker {
 func (w *worker) doJob(job Job) {
 	defer w.workerPool.AddNumberOfIdleWorker(1)
 	w.workerPool.AddNumberOfIdleWorker(-1)
+	w.workerPool.pendingJobs.Claim()
 
 	logrus.Debugf("Job[%s] is submitted to worker[%s]", job.Id(), w.id.String())
 
-	err := job.Execute() // todo panic recover, stay the pool as working
+	defer w.recoverJob(job)
+
+	err := job.Execute()
 	if err != nil {
 		logrus.Errorf(err.Error())
 		return
@@ -33,25 +37,35 @@ func (w *worker) doJob(job Job) {
 	logrus.Debugf("Job[%s] has been processed by worker[%s].", job.Id(), w.id.String())
 }
 
-func (w *worker) work(initialJob Job) {
+// recoverJob keeps the worker alive when the job panics.
+func (w *worker) recoverJob(job Job) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
 
-	logrus.Debugf("worker[%s] is spawned.", w.id.String())
-	defer w.workerPool.workersWg.Done()
+	poolJobPanics.WithLabelValues(w.workerPool.name).Inc()
+	logrus.Errorf("Job[%s] panicked in worker[%s]: %v\n%s", job.Id(), w.id.String(), recovered, debug.Stack())
 
-	if initialJob != nil {
-		w.doJob(initialJob)
+	if panicHandler, ok := job.(PanicHandler); ok {
+		panicHandler.HandlePanic(recovered)
 	}
+}
 
-	if w.workerPool.poolConf.MinNumberOfWorker == w.workerPool.poolConf.MaxNumberOfWorker {
-		w.runWithFixedNumberOfWorker()
-	} else {
-		w.runWithDynamicNumberOfWorker()
-	}
+func (w *worker) work() {
+
+	logrus.Debugf("worker[%s] is spawned.", w.id.String())
+	defer w.workerPool.workersWg.Done()
+
+	w.run()
 }
 
-func (w *worker) runWithDynamicNumberOfWorker() {
+// run keeps the worker taking jobs until the pool stops. An idle worker leaves the pool after the keep alive time
+// if the pool has more workers than its min, and a worker leaves right after its job if the pool has been shrunk
+// below its current size.
+func (w *worker) run() {
 
-	keepAliveTime := w.workerPool.poolConf.KeepAliveTimeInMillis * time.Millisecond
+	keepAliveTime := w.workerPool.keepAliveTime()
 	ticker := time.NewTicker(keepAliveTime)
 
 	for {
@@ -72,6 +86,12 @@ func (w *worker) runWithDynamicNumberOfWorker() {
 
 			w.doJob(job)
 
+			if w.workerPool.CompareAndDecrementExcessWorker() {
+				logrus.Debugf("worker [%s] has left the shrunk pool.", w.id.String())
+				return
+			}
+
+			keepAliveTime = w.workerPool.keepAliveTime()
 			ticker = time.NewTicker(keepAliveTime)
 		case <-ticker.C:
 			ticker.Stop()
@@ -81,28 +101,8 @@ func (w *worker) runWithDynamicNumberOfWorker() {
 				return
 			}
 
+			keepAliveTime = w.workerPool.keepAliveTime()
 			ticker = time.NewTicker(keepAliveTime)
-
-		}
-	}
-}
-
-func (w *worker) runWithFixedNumberOfWorker() {
-
-	for {
-		select {
-		case <-w.workerPool.quitNow:
-			logrus.Debugf("worker [%s] has stopped working.", w.id.String())
-			w.workerPool.AddNumberOfCurrentAndIdleWorker(-1)
-			return
-		case job, isOpen := <-w.workerPool.jobQueue:
-			if !isOpen {
-				w.workerPool.AddNumberOfCurrentAndIdleWorker(-1)
-				logrus.Debugf("worker[%s] has done its job.", w.id.String())
-				return
-			}
-
-			w.doJob(job)
 		}
 	}
 }
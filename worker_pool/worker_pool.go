@@ -15,25 +15,32 @@ const (
 	queueSize                = 0
 	keepAliveTimeInMillis    = 6000
 	monitoringPeriodInMillis = 15000
+	agingIntervalInMillis    = 10000
 )
 
+const DefaultPoolName = conf.DefaultPoolName
+
 type WorkerPool interface {
 	Start() error
 	Stop() error
 	Submit(job Job) (bool, error)
 	NumberOfAvailableWorker() int32
+	PoolConf() conf.PoolConf
+	Resize(poolConf conf.PoolConf) error
 }
 
 type workerPool struct {
+	name     string
 	poolConf *conf.PoolConf
 
 	numberOfCurrentWorker int32
 	numberOfIdleWorker    int32
 
-	jobQueue  chan Job
-	quit      chan struct{}
-	quitNow   chan struct{}
-	isRunning bool
+	jobQueue    chan Job
+	pendingJobs *jobQueue
+	quit        chan struct{}
+	quitNow     chan struct{}
+	isRunning   bool
 
 	workersWg        *sync.WaitGroup
 	startStopMu      *sync.RWMutex
@@ -41,6 +48,28 @@ type workerPool struct {
 }
 
 func New(poolConf *conf.PoolConf) WorkerPool {
+	return NewNamed(DefaultPoolName, poolConf)
+}
+
+func NewNamed(name string, poolConf *conf.PoolConf) WorkerPool {
+	ApplyDefaults(poolConf)
+
+	return &workerPool{
+		name:             name,
+		jobQueue:         make(chan Job),
+		pendingJobs:      newJobQueue(poolConf.AgingIntervalInMillis * time.Millisecond),
+		quit:             make(chan struct{}),
+		quitNow:          make(chan struct{}),
+		poolConf:         poolConf,
+		workersWg:        &sync.WaitGroup{},
+		startStopMu:      &sync.RWMutex{},
+		numberOfWorkerMu: &sync.RWMutex{},
+		isRunning:        false,
+	}
+}
+
+// ApplyDefaults replaces the missing or invalid values of the pool configuration with the default ones.
+func ApplyDefaults(poolConf *conf.PoolConf) {
 
 	if poolConf.MaxNumberOfWorker <= 0 {
 		logrus.Infof("Max number of workers should be greater than zero, default value[%d] is set.", maxNumberOfWorker)
@@ -72,15 +101,9 @@ func New(poolConf *conf.PoolConf) WorkerPool {
 		poolConf.MonitoringPeriodInMillis = monitoringPeriodInMillis
 	}
 
-	return &workerPool{
-		jobQueue:         make(chan Job, poolConf.QueueSize),
-		quit:             make(chan struct{}),
-		quitNow:          make(chan struct{}),
-		poolConf:         poolConf,
-		workersWg:        &sync.WaitGroup{},
-		startStopMu:      &sync.RWMutex{},
-		numberOfWorkerMu: &sync.RWMutex{},
-		isRunning:        false,
+	if poolConf.AgingIntervalInMillis <= 0 {
+		logrus.Infof("Aging interval of the pool should be greater than zero, default value[%d ms.] is set.", agingIntervalInMillis)
+		poolConf.AgingIntervalInMillis = agingIntervalInMillis
 	}
 }
 
@@ -128,26 +151,96 @@ func (wp *workerPool) Submit(job Job) (isSubmitted bool, err error) {
 
 	logrus.Debugf("Job[%s] is being submitted", job.Id())
 
-	select {
-	case wp.jobQueue <- job:
-		return true, nil
-	default:
-		if wp.poolConf.MaxNumberOfWorker == wp.poolConf.MinNumberOfWorker {
-			return false, nil
-		}
+	poolConf := wp.PoolConf()
 
-		if wp.CompareAndIncrementCurrentWorker() {
-			wp.workersWg.Add(1)
-			go func() {
-				worker := newWorker(wp)
-				worker.work(job)
-			}()
-			return true, nil
-		}
+	if wp.tryPush(job, poolConf.QueueSize) {
+		return true, nil
+	}
 
-		logrus.Debugf("Job[%s] could not be submitted", job.Id())
+	if poolConf.MaxNumberOfWorker == poolConf.MinNumberOfWorker {
 		return false, nil
 	}
+
+	if wp.CompareAndIncrementCurrentWorker() {
+		// the new worker takes the job with the highest priority which is not necessarily the submitted one
+		wp.pendingJobs.Push(job)
+		wp.workersWg.Add(1)
+		go func() {
+			worker := newWorker(wp)
+			worker.work()
+		}()
+		return true, nil
+	}
+
+	logrus.Debugf("Job[%s] could not be submitted", job.Id())
+	return false, nil
+}
+
+// tryPush pushes the job if the queue or an idle worker has room for it. An idle worker takes the job as soon as the
+// dispatcher hands it over, so that the idle workers count as queue slots, the jobs which are handed over but not taken
+// yet still count as pending until their workers are not idle. The idle workers do not leave while jobs are waiting,
+// see CompareAndDecrementCurrentWorker, so the idle worker which makes room for the job cannot leave before taking it.
+func (wp *workerPool) tryPush(job Job, queueSize int32) bool {
+	wp.numberOfWorkerMu.Lock()
+	defer wp.numberOfWorkerMu.Unlock()
+	return wp.pendingJobs.TryPush(job, int(queueSize+wp.numberOfIdleWorker))
+}
+
+func (wp *workerPool) PoolConf() conf.PoolConf {
+	wp.numberOfWorkerMu.RLock()
+	defer wp.numberOfWorkerMu.RUnlock()
+	return *wp.poolConf
+}
+
+func (wp *workerPool) keepAliveTime() time.Duration {
+	wp.numberOfWorkerMu.RLock()
+	defer wp.numberOfWorkerMu.RUnlock()
+	return wp.poolConf.KeepAliveTimeInMillis * time.Millisecond
+}
+
+// Resize changes the worker limits, the keep alive time and the queue size of the pool without stopping it.
+// When the pool shrinks, the excess workers leave after finishing their jobs, so no running job is interrupted.
+func (wp *workerPool) Resize(poolConf conf.PoolConf) error {
+
+	if poolConf.MaxNumberOfWorker <= 0 {
+		return errors.New("Max number of workers should be greater than zero.")
+	}
+	if poolConf.MinNumberOfWorker < 0 {
+		return errors.New("Min number of workers cannot be lesser than zero.")
+	}
+	if poolConf.MinNumberOfWorker > poolConf.MaxNumberOfWorker {
+		return errors.New("Min number of workers cannot be greater than max number of workers.")
+	}
+	if poolConf.QueueSize < 0 {
+		return errors.New("Queue size of the pool cannot be lesser than zero.")
+	}
+
+	defer wp.startStopMu.RUnlock()
+	wp.startStopMu.RLock()
+
+	wp.numberOfWorkerMu.Lock()
+	wp.poolConf.MaxNumberOfWorker = poolConf.MaxNumberOfWorker
+	wp.poolConf.MinNumberOfWorker = poolConf.MinNumberOfWorker
+	wp.poolConf.QueueSize = poolConf.QueueSize
+	if poolConf.KeepAliveTimeInMillis > 0 {
+		wp.poolConf.KeepAliveTimeInMillis = poolConf.KeepAliveTimeInMillis
+	}
+
+	missingWorkers := int32(0)
+	if wp.isRunning && wp.numberOfCurrentWorker < wp.poolConf.MinNumberOfWorker {
+		missingWorkers = wp.poolConf.MinNumberOfWorker - wp.numberOfCurrentWorker
+	}
+	resizedConf := *wp.poolConf
+	wp.numberOfWorkerMu.Unlock()
+
+	if missingWorkers > 0 {
+		wp.addInitialWorkers(missingWorkers)
+	}
+
+	logrus.Infof("Worker pool[%s] is resized; Min Worker: %d, Max Worker: %d, Queue Size: %d, Keep Alive Time: %d ms.",
+		wp.name, resizedConf.MinNumberOfWorker, resizedConf.MaxNumberOfWorker, resizedConf.QueueSize, resizedConf.KeepAliveTimeInMillis)
+	wp.updateMetrics()
+	return nil
 }
 
 func (wp *workerPool) monitorMetrics(monitoringPeriodInMillis time.Duration) {
@@ -155,14 +248,17 @@ func (wp *workerPool) monitorMetrics(monitoringPeriodInMillis time.Duration) {
 		return
 	}
 
-	logrus.Infof("Worker pool is running with; Min Worker: %d, Max Worker: %d, Queue Size: %d", wp.poolConf.MinNumberOfWorker, wp.poolConf.MaxNumberOfWorker, cap(wp.jobQueue))
+	poolConf := wp.PoolConf()
+	logrus.Infof("Worker pool[%s] is running with; Min Worker: %d, Max Worker: %d, Queue Size: %d", wp.name, poolConf.MinNumberOfWorker, poolConf.MaxNumberOfWorker, poolConf.QueueSize)
+	wp.updateMetrics()
 
 	ticker := time.NewTicker(monitoringPeriodInMillis * time.Millisecond)
 
 	for {
 		select {
 		case <-ticker.C:
-			logrus.Debugf("Current Worker: %d, Idle Worker: %d, Queue Size: %d, Queue load: %d", wp.NumberOfCurrentWorker(), wp.numberOfIdleWorker, cap(wp.jobQueue), len(wp.jobQueue))
+			logrus.Debugf("Worker pool[%s]; Current Worker: %d, Idle Worker: %d, Queue Size: %d, Queue load: %d", wp.name, wp.NumberOfCurrentWorker(), wp.NumberOfIdleWorker(), wp.PoolConf().QueueSize, wp.pendingJobs.Len())
+			wp.updateMetrics()
 		case <-wp.quit:
 			ticker.Stop()
 			logrus.Infof("Monitor metrics has stopped.")
@@ -171,6 +267,13 @@ func (wp *workerPool) monitorMetrics(monitoringPeriodInMillis time.Duration) {
 	}
 }
 
+func (wp *workerPool) updateMetrics() {
+	poolMaxWorkers.WithLabelValues(wp.name).Set(float64(wp.PoolConf().MaxNumberOfWorker))
+	poolCurrentWorkers.WithLabelValues(wp.name).Set(float64(wp.NumberOfCurrentWorker()))
+	poolIdleWorkers.WithLabelValues(wp.name).Set(float64(wp.NumberOfIdleWorker()))
+	poolQueuedJobs.WithLabelValues(wp.name).Set(float64(wp.pendingJobs.Len()))
+}
+
 func (wp *workerPool) addInitialWorkers(num int32) {
 
 	wp.AddNumberOfCurrentAndIdleWorker(num)
@@ -178,21 +281,39 @@ func (wp *workerPool) addInitialWorkers(num int32) {
 
 	for i := int32(0); i < num; i++ {
 		worker := newWorker(wp)
-		go worker.work(nil)
+		go worker.work()
 	}
 }
 
+// run dispatches the pending jobs to the workers in the order of their priorities.
 func (wp *workerPool) run() {
 
 	logrus.Infof("Worker pool has started to run.")
 
 	for {
+		pending, ok := wp.pendingJobs.Pop()
+		if !ok {
+			select {
+			case <-wp.pendingJobs.notEmpty:
+				continue
+			case <-wp.quit:
+				if wp.pendingJobs.Len() > 0 {
+					continue // no job is submitted after quit, the remaining ones are still dispatched
+				}
+				logrus.Infof("Worker pool is waiting that all workers are done.")
+				close(wp.jobQueue)
+				wp.workersWg.Wait()
+				return
+			case <-wp.quitNow:
+				logrus.Infof("Worker pool has stopped immediately.")
+				return
+			}
+		}
+
 		select {
-		case <-wp.quit:
-			logrus.Infof("Worker pool is waiting that all workers are done.")
-			close(wp.jobQueue)
-			wp.workersWg.Wait()
-			return
+		case wp.jobQueue <- pending.job:
+		case <-wp.pendingJobs.notEmpty:
+			wp.pendingJobs.Requeue(pending) // a job with a higher priority may have been submitted meanwhile
 		case <-wp.quitNow:
 			logrus.Infof("Worker pool has stopped immediately.")
 			return
@@ -242,10 +363,24 @@ func (wp *workerPool) CompareAndIncrementCurrentWorker() bool {
 	return false
 }
 
+// CompareAndDecrementCurrentWorker lets an idle worker leave when the pool has more workers than its min and no job
+// is waiting for a worker.
 func (wp *workerPool) CompareAndDecrementCurrentWorker() bool {
 	wp.numberOfWorkerMu.Lock()
 	defer wp.numberOfWorkerMu.Unlock()
-	if wp.numberOfCurrentWorker > wp.poolConf.MinNumberOfWorker {
+	if wp.numberOfCurrentWorker > wp.poolConf.MinNumberOfWorker && wp.pendingJobs.Waiting() == 0 {
+		wp.numberOfCurrentWorker--
+		wp.numberOfIdleWorker--
+		return true
+	}
+	return false
+}
+
+// CompareAndDecrementExcessWorker lets a worker leave when the pool has more workers than its max after a resize.
+func (wp *workerPool) CompareAndDecrementExcessWorker() bool {
+	wp.numberOfWorkerMu.Lock()
+	defer wp.numberOfWorkerMu.Unlock()
+	if wp.numberOfCurrentWorker > wp.poolConf.MaxNumberOfWorker {
 		wp.numberOfCurrentWorker--
 		wp.numberOfIdleWorker--
 		return true
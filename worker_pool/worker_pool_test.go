@@ -45,6 +45,7 @@ func TestValidateNewWorkerPool(t *testing.T) {
 		-1,
 		-1,
 		-1,
+		-1,
 	}
 	pool := New(configuration).(*workerPool)
 
@@ -53,6 +54,7 @@ func TestValidateNewWorkerPool(t *testing.T) {
 	assert.Equal(t, int32(queueSize), pool.poolConf.QueueSize)
 	assert.Equal(t, time.Duration(keepAliveTimeInMillis), pool.poolConf.KeepAliveTimeInMillis)
 	assert.Equal(t, time.Duration(monitoringPeriodInMillis), pool.poolConf.MonitoringPeriodInMillis)
+	assert.Equal(t, time.Duration(agingIntervalInMillis), pool.poolConf.AgingIntervalInMillis)
 }
 
 func TestValidateWorkerNumbersNewWorkerPool(t *testing.T) {
@@ -62,6 +64,7 @@ func TestValidateWorkerNumbersNewWorkerPool(t *testing.T) {
 		-1,
 		0,
 		0,
+		0,
 	}
 	pool := New(configuration).(*workerPool)
 
@@ -70,6 +73,7 @@ func TestValidateWorkerNumbersNewWorkerPool(t *testing.T) {
 	assert.Equal(t, int32(queueSize), pool.poolConf.QueueSize)
 	assert.Equal(t, time.Duration(keepAliveTimeInMillis), pool.poolConf.KeepAliveTimeInMillis)
 	assert.Equal(t, time.Duration(monitoringPeriodInMillis), pool.poolConf.MonitoringPeriodInMillis)
+	assert.Equal(t, time.Duration(agingIntervalInMillis), pool.poolConf.AgingIntervalInMillis)
 }
 
 func TestStartPool(t *testing.T) {
@@ -105,6 +109,108 @@ func TestStartPool(t *testing.T) {
 	assert.Equal(t, int32(1000), executeJobCallCount)
 }
 
+func TestIdleWorkerDoesNotLeaveBeforeTakingAcceptedJob(t *testing.T) {
+
+	pool := New(&conf.PoolConf{MaxNumberOfWorker: 1, MinNumberOfWorker: 0, KeepAliveTimeInMillis: 5}).(*workerPool)
+
+	// the pool is started without its dispatcher, so that the accepted job waits to be handed over
+	pool.isRunning = true
+	pool.addInitialWorkers(1)
+
+	executed := make(chan struct{})
+	job := NewMockJob()
+	job.ExecuteFunc = func() error {
+		close(executed)
+		return nil
+	}
+
+	isSubmitted, err := pool.Submit(job)
+	assert.Nil(t, err)
+	assert.True(t, isSubmitted)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), pool.NumberOfCurrentWorker())
+
+	go pool.run()
+	select {
+	case <-executed:
+	case <-time.After(time.Second):
+		assert.Fail(t, "Accepted job is not executed.")
+	}
+
+	for pool.NumberOfCurrentWorker() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Nil(t, pool.Stop())
+}
+
+func TestResizePoolGrowsMinWorkers(t *testing.T) {
+
+	pool := New(&conf.PoolConf{MaxNumberOfWorker: 2, MinNumberOfWorker: 1, KeepAliveTimeInMillis: 100}).(*workerPool)
+
+	err := pool.Start()
+	assert.Nil(t, err)
+	defer pool.Stop()
+
+	err = pool.Resize(conf.PoolConf{MaxNumberOfWorker: 4, MinNumberOfWorker: 3, QueueSize: 5})
+
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), pool.NumberOfCurrentWorker())
+	assert.Equal(t, int32(3), pool.NumberOfIdleWorker())
+
+	poolConf := pool.PoolConf()
+	assert.Equal(t, int32(4), poolConf.MaxNumberOfWorker)
+	assert.Equal(t, int32(3), poolConf.MinNumberOfWorker)
+	assert.Equal(t, int32(5), poolConf.QueueSize)
+	assert.Equal(t, time.Duration(100), poolConf.KeepAliveTimeInMillis)
+}
+
+func TestResizePoolShrinksAfterRunningJobs(t *testing.T) {
+
+	pool := New(&conf.PoolConf{MaxNumberOfWorker: 3, MinNumberOfWorker: 3, QueueSize: 1}).(*workerPool)
+
+	err := pool.Start()
+	assert.Nil(t, err)
+	defer pool.Stop()
+
+	release := make(chan struct{})
+	var finishedJobs int32 = 0
+	for i := 0; i < 3; i++ {
+		job := NewMockJob()
+		job.ExecuteFunc = func() error {
+			<-release
+			atomic.AddInt32(&finishedJobs, 1)
+			return nil
+		}
+		for isSubmitted, _ := pool.Submit(job); !isSubmitted; isSubmitted, _ = pool.Submit(job) {
+		}
+	}
+
+	for pool.NumberOfIdleWorker() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	err = pool.Resize(conf.PoolConf{MaxNumberOfWorker: 1, MinNumberOfWorker: 1, QueueSize: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), pool.NumberOfCurrentWorker())
+
+	close(release)
+	for pool.NumberOfCurrentWorker() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&finishedJobs))
+}
+
+func TestResizePoolWithInvalidConf(t *testing.T) {
+
+	pool := New(&conf.PoolConf{MaxNumberOfWorker: 2, MinNumberOfWorker: 1}).(*workerPool)
+
+	err := pool.Resize(conf.PoolConf{MaxNumberOfWorker: 1, MinNumberOfWorker: 2})
+
+	assert.EqualError(t, err, "Min number of workers cannot be greater than max number of workers.")
+	assert.Equal(t, int32(2), pool.PoolConf().MaxNumberOfWorker)
+}
+
 func BenchmarkWorkerPool(b *testing.B) {
 
 	jobSize1 := 500
@@ -137,6 +243,7 @@ func BenchmarkWorkerPool(b *testing.B) {
 				queueSize,
 				keepAliveTimeInMillis,
 				monitoringPeriodInMillis,
+				agingIntervalInMillis,
 			},
 		)
 
@@ -206,6 +313,7 @@ func BenchmarkWorkerPoolWithComparableFixedWorkerSize(b *testing.B) {
 				queueSize,
 				keepAliveTimeInMillis,
 				monitoringPeriodInMillis,
+				agingIntervalInMillis,
 			},
 		)
 
@@ -0,0 +1,58 @@
+package worker_pool
+
+import (
+	"github.com/atlassian/jec/conf"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+type mockPanickingJob struct {
+	MockJob
+	recovered interface{}
+	wg        *sync.WaitGroup
+}
+
+func (j *mockPanickingJob) HandlePanic(recovered interface{}) {
+	j.recovered = recovered
+	j.wg.Done()
+}
+
+func TestWorkerRecoversPanickingJob(t *testing.T) {
+	pool := New(&conf.PoolConf{MaxNumberOfWorker: 1, MinNumberOfWorker: 1, QueueSize: 2}).(*workerPool)
+
+	err := pool.Start()
+	assert.Nil(t, err)
+
+	wg := &sync.WaitGroup{}
+	panickingJob := &mockPanickingJob{wg: wg}
+	panickingJob.ExecuteFunc = func() error {
+		panic("unexpected")
+	}
+
+	executed := false
+	job := NewMockJob()
+	job.ExecuteFunc = func() error {
+		executed = true
+		wg.Done()
+		return nil
+	}
+
+	wg.Add(2)
+	isSubmitted, err := pool.Submit(panickingJob)
+	assert.True(t, isSubmitted)
+	assert.Nil(t, err)
+
+	isSubmitted, err = pool.Submit(job)
+	assert.True(t, isSubmitted)
+	assert.Nil(t, err)
+	wg.Wait()
+
+	err = pool.Stop()
+	assert.Nil(t, err)
+
+	assert.Equal(t, "unexpected", panickingJob.recovered)
+	assert.True(t, executed)
+	assert.Equal(t, int32(0), pool.NumberOfCurrentWorker())
+	assert.Equal(t, int32(0), pool.NumberOfIdleWorker())
+}